@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/copyfile"
+)
+
+// What to do with the duplicates of a group, chosen with -action.
+const (
+	OpDelete     = "delete"     // remove the file
+	OpQuarantine = "quarantine" // move it under -quarantine, keeping its path relative to -src
+	OpHardlink   = "hardlink"   // replace it with a hard link to the survivor
+)
+
+// Action statuses in the log.
+const (
+	StatusPlanned = "planned" // listed by a dry run
+	StatusDone    = "done"
+	StatusSkipped = "skipped" // not safe to do, e.g. the file changed since it was hashed
+	StatusFailed  = "failed"
+	StatusUndone  = "undone"
+)
+
+// Action is one line of the action log: what was, or would be, done to one
+// duplicate, with what is needed to check it first and undo it later.
+type Action struct {
+	Op      string      `json:"op"`
+	Path    string      `json:"path"`
+	Keep    string      `json:"keep"` // the survivor of the group, holding the same content
+	Hash    string      `json:"sha256"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	MovedTo string      `json:"moved_to,omitempty"` // for OpQuarantine
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"` // why it was skipped or failed
+}
+
+// planActions lists op for every file of every group but its survivor. Paths
+// are made absolute, so the log can be replayed from any folder. Symlinks are
+// never the survivor nor acted on, and files that are the survivor under
+// another name (hard links to it) are left alone.
+func planActions(groups [][]FileInfo, op string, keep KeepPolicy, quarantine string) []Action {
+	var actions []Action
+	for _, files := range groups {
+		files = slices.DeleteFunc(slices.Clone(files), func(f FileInfo) bool { return isSymlink(f.Path) })
+		if len(files) < 2 {
+			continue
+		}
+		survivor := files[keep.Survivor(files)]
+		for _, f := range files {
+			if f.Path == survivor.Path || sameFile(f.Path, survivor.Path) {
+				continue
+			}
+			a := Action{
+				Op:      op,
+				Path:    absolute(f.Path),
+				Keep:    absolute(survivor.Path),
+				Hash:    f.Hash,
+				Size:    f.Size,
+				Mode:    f.Mode,
+				ModTime: f.ModTime,
+				Status:  StatusPlanned,
+			}
+			if op == OpQuarantine {
+				rel, _ := filepath.Rel(keep.Root, f.Path)
+				a.MovedTo = absolute(filepath.Join(quarantine, rel))
+			}
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+func absolute(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// ActionLog appends actions to a JSON-lines file as they happen.
+type ActionLog struct {
+	Path string
+	f    *os.File
+}
+
+// CreateActionLog creates the log at path, which must not exist.
+func CreateActionLog(path string) (*ActionLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("creating action log: %w", err)
+	}
+	return &ActionLog{Path: path, f: f}, nil
+}
+
+// DefaultLogPath is dedup-actions-<time>.jsonl in the current folder.
+func DefaultLogPath(now time.Time) string {
+	return fmt.Sprintf("dedup-actions-%s.jsonl", now.Format("2006-01-02-15-04-05"))
+}
+
+// Write appends a and syncs it, so the log is complete up to any crash.
+func (l *ActionLog) Write(a Action) error {
+	line, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing action log: %w", err)
+	}
+	return l.f.Sync()
+}
+
+func (l *ActionLog) Close() error {
+	return l.f.Close()
+}
+
+// ReadActionLog loads the actions of a log written by an earlier run.
+func ReadActionLog(path string) ([]Action, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var actions []Action
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var a Action
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, n, err)
+		}
+		actions = append(actions, a)
+	}
+	return actions, sc.Err()
+}
+
+// apply carries out a planned action. With check set, as when replaying a
+// log, the file and the survivor are first re-hashed to make sure neither
+// changed since the plan was made. With dryRun it only checks.
+func apply(a Action, check, dryRun bool) Action {
+	if check {
+		if reason := changed(a.Path, a.Hash); reason != "" {
+			return skip(a, reason)
+		}
+		if reason := changed(a.Keep, a.Hash); reason != "" {
+			return skip(a, "survivor "+reason)
+		}
+	} else if !exists(a.Keep) {
+		return skip(a, "survivor is missing")
+	}
+	if isSymlink(a.Path) || isSymlink(a.Keep) {
+		return skip(a, "symlinks are not deduplicated")
+	}
+	if a.Path == a.Keep || a.Op != OpHardlink && sameFile(a.Path, a.Keep) {
+		return skip(a, "file is its own survivor")
+	}
+	if dryRun {
+		a.Status = StatusPlanned
+		return a
+	}
+
+	var err error
+	switch a.Op {
+	case OpDelete:
+		err = os.Remove(a.Path)
+	case OpQuarantine:
+		err = moveFile(a.Path, a.MovedTo)
+	case OpHardlink:
+		err = hardlink(a.Keep, a.Path)
+	default:
+		err = fmt.Errorf("unknown action %q", a.Op)
+	}
+	return finish(a, StatusDone, err)
+}
+
+// undo reverses a done action, last first. A deleted or hard-linked file is
+// restored as a copy of its survivor, which has the same content, with the
+// file's own permissions and modification time.
+func undo(a Action, dryRun bool) Action {
+	if a.Status != StatusDone {
+		return skip(a, "was not done")
+	}
+	var err error
+	switch a.Op {
+	case OpQuarantine:
+		if reason := changed(a.MovedTo, a.Hash); reason != "" {
+			return skip(a, "quarantined copy "+reason)
+		}
+		if exists(a.Path) {
+			return skip(a, "a file is back at its path")
+		}
+		if dryRun {
+			return finish(a, StatusPlanned, nil)
+		}
+		err = moveFile(a.MovedTo, a.Path)
+	case OpDelete, OpHardlink:
+		if reason := changed(a.Keep, a.Hash); reason != "" {
+			return skip(a, "survivor "+reason)
+		}
+		if a.Op == OpDelete && exists(a.Path) {
+			return skip(a, "a file is back at its path")
+		}
+		if a.Op == OpHardlink && !sameFile(a.Path, a.Keep) {
+			return skip(a, "no longer a link to the survivor")
+		}
+		if dryRun {
+			return finish(a, StatusPlanned, nil)
+		}
+		err = restoreCopy(a)
+	default:
+		err = fmt.Errorf("unknown action %q", a.Op)
+	}
+	return finish(a, StatusUndone, err)
+}
+
+func skip(a Action, reason string) Action {
+	a.Status, a.Error = StatusSkipped, reason
+	return a
+}
+
+func finish(a Action, status string, err error) Action {
+	a.Status, a.Error = status, ""
+	if err != nil {
+		a.Status, a.Error = StatusFailed, err.Error()
+	}
+	return a
+}
+
+// changed says why the file at path no longer holds content hash, or ""
+// if it does.
+func changed(path, hash string) string {
+	got, err := calculateFileHash(path)
+	switch {
+	case os.IsNotExist(err):
+		return "is missing"
+	case err != nil:
+		return fmt.Sprintf("could not be read: %v", err)
+	case got != hash:
+		return "changed since it was hashed"
+	}
+	return ""
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func isSymlink(path string) bool {
+	fi, err := os.Lstat(path)
+	return err == nil && fi.Mode()&os.ModeSymlink != 0
+}
+
+func sameFile(a, b string) bool {
+	fa, errA := os.Stat(a)
+	fb, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(fa, fb)
+}
+
+// moveFile renames src to dst, copying then deleting when they are on
+// different disks. dst must not exist.
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if exists(dst) {
+		return fmt.Errorf("%s: %w", dst, fs.ErrExist)
+	}
+	err := os.Rename(src, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		if err := copyfile.Copy(src, dst); err != nil {
+			return err
+		}
+		return os.Remove(src)
+	}
+	return err
+}
+
+// hardlink replaces path with a hard link to keep. The link is made under a
+// temporary name and renamed over path, so path is never missing.
+func hardlink(keep, path string) error {
+	if sameFile(keep, path) {
+		return nil
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".dedup-link")
+	os.Remove(tmp)
+	if err := os.Link(keep, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// restoreCopy puts a copy of the survivor back at the action's path, with
+// the permissions and modification time the file had.
+func restoreCopy(a Action) error {
+	tmp := filepath.Join(filepath.Dir(a.Path), "."+filepath.Base(a.Path)+".dedup-restore")
+	os.Remove(tmp)
+	if err := os.MkdirAll(filepath.Dir(a.Path), 0755); err != nil {
+		return err
+	}
+	if err := copyfile.Copy(a.Keep, tmp); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, a.Mode.Perm()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chtimes(tmp, a.ModTime, a.ModTime); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// a hard link is replaced; a deleted file's path was checked to be free
+	if err := os.Rename(tmp, a.Path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// runActions runs do on every action, printing each and writing it to log
+// (when not nil). It returns how many ended in each status.
+func runActions(actions []Action, do func(Action) Action, dryRun, undoing bool, log *ActionLog) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, a := range actions {
+		a = do(a)
+		counts[a.Status]++
+		fmt.Println(describe(a, dryRun, undoing))
+		if log != nil {
+			if err := log.Write(a); err != nil {
+				return counts, err
+			}
+		}
+	}
+	return counts, nil
+}
+
+// describe renders an action for the terminal, e.g.
+// "deleted 2024/03/IMG_1234 copy.JPG  (kept 2024/03/IMG_1234.JPG)".
+// undoing describes it as undo saw it.
+func describe(a Action, dryRun, undoing bool) string {
+	verbs := map[string][2]string{
+		OpDelete:     {"would delete", "deleted"},
+		OpQuarantine: {"would move", "moved"},
+		OpHardlink:   {"would link", "linked"},
+	}
+	verb := verbs[a.Op][1]
+	if dryRun {
+		verb = verbs[a.Op][0]
+	}
+	switch {
+	case undoing && a.Status == StatusPlanned:
+		return fmt.Sprintf("would restore %s", a.Path)
+	case a.Status == StatusSkipped:
+		return fmt.Sprintf("skipped %s: %s", a.Path, a.Error)
+	case a.Status == StatusFailed:
+		return fmt.Sprintf("failed %s: %s", a.Path, a.Error)
+	case a.Status == StatusUndone:
+		return fmt.Sprintf("restored %s", a.Path)
+	}
+	if a.Op == OpQuarantine {
+		return fmt.Sprintf("%s %s → %s", verb, a.Path, a.MovedTo)
+	}
+	return fmt.Sprintf("%s %s  (kept %s)", verb, a.Path, a.Keep)
+}
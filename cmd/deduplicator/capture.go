@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg" // for image.DecodeConfig
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/media"
+)
+
+// captureFormats maps the extensions -captures compares to their format, as
+// -prefer-format names it. RAW files are left out: a RAW and its camera JPEG
+// are meant to be kept together.
+var captureFormats = map[string]string{"heic": "heic", "heif": "heic", "jpg": "jpg", "jpeg": "jpg", "tif": "tif", "tiff": "tif"}
+
+// parseFormat checks a -prefer-format name, e.g. "HEIC" or ".jpeg", and
+// returns the format it means.
+func parseFormat(name string) (string, error) {
+	if f, ok := captureFormats[strings.ToLower(strings.TrimPrefix(name, "."))]; ok {
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown -prefer-format %q: want heic, jpg or tif", name)
+}
+
+// aspectTolerance is how far apart two aspect ratios may be, as a fraction,
+// and still be the same shot: exports round their scaled sizes.
+const aspectTolerance = 0.01
+
+// Capture is what one press of the shutter leaves in every encoding of it.
+type Capture struct {
+	Time          time.Time // EXIF capture time, to the fraction of a second
+	Camera        string    // make and model
+	Width, Height int       // upright, in pixels
+}
+
+// key is the capture time as the camera's clock showed it and the camera,
+// which encodings of one shot share; the aspect ratio is compared apart.
+func (c Capture) key() string {
+	return c.Time.Format("2006-01-02T15:04:05.000000000") + "|" + c.Camera
+}
+
+func (c Capture) sameShape(o Capture) bool {
+	a, b := float64(c.Width*o.Height), float64(o.Width*c.Height)
+	return a >= b*(1-aspectTolerance) && a <= b*(1+aspectTolerance)
+}
+
+// CaptureFile is a file of a same-capture group.
+type CaptureFile struct {
+	FileInfo
+	Capture   Capture
+	Preferred bool // in the -prefer-format encoding
+}
+
+// readCapture reads the capture time, camera and upright dimensions of path.
+// ok is false when the file does not record all of them, or records its
+// time only to the second, which a burst of shots can share.
+func readCapture(path string) (c Capture, ok bool) {
+	x, err := media.DecodeExif(path)
+	if err != nil || !media.HasSubSecTime(x) {
+		return c, false
+	}
+	if c.Time, _, err = media.ExifCaptureTime(x, time.UTC); err != nil {
+		return c, false
+	}
+	cam := media.CameraOf(x)
+	if cam.Make == "" || cam.Model == "" {
+		return c, false
+	}
+	c.Camera = strings.TrimSpace(cam.Make + " " + cam.Model)
+	c.Width, c.Height = media.Dimensions(x)
+	if c.Width == 0 {
+		// exports often drop the pixel dimension tags; JPEGs say it anyway
+		c.Width, c.Height = decodedSize(path, media.Orientation(x))
+	}
+	return c, c.Width > 0 && c.Height > 0
+}
+
+// decodedSize reads an image's size from its header, turned upright by
+// orientation, or 0, 0 when it cannot.
+func decodedSize(path string, orientation int) (width, height int) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0
+	}
+	if orientation >= 5 {
+		return cfg.Height, cfg.Width
+	}
+	return cfg.Width, cfg.Height
+}
+
+// findCaptures reads the EXIF of the photos among files on pool and groups
+// those taken at the same moment, to the fraction of a second, by the same
+// camera, in the same aspect ratio: one shot saved in different encodings,
+// e.g. IMG_1234.HEIC and an exported IMG_1234.JPG. Groups in a single
+// format, such as a JPEG and an edited copy of it, are left out, as are
+// groups whose files all hold the same bytes: exact duplicates among dups,
+// reported already. Files in the prefer format (from parseFormat, "" for none) come
+// first in each group, then most pixels, then largest file, then path.
+func findCaptures(files []FileInfo, dups [][]FileInfo, prefer string, pool *Pool) ([][]CaptureFile, Stage) {
+	start := time.Now()
+	var jobs []*FileInfo
+	for i := range files {
+		if formatOf(files[i].Path) != "" {
+			jobs = append(jobs, &files[i])
+		}
+	}
+	index := make(map[*FileInfo]int, len(jobs))
+	for i, f := range jobs {
+		index[f] = i
+	}
+	captures := make([]Capture, len(jobs))
+	found := make([]bool, len(jobs))
+	pool.Stage = "captures"
+	pool.Hash(jobs, func(f *FileInfo) (string, int64, error) {
+		i := index[f]
+		captures[i], found[i] = readCapture(f.Path)
+		return "", 0, nil
+	})
+
+	// by time and camera, in walk order, then by shape
+	byKey := make(map[string][][]CaptureFile)
+	var order []string
+	for i, f := range jobs {
+		if !found[i] {
+			continue
+		}
+		cf := CaptureFile{FileInfo: *f, Capture: captures[i], Preferred: prefer != "" && formatOf(f.Path) == prefer}
+		k := cf.Capture.key()
+		if _, ok := byKey[k]; !ok {
+			order = append(order, k)
+		}
+		shapes := byKey[k]
+		j := 0
+		for j < len(shapes) && !shapes[j][0].Capture.sameShape(cf.Capture) {
+			j++
+		}
+		if j == len(shapes) {
+			shapes = append(shapes, nil)
+		}
+		shapes[j] = append(shapes[j], cf)
+		byKey[k] = shapes
+	}
+
+	content := make(map[string]string)
+	for _, group := range dups {
+		for _, f := range group {
+			content[f.Path] = f.Hash
+		}
+	}
+	var groups [][]CaptureFile
+	for _, k := range order {
+		for _, g := range byKey[k] {
+			if len(g) < 2 || oneFormat(g) || sameBytes(g, content) {
+				continue
+			}
+			sort.Slice(g, func(a, b int) bool {
+				fa, fb := g[a], g[b]
+				if fa.Preferred != fb.Preferred {
+					return fa.Preferred
+				}
+				if pa, pb := fa.Capture.Width*fa.Capture.Height, fb.Capture.Width*fb.Capture.Height; pa != pb {
+					return pa > pb
+				}
+				if fa.Size != fb.Size {
+					return fa.Size > fb.Size
+				}
+				return fa.Path < fb.Path
+			})
+			groups = append(groups, g)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i][0].Capture.Time.Before(groups[j][0].Capture.Time) })
+	return groups, Stage{Name: "captures", Files: len(jobs), Took: time.Since(start)}
+}
+
+// oneFormat reports whether every file of g is in the same format.
+func oneFormat(g []CaptureFile) bool {
+	for _, f := range g[1:] {
+		if formatOf(f.Path) != formatOf(g[0].Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameBytes reports whether every file of g holds the same content, going by
+// content, the SHA-256 of every exact duplicate.
+func sameBytes(g []CaptureFile, content map[string]string) bool {
+	first := content[g[0].Path]
+	for _, f := range g {
+		if h := content[f.Path]; h == "" || h != first {
+			return false
+		}
+	}
+	return true
+}
+
+// formatOf returns the format of path by its extension, or "" when
+// -captures does not compare it.
+func formatOf(path string) string {
+	return captureFormats[strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))]
+}
+
+// printCaptures lists same-capture groups, e.g.
+// "- 2024/03/IMG_1234.HEIC (4032x3024, 1843200 bytes, preferred)".
+func printCaptures(groups [][]CaptureFile) {
+	if len(groups) == 0 {
+		fmt.Println("\nNo captures found in more than one encoding.")
+		return
+	}
+	fmt.Println("\nSame capture, different encoding:")
+	for _, g := range groups {
+		c := g[0].Capture
+		fmt.Printf("\nCapture %s (%s):\n", c.Time.Format("2006-01-02 15:04:05.000"), c.Camera)
+		for _, f := range g {
+			line := fmt.Sprintf("- %s (%dx%d, %d bytes", f.Path, f.Capture.Width, f.Capture.Height, f.Size)
+			if f.Preferred {
+				line += ", preferred"
+			}
+			fmt.Println(line + ")")
+		}
+	}
+}
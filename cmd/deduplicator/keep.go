@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/layout"
+)
+
+// Keep policies, chosen with -keep.
+const (
+	KeepLayout   = "layout"   // the file in the library's folder layout, e.g. 2024/03/
+	KeepOldest   = "oldest"   // the file with the oldest modification time
+	KeepShortest = "shortest" // the file with the shortest path
+	KeepGlob     = "glob"     // the file matching -keep-glob
+)
+
+// KeepPolicy picks the file of each duplicate group that survives.
+type KeepPolicy struct {
+	Name   string           // one of the Keep constants
+	Root   string           // the folder scanned; paths are matched relative to it
+	Layout *layout.Template // folder template for KeepLayout
+	Glob   string           // pattern for KeepGlob
+}
+
+// ParseKeepPolicy checks name and the settings it needs.
+func ParseKeepPolicy(name, root, dirTemplate, glob string) (KeepPolicy, error) {
+	p := KeepPolicy{Name: name, Root: root, Glob: glob}
+	switch name {
+	case KeepLayout:
+		t, err := layout.ParseDir(dirTemplate)
+		if err != nil {
+			return p, fmt.Errorf("invalid -layout: %w", err)
+		}
+		p.Layout = t
+	case KeepOldest, KeepShortest:
+	case KeepGlob:
+		if glob == "" {
+			return p, fmt.Errorf("-keep=glob needs -keep-glob")
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return p, fmt.Errorf("invalid -keep-glob %q: %w", glob, err)
+		}
+	default:
+		return p, fmt.Errorf("unknown -keep %q: want %s, %s, %s or %s", name, KeepLayout, KeepOldest, KeepShortest, KeepGlob)
+	}
+	return p, nil
+}
+
+// Survivor returns the index in files of the one to keep. Files the policy
+// prefers come first; ties, and groups where the policy prefers none, go to
+// the shortest path, then the first in alphabetical order.
+func (p KeepPolicy) Survivor(files []FileInfo) int {
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		fa, fb := files[order[a]], files[order[b]]
+		switch p.Name {
+		case KeepOldest:
+			if !fa.ModTime.Equal(fb.ModTime) {
+				return fa.ModTime.Before(fb.ModTime)
+			}
+		case KeepLayout, KeepGlob:
+			if pa, pb := p.prefers(fa.Path), p.prefers(fb.Path); pa != pb {
+				return pa
+			}
+		}
+		if len(fa.Path) != len(fb.Path) {
+			return len(fa.Path) < len(fb.Path)
+		}
+		return fa.Path < fb.Path
+	})
+	return order[0]
+}
+
+// prefers reports whether file matches the layout or glob. Globs without a
+// slash are matched against the file name, others against the path
+// relative to Root.
+func (p KeepPolicy) prefers(file string) bool {
+	rel, err := filepath.Rel(p.Root, file)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if p.Name == KeepLayout {
+		_, ok := p.Layout.Match(path.Dir(rel), time.UTC)
+		return ok
+	}
+	if !strings.Contains(p.Glob, "/") {
+		rel = path.Base(rel)
+	}
+	ok, _ := path.Match(p.Glob, rel)
+	return ok
+}
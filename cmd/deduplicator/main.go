@@ -8,25 +8,70 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/config"
+	"github.com/cemeng/photos-organiser/internal/imagehash"
+	"github.com/cemeng/photos-organiser/internal/layout"
 )
 
 type FileInfo struct {
-	Path string
-	Size int64
-	Hash string
+	Path    string
+	Size    int64
+	Hash    string
+	Mode    os.FileMode
+	ModTime time.Time
+
+	// Set for images by -similar
+	Width, Height int            // upright, in pixels
+	Look          imagehash.Hash // perceptual hash
 }
 
 func main() {
-	srcPtr := flag.String("src", "", "Source directory to scan for duplicates")
+	cfg, sel, err := config.FromArgs(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	flag.String("config", sel.Path, "Config file")
+	flag.String("profile", sel.Profile, "Config profile to use")
+	srcPtr := flag.String("src", cfg.Library, "Source directory to scan for duplicates (default: the configured library)")
+	flag.String("ext", strings.Join(cfg.Extensions, ","), "Comma-separated extensions to scan, e.g. jpg,heic (default: all files)")
+	workersPtr := flag.Int("workers", 4, "Files to hash at the same time")
+	actionPtr := flag.String("action", "", "What to do with duplicates: delete, quarantine (move to -quarantine) or hardlink (default: only list them)")
+	keepPtr := flag.String("keep", KeepLayout, "Which file of each group to keep: layout (inside the library's -layout folders), oldest, shortest or glob")
+	keepGlobPtr := flag.String("keep-glob", "", "With -keep=glob, keep the file matching this pattern, e.g. '*.HEIC' or '2024/*/*'")
+	quarantinePtr := flag.String("quarantine", "", "Folder, outside -src, that -action=quarantine moves duplicates to")
+	applyPtr := flag.Bool("apply", false, "Carry out the actions; without it they are only listed and logged as planned")
+	logPtr := flag.String("log", "", "Action log to write (default: dedup-actions-<time>.jsonl in the current folder)")
+	flag.String("layout", orDefault(cfg.Layout, layout.DefaultDir), "Library folder template, for -keep=layout")
+	similarPtr := flag.String("similar", "", "Also cluster look-alike JPEG and PNG images by perceptual hash: dhash or phash (default: off)")
+	distancePtr := flag.Int("distance", 10, "With -similar, the most bits in which two images' hashes may differ, 0 to 64")
+	capturesPtr := flag.Bool("captures", false, "Also group photos of the same capture saved in different encodings, e.g. IMG_1234.HEIC and IMG_1234.JPG")
+	preferPtr := flag.String("prefer-format", "", "With -captures, list this format first in each group: heic, jpg or tif")
 	helpPtr := flag.Bool("help", false, "Show help message")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Deduplicator helps find duplicate files in a directory and its subdirectories.\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s -src [directory]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s -src [directory] [-similar dhash|phash] [-distance bits] [-captures]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -src [directory] -action delete|quarantine|hardlink [-keep policy] [-apply]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [-apply] replay|undo [action log]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [-config file] [-profile name] config show\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "The tool works by:\n")
 		fmt.Fprintf(os.Stderr, "1. Walking through all files in the specified directory and subdirectories\n")
-		fmt.Fprintf(os.Stderr, "2. Computing SHA256 hash of file contents to detect duplicates\n")
-		fmt.Fprintf(os.Stderr, "3. Reporting groups of duplicate files\n\n")
+		fmt.Fprintf(os.Stderr, "2. Grouping files by size; a file with a size of its own has no duplicate\n")
+		fmt.Fprintf(os.Stderr, "3. Hashing the first and last %d KB of same-size files\n", edgeSize/1024)
+		fmt.Fprintf(os.Stderr, "4. Computing SHA256 hash of the files still alike to detect duplicates\n")
+		fmt.Fprintf(os.Stderr, "5. Reporting groups of duplicate files, and how long each step took\n")
+		fmt.Fprintf(os.Stderr, "6. With -similar, also decoding JPEG and PNG images and listing those that look\n")
+		fmt.Fprintf(os.Stderr, "   alike within -distance, e.g. resized or recompressed copies, best quality first\n")
+		fmt.Fprintf(os.Stderr, "7. With -captures, also reading the EXIF of HEIC, JPEG and TIFF photos and listing\n")
+		fmt.Fprintf(os.Stderr, "   those of one shot in different encodings: same time to the fraction of a\n")
+		fmt.Fprintf(os.Stderr, "   second, same camera, same aspect ratio\n")
+		fmt.Fprintf(os.Stderr, "8. With -action, acting on every file of a duplicate group but the one -keep\n")
+		fmt.Fprintf(os.Stderr, "   picks, only with -apply, and logging each action so it can be replayed or undone\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 	}
@@ -38,6 +83,18 @@ func main() {
 		return
 	}
 
+	if err := config.Apply(&cfg, flag.CommandLine, map[string]string{"src": "library", "ext": "extensions", "layout": "layout"}); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if flag.NArg() == 2 && flag.Arg(0) == "config" && flag.Arg(1) == "show" {
+		config.Show(os.Stdout, sel, cfg, map[string]string{"library": "none, -src is required", "extensions": "all files", "layout": layout.DefaultDir})
+		return
+	}
+	if flag.NArg() == 2 && (flag.Arg(0) == "replay" || flag.Arg(0) == "undo") {
+		os.Exit(runLog(flag.Arg(0), flag.Arg(1), !*applyPtr, *logPtr))
+	}
+
 	if *srcPtr == "" {
 		fmt.Println("Error: src directory is required")
 		flag.Usage()
@@ -65,61 +122,208 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Map to store files by their hash
-	filesByHash := make(map[string][]FileInfo)
-
-	// Walk through directory
-	err = filepath.Walk(*srcPtr, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	var keep KeepPolicy
+	if *actionPtr != "" {
+		if keep, err = checkAction(*actionPtr, *srcPtr, *keepPtr, orDefault(cfg.Layout, layout.DefaultDir), *keepGlobPtr, *quarantinePtr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
+	}
 
-		// Skip directories
-		if info.IsDir() {
-			return nil
+	var alg imagehash.Algorithm
+	if *similarPtr != "" {
+		if alg, err = imagehash.ParseAlgorithm(*similarPtr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
-
-		// Calculate file hash
-		hash, err := calculateFileHash(path)
-		if err != nil {
-			fmt.Printf("Warning: Could not process %s: %v\n", path, err)
-			return nil
+		if *distancePtr < 0 || *distancePtr > 64 {
+			fmt.Printf("Error: -distance %d is not between 0 and 64\n", *distancePtr)
+			os.Exit(1)
 		}
+	}
 
-		// Store file info
-		fileInfo := FileInfo{
-			Path: path,
-			Size: info.Size(),
-			Hash: hash,
+	var prefer string
+	if *preferPtr != "" {
+		if prefer, err = parseFormat(*preferPtr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
-		filesByHash[hash] = append(filesByHash[hash], fileInfo)
-
-		return nil
-	})
+	}
 
+	pool := &Pool{Workers: *workersPtr}
+	if isTerminal(os.Stderr) {
+		pool.Progress = os.Stderr
+	}
+	start := time.Now()
+	files, err := walkFiles(*srcPtr, cfg)
 	if err != nil {
 		fmt.Printf("Error walking through directory: %v\n", err)
 		os.Exit(1)
 	}
+	stages := []Stage{{Name: "walk", Files: len(files), Took: time.Since(start)}}
+	groups, found := findDuplicates(files, pool)
+	stages = append(stages, found...)
+	var similar [][]Similar
+	if alg != "" {
+		var stage Stage
+		similar, stage = findSimilar(files, groups, alg, *distancePtr, pool)
+		stages = append(stages, stage)
+	}
+	var captures [][]CaptureFile
+	if *capturesPtr {
+		var stage Stage
+		captures, stage = findCaptures(files, groups, prefer, pool)
+		stages = append(stages, stage)
+	}
 
 	// Print duplicate files
-	duplicatesFound := false
-	for hash, files := range filesByHash {
-		if len(files) > 1 {
-			if !duplicatesFound {
-				fmt.Println("Found duplicate files:")
-				duplicatesFound = true
+	if len(groups) > 0 {
+		fmt.Println("Found duplicate files:")
+	}
+	for _, files := range groups {
+		fmt.Printf("\nDuplicate group (SHA256: %s):\n", files[0].Hash[:8])
+		for _, file := range files {
+			fmt.Printf("- %s (size: %d bytes)\n", file.Path, file.Size)
+		}
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No duplicate files found.")
+	}
+	if alg != "" {
+		printSimilar(similar, alg, *distancePtr)
+	}
+	if *capturesPtr {
+		printCaptures(captures)
+	}
+	printStages(stages)
+
+	if *actionPtr == "" || len(groups) == 0 {
+		return
+	}
+	fmt.Println()
+	actions := planActions(groups, *actionPtr, keep, *quarantinePtr)
+	if len(actions) == 0 {
+		fmt.Println("Nothing to do: every duplicate is already a link to the file kept.")
+		return
+	}
+	os.Exit(runPlan(actions, !*applyPtr, *logPtr))
+}
+
+// checkAction validates -action and the flags it needs before any hashing,
+// and returns the keep policy.
+func checkAction(op, src, keep, dirTemplate, glob, quarantine string) (KeepPolicy, error) {
+	switch op {
+	case OpDelete, OpHardlink:
+	case OpQuarantine:
+		if quarantine == "" {
+			return KeepPolicy{}, fmt.Errorf("-action=quarantine needs -quarantine")
+		}
+		if rel, err := filepath.Rel(src, quarantine); err == nil && !strings.HasPrefix(rel, "..") {
+			return KeepPolicy{}, fmt.Errorf("-quarantine %s is inside -src, where the next run would find its files again", quarantine)
+		}
+	default:
+		return KeepPolicy{}, fmt.Errorf("unknown -action %q: want %s, %s or %s", op, OpDelete, OpQuarantine, OpHardlink)
+	}
+	return ParseKeepPolicy(keep, src, dirTemplate, glob)
+}
+
+// runPlan carries out freshly planned actions, or with dryRun only lists
+// them, logging each to logPath. It returns the exit code.
+func runPlan(actions []Action, dryRun bool, logPath string) int {
+	log, err := createLog(logPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	defer log.Close()
+	counts, err := runActions(actions, func(a Action) Action { return apply(a, false, dryRun) }, dryRun, false, log)
+	return summarise(counts, err, dryRun, false, log.Path)
+}
+
+// runLog replays the planned actions of a dry run's log, or undoes the
+// done actions of an applied one, last first. With dryRun it only checks.
+// It returns the exit code.
+func runLog(cmd, path string, dryRun bool, logPath string) int {
+	recorded, err := ReadActionLog(path)
+	if err != nil {
+		fmt.Printf("Error reading action log: %v\n", err)
+		return 1
+	}
+	var actions []Action
+	do := func(a Action) Action { return apply(a, true, dryRun) }
+	if cmd == "undo" {
+		for i := len(recorded) - 1; i >= 0; i-- {
+			if recorded[i].Status == StatusDone {
+				actions = append(actions, recorded[i])
 			}
-			fmt.Printf("\nDuplicate group (SHA256: %s):\n", hash[:8])
-			for _, file := range files {
-				fmt.Printf("- %s (size: %d bytes)\n", file.Path, file.Size)
+		}
+		do = func(a Action) Action { return undo(a, dryRun) }
+	} else {
+		for _, a := range recorded {
+			if a.Status == StatusPlanned {
+				actions = append(actions, a)
 			}
 		}
 	}
+	if len(actions) == 0 {
+		fmt.Printf("Nothing to %s in %s.\n", cmd, path)
+		return 0
+	}
 
-	if !duplicatesFound {
-		fmt.Println("No duplicate files found.")
+	log, err := createLog(logPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	defer log.Close()
+	counts, err := runActions(actions, do, dryRun, cmd == "undo", log)
+	return summarise(counts, err, dryRun, cmd == "undo", log.Path)
+}
+
+func createLog(path string) (*ActionLog, error) {
+	if path == "" {
+		path = DefaultLogPath(time.Now())
+	}
+	return CreateActionLog(path)
+}
+
+// summarise prints the counts of a run and how to follow it up, and returns
+// the exit code: 1 if anything failed.
+func summarise(counts map[string]int, err error, dryRun, undoing bool, logPath string) int {
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	switch {
+	case dryRun && undoing:
+		fmt.Printf("\nDry run: %d to restore, %d skipped; nothing was changed.\n", counts[StatusPlanned], counts[StatusSkipped])
+	case dryRun:
+		fmt.Printf("\nDry run: %d actions planned, %d skipped; nothing was changed.\n", counts[StatusPlanned], counts[StatusSkipped])
+	case undoing:
+		fmt.Printf("\nRestored: %d  Skipped: %d  Failed: %d\n", counts[StatusUndone], counts[StatusSkipped], counts[StatusFailed])
+	default:
+		fmt.Printf("\nDone: %d  Skipped: %d  Failed: %d\n", counts[StatusDone], counts[StatusSkipped], counts[StatusFailed])
+	}
+	fmt.Printf("Log written to %s\n", logPath)
+	switch {
+	case dryRun && !undoing && counts[StatusPlanned] > 0:
+		fmt.Printf("Apply exactly these actions with: deduplicator -apply replay %s\n", logPath)
+	case !dryRun && !undoing && counts[StatusDone] > 0:
+		fmt.Printf("Undo them with: deduplicator -apply undo %s\n", logPath)
+	}
+	if counts[StatusFailed] > 0 {
+		return 1
+	}
+	return 0
+}
+
+// orDefault returns s, or def when s is empty.
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
 	}
+	return s
 }
 
 func calculateFileHash(filePath string) (string, error) {
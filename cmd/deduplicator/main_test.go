@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/config"
+	"github.com/cemeng/photos-organiser/internal/layout"
+	"github.com/cemeng/photos-organiser/internal/mediatest"
+)
+
+// writeFile writes data to name under dir, creating its folders, and
+// returns the path.
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// filled returns n bytes of b.
+func filled(b byte, n int) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+// paths lists the paths of each group, relative to root.
+func paths(t *testing.T, root string, groups [][]FileInfo) [][]string {
+	t.Helper()
+	var out [][]string
+	for _, g := range groups {
+		var names []string
+		for _, f := range g {
+			rel, err := filepath.Rel(root, f.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		out = append(out, names)
+	}
+	return out
+}
+
+// ── walkFiles ─────────────────────────────────────────────────────────────────
+
+func TestWalkFiles_Symlinks(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "a.jpg", filled('a', 50000))
+	writeFile(t, root, "b/other.jpg", filled('b', 50000))
+	if err := os.Symlink(filepath.Join(root, "a.jpg"), filepath.Join(root, "link.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "missing.jpg"), filepath.Join(root, "broken.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "b"), filepath.Join(root, "folder")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := walkFiles(root, config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := paths(t, root, [][]FileInfo{files})[0]
+	if want := []string{"a.jpg", "b/other.jpg"}; !slices.Equal(got, want) {
+		t.Errorf("walkFiles() = %q, want %q without the links", got, want)
+	}
+	if groups, _ := findDuplicates(files, &Pool{Workers: 2}); len(groups) != 0 {
+		t.Errorf("groups = %q, want none for a link and its target", paths(t, root, groups))
+	}
+}
+
+// ── groupBySize ───────────────────────────────────────────────────────────────
+
+func TestGroupBySize(t *testing.T) {
+	files := []FileInfo{
+		{Path: "c", Size: 20},
+		{Path: "a", Size: 10},
+		{Path: "lone", Size: 30},
+		{Path: "d", Size: 20},
+		{Path: "b", Size: 10},
+	}
+	var got [][]string
+	for _, g := range groupBySize(files) {
+		var names []string
+		for _, f := range g {
+			names = append(names, f.Path)
+		}
+		got = append(got, names)
+	}
+	want := [][]string{{"c", "d"}, {"a", "b"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("groupBySize() = %q, want %q in walk order", got, want)
+	}
+}
+
+// ── edgeHash ──────────────────────────────────────────────────────────────────
+
+func TestEdgeHash(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("small files are hashed whole", func(t *testing.T) {
+		for _, size := range []int{0, 100, 2 * edgeSize} {
+			path := writeFile(t, dir, "small.bin", filled('s', size))
+			f := FileInfo{Path: path, Size: int64(size)}
+			key, n, err := edgeHash(&f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			full, _ := calculateFileHash(path)
+			if key != full || f.Hash != full || n != int64(size) {
+				t.Errorf("size %d: key %s, Hash %s, read %d; want the full hash %s and %d bytes", size, key, f.Hash, n, full, size)
+			}
+		}
+	})
+
+	t.Run("large files by their edges", func(t *testing.T) {
+		size := 2*edgeSize + 1
+		a := filled('x', size)
+		b := filled('x', size)
+		b[edgeSize] = 'y' // just past the head
+		c := filled('x', size)
+		c[size-1] = 'y' // in the tail
+
+		var keys []string
+		for i, data := range [][]byte{a, b, c} {
+			f := FileInfo{Path: writeFile(t, dir, string(rune('a'+i))+".bin", data), Size: int64(size)}
+			key, n, err := edgeHash(&f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Hash != "" || n != 2*edgeSize {
+				t.Errorf("file %d: Hash %q, read %d; want no full hash and %d bytes", i, f.Hash, n, 2*edgeSize)
+			}
+			keys = append(keys, key)
+		}
+		if keys[0] != keys[1] {
+			t.Error("files differing only in the middle have different keys")
+		}
+		if keys[0] == keys[2] {
+			t.Error("files differing in the tail have the same key")
+		}
+	})
+}
+
+// ── refine ────────────────────────────────────────────────────────────────────
+
+func TestRefine(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name, content string) FileInfo {
+		return FileInfo{Path: writeFile(t, dir, name, []byte(content)), Size: int64(len(content))}
+	}
+	groups := [][]FileInfo{
+		{file("a1", "aaa"), file("b1", "bbb"), file("a2", "aaa"), file("b2", "bbb"), file("c", "ccc")},
+		{file("d1", "dd"), {Path: filepath.Join(dir, "gone"), Size: 2}, file("d2", "dd")},
+		{file("e", "ee"), file("f", "ff")},
+	}
+	out, files, read := refine(groups, &Pool{Workers: 3}, edgeHash)
+
+	var got [][]string
+	for _, g := range out {
+		var names []string
+		for _, f := range g {
+			names = append(names, filepath.Base(f.Path))
+		}
+		got = append(got, names)
+	}
+	want := [][]string{{"a1", "a2"}, {"b1", "b2"}, {"d1", "d2"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("refine() = %q, want %q", got, want)
+	}
+	if files != 10 || read != 5*3+2*2+2*2 {
+		t.Errorf("refine() read %d files, %d bytes; want 10 files, 23 bytes", files, read)
+	}
+}
+
+// ── Pool ──────────────────────────────────────────────────────────────────────
+
+func TestPool_HashKeepsOrder(t *testing.T) {
+	for _, workers := range []int{1, 4, 16} {
+		t.Run(fmt.Sprint(workers, " workers"), func(t *testing.T) {
+			files := make([]*FileInfo, 50)
+			for i := range files {
+				files[i] = &FileInfo{Path: fmt.Sprint(i), Size: int64(i)}
+			}
+			failed := errors.New("unreadable")
+			results := (&Pool{Workers: workers}).Hash(files, func(f *FileInfo) (string, int64, error) {
+				// later files finish first, so workers complete out of order
+				time.Sleep(time.Duration(50-f.Size) * 20 * time.Microsecond)
+				if f.Path == "7" {
+					return "", 0, failed
+				}
+				f.Hash = "h" + f.Path
+				return "key" + f.Path, f.Size, nil
+			})
+
+			if len(results) != len(files) {
+				t.Fatalf("%d results for %d files", len(results), len(files))
+			}
+			for i, r := range results {
+				if i == 7 {
+					if r.err != failed || r.key != "" {
+						t.Errorf("result 7 = %+v, want the error", r)
+					}
+					continue
+				}
+				want := fmt.Sprint("key", i)
+				if r.err != nil || r.key != want || r.n != int64(i) {
+					t.Errorf("result %d = %+v, want key %s", i, r, want)
+				}
+				if files[i].Hash != fmt.Sprint("h", i) {
+					t.Errorf("file %d: Hash %q not set by its own hash call", i, files[i].Hash)
+				}
+			}
+		})
+	}
+}
+
+// ── findDuplicates ────────────────────────────────────────────────────────────
+
+func TestFindDuplicates_Order(t *testing.T) {
+	root := t.TempDir()
+	big := filled('p', 3*edgeSize)
+	other := filled('p', 3*edgeSize)
+	other[edgeSize+1] = 'q' // same size, head and tail as big
+	for name, data := range map[string][]byte{
+		"z/big.jpg": big, "a/big.jpg": big, "m/big.jpg": big,
+		"other.jpg": other, "z/other.jpg": other,
+		"x.txt": []byte("one"), "y.txt": []byte("one"),
+		"lone.txt": []byte("four"),
+	} {
+		writeFile(t, root, name, data)
+	}
+	files, err := walkFiles(root, config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first [][]string
+	for _, workers := range []int{1, 4, 16} {
+		groups, stages := findDuplicates(slices.Clone(files), &Pool{Workers: workers})
+		got := paths(t, root, groups)
+		if len(got) != 3 {
+			t.Fatalf("workers %d: %d groups %q, want 3", workers, len(got), got)
+		}
+		for i, g := range groups {
+			if !slices.IsSortedFunc(g, func(a, b FileInfo) int { return strings.Compare(a.Path, b.Path) }) {
+				t.Errorf("workers %d: group %d not sorted by path: %q", workers, i, got[i])
+			}
+			if i > 0 && groups[i-1][0].Hash >= g[0].Hash {
+				t.Errorf("workers %d: groups not sorted by hash", workers)
+			}
+		}
+		if first == nil {
+			first = got
+		} else if !slices.EqualFunc(got, first, slices.Equal) {
+			t.Errorf("workers %d: groups %q, want %q as with 1 worker", workers, got, first)
+		}
+		if names := []string{stages[0].Name, stages[1].Name, stages[2].Name}; !slices.Equal(names, []string{"size", "head/tail", "full hash"}) {
+			t.Errorf("stages = %q", names)
+		}
+		// the small files were hashed whole by head/tail, and not read again
+		if stages[0].Files != 7 || stages[2].Files != 7 || stages[2].Bytes != 5*3*edgeSize {
+			t.Errorf("size stage saw %d files, full hash %d files, %d bytes; want 7, 7 and %d", stages[0].Files, stages[2].Files, stages[2].Bytes, 5*3*edgeSize)
+		}
+	}
+}
+
+// ── keep policies ─────────────────────────────────────────────────────────────
+
+func TestKeepPolicy_Survivor(t *testing.T) {
+	root := "/library"
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []FileInfo{
+		{Path: "/library/IMG_1234.JPG", ModTime: old.Add(time.Hour)},
+		{Path: "/library/imports/2024-03-15/IMG_1234.HEIC", ModTime: old},
+		{Path: "/library/2024/03/IMG_1234 copy.JPG", ModTime: old.Add(2 * time.Hour)},
+		{Path: "/library/backup/2024/03/IMG_1234.JPG", ModTime: old.Add(3 * time.Hour)},
+	}
+	tests := []struct {
+		name, policy, glob string
+		want               string
+	}{
+		{"layout folder beats the root", KeepLayout, "", "/library/2024/03/IMG_1234 copy.JPG"},
+		{"oldest", KeepOldest, "", "/library/imports/2024-03-15/IMG_1234.HEIC"},
+		{"shortest", KeepShortest, "", "/library/IMG_1234.JPG"},
+		{"glob without a slash matches the name", KeepGlob, "*.HEIC", "/library/imports/2024-03-15/IMG_1234.HEIC"},
+		{"glob with a slash matches the path from the root", KeepGlob, "backup/*/*/*", "/library/backup/2024/03/IMG_1234.JPG"},
+		{"glob matching nothing falls back to shortest", KeepGlob, "*.PNG", "/library/IMG_1234.JPG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseKeepPolicy(tt.policy, root, layout.DefaultDir, tt.glob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := files[p.Survivor(files)].Path; got != tt.want {
+				t.Errorf("Survivor() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("ties go to the first path", func(t *testing.T) {
+		tied := []FileInfo{{Path: "/library/b.jpg", ModTime: old}, {Path: "/library/a.jpg", ModTime: old}}
+		p, _ := ParseKeepPolicy(KeepOldest, root, "", "")
+		if got := tied[p.Survivor(tied)].Path; got != "/library/a.jpg" {
+			t.Errorf("Survivor() = %s, want /library/a.jpg", got)
+		}
+	})
+}
+
+func TestCheckAction(t *testing.T) {
+	src := t.TempDir()
+	if _, err := checkAction(OpQuarantine, src, KeepShortest, "", "", filepath.Join(src, "dups")); err == nil {
+		t.Error("quarantine inside -src: want an error")
+	}
+	if _, err := checkAction(OpQuarantine, src, KeepShortest, "", "", ""); err == nil {
+		t.Error("quarantine without -quarantine: want an error")
+	}
+	if _, err := checkAction(OpQuarantine, src, KeepShortest, "", "", filepath.Join(filepath.Dir(src), "dups")); err != nil {
+		t.Errorf("quarantine beside -src: %v", err)
+	}
+	if _, err := checkAction("shred", src, KeepShortest, "", "", ""); err == nil {
+		t.Error("unknown action: want an error")
+	}
+	if _, err := checkAction(OpDelete, src, KeepGlob, "", "", ""); err == nil {
+		t.Error("-keep=glob without -keep-glob: want an error")
+	}
+}
+
+// ── actions ───────────────────────────────────────────────────────────────────
+
+// duplicateTree writes a library with one photo in its layout and two
+// copies outside it, the copies with their own mode and time, and returns
+// its root and the duplicate groups found in it.
+func duplicateTree(t *testing.T) (root string, groups [][]FileInfo) {
+	t.Helper()
+	root = t.TempDir()
+	photo := filled('p', 3*edgeSize)
+	writeFile(t, root, "2024/03/IMG_1234.JPG", photo)
+	copied := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"IMG_1234.JPG", "Downloads/IMG_1234 (1).JPG"} {
+		path := writeFile(t, root, name, photo)
+		if err := os.Chmod(path, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, copied, copied); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := walkFiles(root, config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, _ = findDuplicates(files, &Pool{Workers: 2})
+	if len(groups) != 1 || len(groups[0]) != 3 {
+		t.Fatalf("groups = %q, want the three copies", paths(t, root, groups))
+	}
+	return root, groups
+}
+
+func TestActions_ApplyAndUndo(t *testing.T) {
+	for _, op := range []string{OpDelete, OpQuarantine, OpHardlink} {
+		t.Run(op, func(t *testing.T) {
+			root, groups := duplicateTree(t)
+			keep, err := ParseKeepPolicy(KeepLayout, root, layout.DefaultDir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			quarantine := filepath.Join(t.TempDir(), "dups")
+			survivor := filepath.Join(root, "2024", "03", "IMG_1234.JPG")
+
+			actions := planActions(groups, op, keep, quarantine)
+			if len(actions) != 2 {
+				t.Fatalf("%d actions, want 2", len(actions))
+			}
+			for i, a := range actions {
+				if a.Keep != survivor || a.Status != StatusPlanned {
+					t.Errorf("action %d keeps %s with status %s, want %s planned", i, a.Keep, a.Status, survivor)
+				}
+				if got := apply(a, false, true); got.Status != StatusPlanned || !exists(a.Path) {
+					t.Errorf("dry run of %s: status %s, file exists %v", a.Path, got.Status, exists(a.Path))
+				}
+				if actions[i] = apply(a, false, false); actions[i].Status != StatusDone {
+					t.Fatalf("apply %s: %s %s", a.Path, actions[i].Status, actions[i].Error)
+				}
+			}
+
+			for _, a := range actions {
+				switch op {
+				case OpDelete:
+					if exists(a.Path) {
+						t.Errorf("%s still exists", a.Path)
+					}
+				case OpQuarantine:
+					rel, _ := filepath.Rel(root, a.Path)
+					if a.MovedTo != filepath.Join(quarantine, rel) || exists(a.Path) || !exists(a.MovedTo) {
+						t.Errorf("%s not moved to %s under the quarantine", a.Path, rel)
+					}
+				case OpHardlink:
+					if !sameFile(a.Path, survivor) {
+						t.Errorf("%s is not a link to the survivor", a.Path)
+					}
+				}
+			}
+			if op == OpHardlink {
+				if again := planActions(groups, op, keep, quarantine); len(again) != 0 {
+					t.Errorf("files already linked planned again: %+v", again)
+				}
+			}
+
+			for i := len(actions) - 1; i >= 0; i-- {
+				a := undo(actions[i], false)
+				if a.Status != StatusUndone {
+					t.Fatalf("undo %s: %s %s", a.Path, a.Status, a.Error)
+				}
+				fi, err := os.Stat(a.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fi.Mode().Perm() != 0600 || !fi.ModTime().Equal(a.ModTime) {
+					t.Errorf("restored %s with mode %v, time %v; want 0600, %v", a.Path, fi.Mode().Perm(), fi.ModTime(), a.ModTime)
+				}
+				if sameFile(a.Path, survivor) {
+					t.Errorf("restored %s is still a link to the survivor", a.Path)
+				}
+				if reason := changed(a.Path, a.Hash); reason != "" {
+					t.Errorf("restored %s %s", a.Path, reason)
+				}
+			}
+			if got := undo(actions[0], false); got.Status != StatusSkipped {
+				t.Errorf("second undo: status %s, want skipped", got.Status)
+			}
+		})
+	}
+}
+
+func TestPlanActions_Symlinks(t *testing.T) {
+	root := t.TempDir()
+	photo := filled('p', 3*edgeSize)
+	original := writeFile(t, root, "lib/original_photo.jpg", photo)
+	link := filepath.Join(root, "lib", "a.jpg")
+	if err := os.Symlink("original_photo.jpg", link); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := calculateFileHash(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a group holding a link and its target, as a walk that followed links
+	// would have found it
+	groups := [][]FileInfo{{
+		{Path: original, Size: int64(len(photo)), Hash: hash},
+		{Path: link, Size: int64(len(photo)), Hash: hash},
+	}}
+	keep, err := ParseKeepPolicy(KeepShortest, root, layout.DefaultDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []string{OpDelete, OpQuarantine, OpHardlink} {
+		if actions := planActions(groups, op, keep, filepath.Join(t.TempDir(), "dups")); len(actions) != 0 {
+			t.Errorf("%s: planned %+v, want nothing", op, actions)
+		}
+	}
+
+	// a log that names the link as survivor is not carried out
+	a := Action{Op: OpDelete, Path: original, Keep: link, Hash: hash, Status: StatusPlanned}
+	for _, check := range []bool{false, true} {
+		if got := apply(a, check, false); got.Status != StatusSkipped || !exists(original) {
+			t.Errorf("apply(check=%v): status %s (%s), original exists %v; want skipped and kept", check, got.Status, got.Error, exists(original))
+		}
+	}
+}
+
+func TestApply_ReplaySkipsChangedFiles(t *testing.T) {
+	root, groups := duplicateTree(t)
+	keep, _ := ParseKeepPolicy(KeepLayout, root, layout.DefaultDir, "")
+	actions := planActions(groups, OpDelete, keep, "")
+
+	// the dry run's log, read back as replay would
+	logPath := filepath.Join(t.TempDir(), "actions.jsonl")
+	log, err := CreateActionLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range actions {
+		if err := log.Write(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+	recorded, err := ReadActionLog(logPath)
+	if err != nil || len(recorded) != 2 {
+		t.Fatalf("read %d actions, err %v; want 2", len(recorded), err)
+	}
+
+	edited := recorded[0].Path
+	if err := os.WriteFile(edited, filled('e', 3*edgeSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if a := apply(recorded[0], true, false); a.Status != StatusSkipped || a.Error != "changed since it was hashed" || !exists(edited) {
+		t.Errorf("changed file: status %s (%s), exists %v; want skipped and kept", a.Status, a.Error, exists(edited))
+	}
+	if a := apply(recorded[1], true, false); a.Status != StatusDone || exists(a.Path) {
+		t.Errorf("unchanged file: status %s (%s), exists %v; want deleted", a.Status, a.Error, exists(a.Path))
+	}
+
+	if err := os.WriteFile(recorded[0].Keep, filled('k', 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recorded[0].Path = filepath.Join(root, "elsewhere.jpg")
+	writeFile(t, root, "elsewhere.jpg", filled('p', 3*edgeSize))
+	if a := apply(recorded[0], true, false); a.Status != StatusSkipped || a.Error != "survivor changed since it was hashed" {
+		t.Errorf("changed survivor: status %s (%s), want skipped", a.Status, a.Error)
+	}
+}
+
+// ── captures ──────────────────────────────────────────────────────────────────
+
+// shot is the EXIF of one capture as one encoding of it records it.
+type shot struct {
+	model, taken, subSec string
+	width, height        uint16
+}
+
+// writeShot writes a HEIC or JPEG, by name's extension, carrying s, and a
+// few bytes of its own so no two files are identical.
+func writeShot(t *testing.T, dir, name string, s shot) {
+	t.Helper()
+	exifTags := []mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: s.taken}}
+	if s.subSec != "" {
+		exifTags = append(exifTags, mediatest.Tag{ID: mediatest.TagSubSecTimeOriginal, Value: s.subSec})
+	}
+	if s.width != 0 {
+		exifTags = append(exifTags,
+			mediatest.Tag{ID: mediatest.TagPixelXDimension, Short: s.width},
+			mediatest.Tag{ID: mediatest.TagPixelYDimension, Short: s.height})
+	}
+	tiff := mediatest.TIFF([]mediatest.Tag{
+		{ID: mediatest.TagMake, Value: "Apple"},
+		{ID: mediatest.TagModel, Value: s.model},
+		{ID: mediatest.TagDateTime, Value: name},
+	}, exifTags)
+	data := mediatest.JPEG(tiff)
+	if filepath.Ext(name) == ".HEIC" {
+		data = mediatest.HEIC(tiff, false)
+	}
+	writeFile(t, dir, name, data)
+}
+
+func TestFindCaptures(t *testing.T) {
+	root := t.TempDir()
+	morning := shot{model: "iPhone 12", taken: "2024:03:15 09:41:27", subSec: "123", width: 4032, height: 3024}
+	burst := morning
+	burst.subSec = "456"
+	export := morning
+	export.width, export.height = 1008, 757 // rounded when scaled
+	square := morning
+	square.width, square.height = 3024, 3024
+	otherPhone := morning
+	otherPhone.model = "iPhone 15"
+	noSubSec := shot{model: "iPhone 12", taken: "2024:03:16 10:00:00", width: 4032, height: 3024}
+
+	writeShot(t, root, "IMG_1234.HEIC", morning)
+	writeShot(t, root, "export/IMG_1234.JPG", export)
+	writeShot(t, root, "IMG_1234 crop.JPG", square)
+	writeShot(t, root, "IMG_1234 other.JPG", otherPhone)
+	writeShot(t, root, "IMG_1235.HEIC", burst)
+	writeShot(t, root, "IMG_1235.JPG", burst)
+	writeShot(t, root, "IMG_1235 edit.JPG", burst)
+	writeShot(t, root, "IMG_2000.HEIC", noSubSec)
+	writeShot(t, root, "IMG_2000.JPG", noSubSec)
+	later := burst
+	later.taken = "2024:03:17 08:00:00"
+	writeShot(t, root, "IMG_3000.JPG", later)
+	writeShot(t, root, "IMG_3000 edit.JPG", later)
+
+	files, err := walkFiles(root, config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := &Pool{Workers: 4}
+	dups, _ := findDuplicates(files, pool)
+
+	names := func(groups [][]CaptureFile) [][]string {
+		var out [][]string
+		for _, g := range groups {
+			var fs []FileInfo
+			for _, f := range g {
+				fs = append(fs, f.FileInfo)
+			}
+			out = append(out, paths(t, root, [][]FileInfo{fs})[0])
+		}
+		return out
+	}
+
+	t.Run("most pixels first", func(t *testing.T) {
+		groups, stage := findCaptures(slices.Clone(files), dups, "", pool)
+		want := [][]string{
+			{"IMG_1234.HEIC", "export/IMG_1234.JPG"},
+			{"IMG_1235.HEIC", "IMG_1235 edit.JPG", "IMG_1235.JPG"},
+		}
+		if got := names(groups); !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("groups = %q, want %q", got, want)
+		}
+		if stage.Files != 11 {
+			t.Errorf("stage read %d files, want 11", stage.Files)
+		}
+	})
+
+	t.Run("prefer-format first", func(t *testing.T) {
+		prefer, err := parseFormat(".JPEG")
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups, _ := findCaptures(slices.Clone(files), dups, prefer, pool)
+		want := [][]string{
+			{"export/IMG_1234.JPG", "IMG_1234.HEIC"},
+			{"IMG_1235 edit.JPG", "IMG_1235.JPG", "IMG_1235.HEIC"},
+		}
+		if got := names(groups); !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("groups = %q, want %q", got, want)
+		}
+		if !groups[0][0].Preferred || groups[0][1].Preferred {
+			t.Errorf("Preferred = %v, %v; want only the JPEG", groups[0][0].Preferred, groups[0][1].Preferred)
+		}
+	})
+
+	t.Run("exact duplicates are left out", func(t *testing.T) {
+		dir := t.TempDir()
+		writeShot(t, dir, "IMG_4000.JPG", morning)
+		data, err := os.ReadFile(filepath.Join(dir, "IMG_4000.JPG"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, dir, "IMG_4000.TIF", data)
+		files, err := walkFiles(dir, config.Settings{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dups, _ := findDuplicates(files, pool)
+		if groups, _ := findCaptures(files, dups, "", pool); len(groups) != 0 {
+			t.Errorf("byte-identical files reported as one capture: %q", names(groups))
+		}
+		if groups, _ := findCaptures(files, nil, "", pool); len(groups) != 1 {
+			t.Errorf("without the duplicates, %d groups, want the JPEG and TIFF", len(groups))
+		}
+	})
+}
+
+func TestReadCapture(t *testing.T) {
+	dir := t.TempDir()
+	writeShot(t, dir, "a.JPG", shot{model: "iPhone 12", taken: "2024:03:15 09:41:27", subSec: "5", width: 3024, height: 4032})
+	writeShot(t, dir, "b.JPG", shot{model: "iPhone 12", taken: "2024:03:15 09:41:27", width: 3024, height: 4032})
+
+	c, ok := readCapture(filepath.Join(dir, "a.JPG"))
+	want := time.Date(2024, 3, 15, 9, 41, 27, 500_000_000, time.UTC)
+	if !ok || !c.Time.Equal(want) || c.Camera != "Apple iPhone 12" || c.Width != 3024 || c.Height != 4032 {
+		t.Errorf("readCapture() = %+v, %v; want %v, Apple iPhone 12, 3024x4032", c, ok, want)
+	}
+	if _, ok := readCapture(filepath.Join(dir, "b.JPG")); ok {
+		t.Error("readCapture() without SubSecTimeOriginal: want ok false")
+	}
+	if _, err := parseFormat("png"); err == nil {
+		t.Error("parseFormat(png): want an error")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pool hashes files on a fixed number of worker goroutines, fed by a
+// producer, and shows a live progress line while it works.
+type Pool struct {
+	Workers  int
+	Stage    string    // name shown on the progress line
+	Progress io.Writer // where the progress line is drawn; nil for none
+
+	files, bytes atomic.Int64
+}
+
+// hashResult is what a hash function said about one file.
+type hashResult struct {
+	key string
+	n   int64 // bytes read
+	err error
+}
+
+// isTerminal reports whether f is a terminal, so the progress line is only
+// drawn where it can be redrawn in place.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Hash runs hash on every file and returns the results in the order of
+// files, whichever worker finished first. hash may set fields of its file;
+// no two workers see the same one.
+func (p *Pool) Hash(files []*FileInfo, hash func(*FileInfo) (string, int64, error)) []hashResult {
+	results := make([]hashResult, len(files))
+	p.files.Store(0)
+	p.bytes.Store(0)
+	stop := p.showProgress(len(files))
+	defer stop()
+
+	workers := max(p.Workers, 1)
+	jobs := make(chan int, workers)
+	go func() {
+		for i := range files {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				key, n, err := hash(files[i])
+				results[i] = hashResult{key, n, err}
+				p.files.Add(1)
+				p.bytes.Add(n)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// showProgress redraws "head/tail: 120/800 files, 1.2 MB hashed" every
+// tenth of a second until the returned stop is called, which clears it.
+func (p *Pool) showProgress(files int) (stop func()) {
+	if p.Progress == nil || files == 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			fmt.Fprintf(p.Progress, "\r\033[K%s: %d/%d files, %s hashed", p.Stage, p.files.Load(), files, formatBytes(p.bytes.Load()))
+			select {
+			case <-done:
+				fmt.Fprint(p.Progress, "\r\033[K")
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
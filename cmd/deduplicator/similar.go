@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/imagehash"
+)
+
+// Similar is a file of a cluster of look-alike images.
+type Similar struct {
+	FileInfo
+	Distance int // bits from the cluster's first, best-quality image
+}
+
+// findSimilar decodes the JPEGs and PNGs among files on pool and clusters
+// those whose perceptual hashes are within maxDistance. Clusters whose images
+// are all copies of one of the exact duplicate groups dups are reported
+// already, and left out. Each cluster is sorted best quality first: most
+// pixels, then largest file, then path.
+func findSimilar(files []FileInfo, dups [][]FileInfo, alg imagehash.Algorithm, maxDistance int, pool *Pool) ([][]Similar, Stage) {
+	start := time.Now()
+	var jobs []*FileInfo
+	for i := range files {
+		if imagehash.Supported(filepath.Ext(files[i].Path)) {
+			jobs = append(jobs, &files[i])
+		}
+	}
+	pool.Stage = "similar"
+	results := pool.Hash(jobs, func(f *FileInfo) (string, int64, error) {
+		img, err := imagehash.File(f.Path, alg)
+		f.Width, f.Height, f.Look = img.Width, img.Height, img.Hash
+		return "", f.Size, err
+	})
+
+	var images []FileInfo
+	var hashes []imagehash.Hash
+	stage := Stage{Name: "similar", Files: len(jobs)}
+	for i, r := range results {
+		stage.Bytes += r.n
+		if r.err != nil {
+			fmt.Printf("Warning: Could not decode %s: %v\n", jobs[i].Path, r.err)
+			continue
+		}
+		images = append(images, *jobs[i])
+		hashes = append(hashes, jobs[i].Look)
+	}
+
+	content := make(map[string]string)
+	for _, group := range dups {
+		for _, f := range group {
+			content[f.Path] = f.Hash
+		}
+	}
+	var clusters [][]Similar
+	for _, members := range imagehash.Cluster(hashes, maxDistance) {
+		if sameContent(images, members, content) {
+			continue
+		}
+		sort.Slice(members, func(a, b int) bool {
+			fa, fb := images[members[a]], images[members[b]]
+			if pa, pb := fa.Width*fa.Height, fb.Width*fb.Height; pa != pb {
+				return pa > pb
+			}
+			if fa.Size != fb.Size {
+				return fa.Size > fb.Size
+			}
+			return fa.Path < fb.Path
+		})
+		best := hashes[members[0]]
+		cluster := make([]Similar, len(members))
+		for i, m := range members {
+			cluster[i] = Similar{FileInfo: images[m], Distance: best.Distance(hashes[m])}
+		}
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i][0].Path < clusters[j][0].Path })
+	stage.Took = time.Since(start)
+	return clusters, stage
+}
+
+// sameContent reports whether the images at indexes members all hold the
+// same bytes, going by content, the SHA-256 of every exact duplicate.
+func sameContent(images []FileInfo, members []int, content map[string]string) bool {
+	first := content[images[members[0]].Path]
+	for _, m := range members {
+		if h := content[images[m].Path]; h == "" || h != first {
+			return false
+		}
+	}
+	return true
+}
+
+// printSimilar lists clusters of look-alike images, e.g.
+// "- 2024/03/IMG_1234.JPG (4032x3024, 2811904 bytes)".
+func printSimilar(clusters [][]Similar, alg imagehash.Algorithm, maxDistance int) {
+	if len(clusters) == 0 {
+		fmt.Println("\nNo similar images found.")
+		return
+	}
+	fmt.Printf("\nSimilar images (%s, distance ≤ %d), best quality first:\n", alg, maxDistance)
+	for _, cluster := range clusters {
+		fmt.Printf("\nSimilar group (%d images):\n", len(cluster))
+		for i, f := range cluster {
+			line := fmt.Sprintf("- %s (%dx%d, %d bytes", f.Path, f.Width, f.Height, f.Size)
+			if i > 0 {
+				line += fmt.Sprintf(", distance %d", f.Distance)
+			}
+			fmt.Println(line + ")")
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/config"
+)
+
+// edgeSize is how much of the start and of the end of a file the head/tail
+// stage hashes. Files up to twice this size are hashed whole there.
+const edgeSize = 8 * 1024
+
+// Stage records how long one step of the search took and how much it read,
+// for the timings printed at the end.
+type Stage struct {
+	Name  string
+	Files int   // files the stage looked at
+	Bytes int64 // bytes it read
+	Took  time.Duration
+}
+
+// walkFiles lists the regular files under root whose extension cfg allows.
+// Symlinks are left out: a link holds no content of its own, and listing it
+// would make it a duplicate of the file it points to.
+func walkFiles(root string, cfg config.Settings) ([]FileInfo, error) {
+	var files []FileInfo
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip directories, symlinks and other files that are not regular
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		// Skip extensions left out of -ext or the config file
+		if !cfg.Allows(strings.TrimPrefix(filepath.Ext(path), ".")) {
+			return nil
+		}
+
+		files = append(files, FileInfo{Path: path, Size: info.Size(), Mode: info.Mode(), ModTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// groupBySize returns the sets of files sharing a size, in walk order. A
+// file with a size of its own cannot have a duplicate and is dropped.
+func groupBySize(files []FileInfo) [][]FileInfo {
+	bySize := make(map[int64][]FileInfo)
+	var order []int64
+	for _, f := range files {
+		if _, ok := bySize[f.Size]; !ok {
+			order = append(order, f.Size)
+		}
+		bySize[f.Size] = append(bySize[f.Size], f)
+	}
+	var groups [][]FileInfo
+	for _, size := range order {
+		if len(bySize[size]) > 1 {
+			groups = append(groups, bySize[size])
+		}
+	}
+	return groups
+}
+
+// refine splits each group by the key hash gives its files and keeps the
+// subgroups that still hold more than one file, in the order they came.
+// Files are hashed on the pool; those that cannot be read are reported and
+// left out. It returns the files hashed and bytes read.
+func refine(groups [][]FileInfo, pool *Pool, hash func(*FileInfo) (key string, n int64, err error)) (out [][]FileInfo, files int, bytes int64) {
+	var jobs []*FileInfo
+	for _, group := range groups {
+		for i := range group {
+			jobs = append(jobs, &group[i])
+		}
+	}
+	keys := pool.Hash(jobs, hash)
+
+	i := 0
+	for _, group := range groups {
+		byKey := make(map[string][]FileInfo)
+		var order []string
+		for _, f := range group {
+			k := keys[i]
+			i++
+			files++
+			bytes += k.n
+			if k.err != nil {
+				fmt.Printf("Warning: Could not process %s: %v\n", f.Path, k.err)
+				continue
+			}
+			if _, ok := byKey[k.key]; !ok {
+				order = append(order, k.key)
+			}
+			byKey[k.key] = append(byKey[k.key], f)
+		}
+		for _, key := range order {
+			if len(byKey[key]) > 1 {
+				out = append(out, byKey[key])
+			}
+		}
+	}
+	return out, files, bytes
+}
+
+// edgeHash keys a file by its first and last edgeSize bytes. Small files are
+// hashed whole, which also gives them their full Hash.
+func edgeHash(f *FileInfo) (string, int64, error) {
+	if f.Size <= 2*edgeSize {
+		hash, err := calculateFileHash(f.Path)
+		if err != nil {
+			return "", 0, err
+		}
+		f.Hash = hash
+		return hash, f.Size, nil
+	}
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, file, edgeSize); err != nil {
+		return "", 0, err
+	}
+	if _, err := file.Seek(-edgeSize, io.SeekEnd); err != nil {
+		return "", 0, err
+	}
+	if _, err := io.CopyN(hash, file, edgeSize); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), 2 * edgeSize, nil
+}
+
+// fullHash keys a file by the SHA-256 of its contents, unless edgeHash
+// already took it.
+func fullHash(f *FileInfo) (string, int64, error) {
+	if f.Hash != "" {
+		return f.Hash, 0, nil
+	}
+	hash, err := calculateFileHash(f.Path)
+	if err != nil {
+		return "", 0, err
+	}
+	f.Hash = hash
+	return hash, f.Size, nil
+}
+
+// findDuplicates returns the groups of duplicates among the walked files,
+// sorted by SHA-256 and each by path, and the stages after the walk. Only
+// files sharing a size are read, only their first and last few KB at first,
+// and only files still alike after that are hashed in full, on pool.
+func findDuplicates(files []FileInfo, pool *Pool) ([][]FileInfo, []Stage) {
+	var stages []Stage
+
+	start := time.Now()
+	groups := groupBySize(files)
+	sized := 0
+	for _, g := range groups {
+		sized += len(g)
+	}
+	stages = append(stages, Stage{Name: "size", Files: sized, Took: time.Since(start)})
+
+	start = time.Now()
+	pool.Stage = "head/tail"
+	groups, n, bytes := refine(groups, pool, edgeHash)
+	stages = append(stages, Stage{Name: "head/tail", Files: n, Bytes: bytes, Took: time.Since(start)})
+
+	start = time.Now()
+	pool.Stage = "full hash"
+	groups, n, bytes = refine(groups, pool, fullHash)
+	stages = append(stages, Stage{Name: "full hash", Files: n, Bytes: bytes, Took: time.Since(start)})
+
+	for _, g := range groups {
+		sort.Slice(g, func(i, j int) bool { return g[i].Path < g[j].Path })
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].Hash < groups[j][0].Hash })
+	return groups, stages
+}
+
+// printStages prints how long each stage took, e.g.
+// "  full hash    12.3s   40 files, 1.2 GB".
+func printStages(stages []Stage) {
+	fmt.Println("\nTimings:")
+	for _, s := range stages {
+		line := fmt.Sprintf("  %-10s %8s  %d files", s.Name, s.Took.Round(time.Millisecond), s.Files)
+		if s.Bytes > 0 {
+			line += ", " + formatBytes(s.Bytes)
+		}
+		fmt.Println(line)
+	}
+}
+
+// formatBytes renders n in B, KB, MB, GB or TB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
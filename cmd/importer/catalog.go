@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/catalog"
+	"github.com/cemeng/photos-organiser/internal/media"
+)
+
+// markInLibrary classifies processable files whose content the library's
+// catalog already holds as ClassInLibrary, so they are left in the source
+// rather than copied in again. Only files whose size is in the catalog are
+// hashed.
+func markInLibrary(files []FilePlan, cat *catalog.Catalog) {
+	for i := range files {
+		fp := &files[i]
+		if fp.Class != ClassProcessable {
+			continue
+		}
+		fi, err := os.Stat(fp.SourcePath)
+		if err != nil || !cat.HasSize(fi.Size()) {
+			continue
+		}
+		hash := fp.Hash
+		if hash == "" {
+			if hash, err = fileHash(fp.SourcePath); err != nil {
+				continue // execution reports the read error
+			}
+		}
+		found := cat.Lookup(hash)
+		if len(found) == 0 {
+			continue
+		}
+		fp.Class = ClassInLibrary
+		fp.SkipReason = "already in library as " + found[0].Path
+		fp.DestDir, fp.DestPath = "", ""
+	}
+}
+
+// catalogImport adds the files an import put in the library to its catalog,
+// tagged with the import's session, so later imports recognise them. A file
+// that was already at its destination is added only if the catalog does not
+// list it there yet.
+func catalogImport(report *ImportReport) error {
+	cat, err := catalog.Open(report.Destination)
+	if err != nil {
+		return fmt.Errorf("reading library catalog: %w", err)
+	}
+	session := report.StartedAt.Format("2006-01-02-15-04-05")
+	var entries []catalog.Entry
+	for _, res := range report.Results {
+		if !res.Succeeded || res.DestHash == "" {
+			continue
+		}
+		rel, err := filepath.Rel(report.Destination, res.Plan.DestPath)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if res.Existed && slices.ContainsFunc(cat.Lookup(res.DestHash), func(e catalog.Entry) bool { return e.Path == rel }) {
+			continue
+		}
+		fi, err := os.Stat(res.Plan.DestPath)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, catalog.Entry{
+			Hash:    res.DestHash,
+			Size:    fi.Size(),
+			TakenAt: res.Plan.TakenAt,
+			Path:    rel,
+			Session: session,
+		})
+	}
+	if err := cat.Add(entries...); err != nil {
+		return fmt.Errorf("updating library catalog: %w", err)
+	}
+	return nil
+}
+
+// libraryDate dates a file already in library for the catalog: from its
+// embedded metadata, then from a name the layout gave it, then as a new
+// import would.
+func libraryDate(library, path string, opts ScanOptions) time.Time {
+	var info captureInfo
+	var err error
+	ext, _, _ := splitExtension(filepath.Base(path))
+	switch ext = strings.ToLower(ext); {
+	case ext == "jpg", ext == "jpeg", ext == "heic", media.IsRaw(ext):
+		info, err = captureFromExif(path, opts.Zone)
+	case ext == "mov", ext == "mp4", ext == "3gp":
+		info, err = captureFromContainer(path)
+	default:
+		err = media.ErrNoDate
+	}
+	if err == nil {
+		return info.Time
+	}
+	zone := opts.Zone
+	if zone == nil {
+		zone = time.Local
+	}
+	if f, ok := opts.Layout.Name.Match(filepath.Base(path), zone); ok && !f.Time.IsZero() {
+		return f.Time
+	}
+	rel, err := filepath.Rel(library, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	t, _, _ := fallbackDate(library, rel, opts)
+	return t
+}
+
+// runCatalog implements "importer catalog rebuild [<library>]", indexing the
+// library (default: the configured one) from scratch. It returns the process
+// exit code.
+func runCatalog(args []string, library string, opts ScanOptions, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("catalog", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.Usage = func() {
+		fmt.Fprintf(errOut, "Usage: importer catalog rebuild [<library>]\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitFailed
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 || fs.Arg(0) != "rebuild" {
+		fs.Usage()
+		return exitFailed
+	}
+	if fs.NArg() == 2 {
+		library = fs.Arg(1)
+	}
+	if library == "" {
+		fmt.Fprintf(errOut, "Error: no library given and none configured\n")
+		return exitFailed
+	}
+	if info, err := os.Stat(library); err != nil || !info.IsDir() {
+		fmt.Fprintf(errOut, "Error: %q is not a valid directory\n", library)
+		return exitFailed
+	}
+
+	start := time.Now()
+	cat, err := catalog.Rebuild(library, func(path string) time.Time {
+		return libraryDate(library, path, opts)
+	}, func(n int) {
+		if n%1000 == 0 {
+			fmt.Fprintf(out, "%d files indexed\n", n)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailed
+	}
+	fmt.Fprintf(out, "Catalogued %d files in %s (%s)\n", cat.Len(), catalog.Path(library), time.Since(start).Round(time.Millisecond))
+	return exitOK
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/media"
+)
+
+// ClockCorrection is a fixed error in one camera's clock. Offset is added to
+// the time the camera recorded to get the true time, so a body running four
+// minutes slow has Offset 4m.
+type ClockCorrection struct {
+	Make   string // matched case-insensitively; empty matches any
+	Model  string
+	Serial string
+	Offset time.Duration
+}
+
+// ClockTable holds the corrections for every camera that needs one.
+type ClockTable []ClockCorrection
+
+// Lookup returns the correction for cam. When several entries match, the most
+// specific one (most non-empty fields) wins, so a serial-number entry
+// overrides one for the whole model.
+func (t ClockTable) Lookup(cam media.Camera) (ClockCorrection, bool) {
+	best, bestScore := ClockCorrection{}, -1
+	for _, c := range t {
+		score := 0
+		ok := true
+		for _, f := range [][2]string{{c.Make, cam.Make}, {c.Model, cam.Model}, {c.Serial, cam.Serial}} {
+			if f[0] == "" {
+				continue
+			}
+			if !strings.EqualFold(f[0], f[1]) {
+				ok = false
+				break
+			}
+			score++
+		}
+		if ok && score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// LoadClockTable reads a clock table file: one "make,model,serial,offset" CSV
+// row per camera, with # comments. Offsets use Go duration syntax, e.g.
+//
+//	# second body, 4m30s slow and never switched to daylight saving
+//	FUJIFILM,X-T4,12345678,4m30s
+//	Canon,Canon EOS 80D,,-1h
+func LoadClockTable(path string) (ClockTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 4
+	r.TrimLeadingSpace = true
+
+	var table ClockTable
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading clock table %s: %w", path, err)
+		}
+		offset, err := time.ParseDuration(strings.TrimSpace(rec[3]))
+		if err != nil {
+			line, _ := r.FieldPos(3)
+			return nil, fmt.Errorf("clock table %s line %d: %w", path, line, err)
+		}
+		table = append(table, ClockCorrection{
+			Make:   strings.TrimSpace(rec[0]),
+			Model:  strings.TrimSpace(rec[1]),
+			Serial: strings.TrimSpace(rec[2]),
+			Offset: offset,
+		})
+	}
+	return table, nil
+}
+
+// CalibrateClock works out a camera's clock error from one reference photo,
+// e.g. a shot of a phone's clock. actual is the true time the photo was taken,
+// as "2006-01-02 15:04:05" wall-clock time in the photo's own zone, or as
+// RFC 3339 with an explicit offset.
+func CalibrateClock(path, actual string) (ClockCorrection, error) {
+	x, err := media.DecodeExif(path)
+	if err != nil {
+		return ClockCorrection{}, err
+	}
+	recorded, _, err := media.ExifCaptureTime(x, time.Local)
+	if err != nil {
+		return ClockCorrection{}, err
+	}
+	truth, err := time.Parse(time.RFC3339, actual)
+	if err != nil {
+		truth, err = time.ParseInLocation("2006-01-02 15:04:05", actual, recorded.Location())
+		if err != nil {
+			return ClockCorrection{}, fmt.Errorf("invalid reference time %q (want \"2006-01-02 15:04:05\" or RFC 3339)", actual)
+		}
+	}
+	cam := media.CameraOf(x)
+	return ClockCorrection{
+		Make:   cam.Make,
+		Model:  cam.Model,
+		Serial: cam.Serial,
+		Offset: truth.Sub(recorded).Round(time.Second),
+	}, nil
+}
+
+// String renders c as a clock table row.
+func (c ClockCorrection) String() string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write([]string{c.Make, c.Model, c.Serial, c.Offset.String()})
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// formatCorrection renders a clock correction with an explicit sign, e.g. "+4m30s".
+func formatCorrection(d time.Duration) string {
+	if d < 0 {
+		return d.String()
+	}
+	return "+" + d.String()
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cemeng/photos-organiser/internal/layout"
+	"github.com/cemeng/photos-organiser/internal/media"
+)
+
+// sidecarExtensions are files that only describe another file: iPhone edit
+// instructions (.AAE) and XMP metadata written by Lightroom, darktable, etc.
+// They are never dated on their own; they follow their primary.
+var sidecarExtensions = map[string]bool{
+	"aae": true,
+	"xmp": true,
+}
+
+// variantPatterns recognise iPhone's names for other renderings of a shot:
+// IMG_E1234.JPG is the edited version of IMG_1234, and IMG_O1234.AAE holds
+// the adjustments of the original. The suffix keeps them recognisable (and
+// distinct) once they are renamed next to the original.
+var variantPatterns = []struct {
+	re     *regexp.Regexp
+	suffix string
+}{
+	{regexp.MustCompile(`(?i)^(IMG)_E(\d+)$`), "EDITED"},
+	{regexp.MustCompile(`(?i)^(IMG)_O(\d+)$`), "ORIGINAL"},
+}
+
+// isSidecar reports whether ext (without the dot, any case) is a sidecar extension.
+func isSidecar(ext string) bool {
+	return sidecarExtensions[strings.ToLower(ext)]
+}
+
+// splitVariant returns the basename of the original a variant belongs to and
+// the variant's suffix, e.g. IMG_E1234 → (IMG_1234, EDITED). Plain names are
+// returned unchanged with an empty suffix.
+func splitVariant(base string) (original, suffix string) {
+	for _, p := range variantPatterns {
+		if m := p.re.FindStringSubmatch(base); m != nil {
+			return m[1] + "_" + m[2], p.suffix
+		}
+	}
+	return base, ""
+}
+
+// groupCompanions links files that belong to the same capture and gives each
+// group one timestamp and one destination prefix:
+//   - a Live Photo's still and its MOV (IMG_1234.HEIC + IMG_1234.MOV)
+//   - a RAW file and its in-camera JPEG (DSC0001.ARW + DSC0001.JPG)
+//
+// Files in the same folder with the same basename are companions unless both
+// carry an Apple ContentIdentifier and the identifiers differ; files sharing a
+// ContentIdentifier are companions whatever their names. The group takes the
+// timestamp and basename of its primary file: an EXIF-dated photo beats a
+// container-dated video, which beats an mtime fallback, and a RAW beats its JPEG.
+func groupCompanions(files []FilePlan, dest string, lay layout.Layout) {
+	parent := make(map[int]int)
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(a, b int) {
+		if ra, rb := find(a), find(b); ra != rb {
+			parent[rb] = ra
+		}
+	}
+
+	byName := make(map[string][]int)
+	byContentID := make(map[string]int)
+	for i, fp := range files {
+		if fp.Class != ClassProcessable || !isCompanionCandidate(fp.SourceName) {
+			continue
+		}
+		parent[i] = i
+		_, base, _ := splitExtension(fp.SourceName)
+		key := filepath.Join(filepath.Dir(fp.RelPath), strings.ToUpper(base))
+		byName[key] = append(byName[key], i)
+		if fp.ContentID != "" {
+			if j, ok := byContentID[fp.ContentID]; ok {
+				union(j, i)
+			} else {
+				byContentID[fp.ContentID] = i
+			}
+		}
+	}
+	for _, idx := range byName {
+		for _, i := range idx[1:] {
+			a, b := files[idx[0]].ContentID, files[i].ContentID
+			if a != "" && b != "" && a != b {
+				continue
+			}
+			union(idx[0], i)
+		}
+	}
+
+	groups := make(map[int][]int)
+	for i := range files {
+		if _, ok := parent[i]; ok {
+			root := find(i)
+			groups[root] = append(groups[root], i)
+		}
+	}
+	for _, idx := range groups {
+		if len(idx) < 2 {
+			continue
+		}
+		primary := idx[0]
+		for _, i := range idx[1:] {
+			if companionRank(files[i]) > companionRank(files[primary]) {
+				primary = i
+			}
+		}
+		_, primaryBase, _ := splitExtension(files[primary].SourceName)
+		key := filepath.Join(filepath.Dir(files[primary].RelPath), primaryBase)
+		for _, i := range idx {
+			ext, _, _ := splitExtension(files[i].SourceName)
+			files[i].Group = key
+			files[i].DateSource = files[primary].DateSource
+			files[i].ShotOffset = files[primary].ShotOffset
+			files[i].ClockFix = files[primary].ClockFix
+			files[i].Camera = files[primary].Camera
+			files[i].Hash = files[primary].Hash
+			files[i].place(dest, lay, files[primary].TakenAt, primaryBase, ext)
+		}
+	}
+}
+
+// isCompanionCandidate reports whether a file can be part of a Live Photo or
+// RAW+JPEG group. Edited variants are attached separately by attachVariants.
+func isCompanionCandidate(name string) bool {
+	ext, base, err := splitExtension(name)
+	if err != nil {
+		return false
+	}
+	if _, suffix := splitVariant(base); suffix != "" {
+		return false
+	}
+	switch strings.ToLower(ext) {
+	case "jpg", "jpeg", "heic", "mov", "mp4":
+		return true
+	}
+	return media.IsRaw(ext)
+}
+
+// companionRank orders candidates for a companion group's primary file.
+func companionRank(fp FilePlan) int {
+	rank := 0
+	switch fp.DateSource {
+	case DateFromExif:
+		rank += 8
+	case DateFromContainer:
+		rank += 4
+	case DateFromFilename:
+		rank += 2
+	}
+	if ext, _, _ := splitExtension(fp.SourceName); media.IsRaw(ext) {
+		rank++
+	}
+	return rank
+}
+
+// attachVariants places edited variants and sidecars next to their primary.
+// It runs after groupCompanions, so a sidecar of a Live Photo follows the
+// group's shared timestamp and basename.
+//
+//   - IMG_E1234.JPG (edited) → <prefix>-IMG_1234_EDITED.JPG
+//   - IMG_1234.AAE, IMG_1234.XMP → <prefix>-IMG_1234.AAE / .XMP
+//   - DSC0001.ARW.xmp → <prefix>-DSC0001.ARW.XMP
+//
+// Each joins its primary's group so it is reported as part of the same item.
+// Sidecars without a primary stay ClassOrphanSidecar; edited variants without
+// an original keep their own date and name.
+func attachVariants(files []FilePlan, lay layout.Layout) {
+	// Primaries by folder + basename and by folder + full name, upper-cased.
+	// Photos win over videos so IMG_1234.AAE follows IMG_1234.HEIC, not its MOV.
+	byBase := make(map[string]int)
+	byName := make(map[string]int)
+	for i, fp := range files {
+		if fp.Class != ClassProcessable {
+			continue
+		}
+		_, base, _ := splitExtension(fp.SourceName)
+		if _, suffix := splitVariant(base); suffix != "" {
+			continue
+		}
+		dir := filepath.Dir(fp.RelPath)
+		byName[filepath.Join(dir, strings.ToUpper(fp.SourceName))] = i
+		key := filepath.Join(dir, strings.ToUpper(base))
+		if j, ok := byBase[key]; !ok || variantRank(files[j]) < variantRank(fp) {
+			byBase[key] = i
+		}
+	}
+
+	for i := range files {
+		fp := &files[i]
+		ext, base, err := splitExtension(fp.SourceName)
+		if err != nil {
+			continue
+		}
+		dir := filepath.Dir(fp.RelPath)
+		sidecar := fp.Class == ClassOrphanSidecar
+
+		// DSC0001.ARW.xmp names its primary exactly.
+		if sidecar {
+			if p, ok := byName[filepath.Join(dir, strings.ToUpper(base))]; ok {
+				primary := files[p]
+				fp.follow(&files[p], filepath.Base(primary.DestPath)+"."+lay.Name.Ext(ext))
+				continue
+			}
+		}
+
+		original, suffix := splitVariant(base)
+		if !sidecar && (suffix == "" || fp.Class != ClassProcessable) {
+			continue
+		}
+		p, ok := byBase[filepath.Join(dir, strings.ToUpper(original))]
+		if !ok {
+			continue
+		}
+		primaryBase := strings.TrimSuffix(filepath.Base(files[p].DestPath), filepath.Ext(files[p].DestPath))
+		if suffix != "" {
+			primaryBase += "_" + suffix
+		}
+		fp.follow(&files[p], primaryBase+"."+lay.Name.Ext(ext))
+	}
+}
+
+// follow makes fp a member of primary's group, placed next to it as destName.
+func (fp *FilePlan) follow(primary *FilePlan, destName string) {
+	if primary.Group == "" {
+		_, base, _ := splitExtension(primary.SourceName)
+		primary.Group = filepath.Join(filepath.Dir(primary.RelPath), base)
+	}
+	fp.Class = ClassProcessable
+	fp.SkipReason = ""
+	fp.Group = primary.Group
+	fp.TakenAt = primary.TakenAt
+	fp.DateSource = primary.DateSource
+	fp.ShotOffset = primary.ShotOffset
+	fp.ClockFix = primary.ClockFix
+	fp.Camera = primary.Camera
+	fp.Seq = primary.Seq
+	fp.Hash = primary.Hash
+	fp.DestDir = primary.DestDir
+	fp.DestPath = filepath.Join(filepath.Dir(primary.DestPath), destName)
+}
+
+// variantRank prefers stills over videos as the primary that sidecars and
+// edited variants attach to.
+func variantRank(fp FilePlan) int {
+	ext, _, _ := splitExtension(fp.SourceName)
+	switch strings.ToLower(ext) {
+	case "mov", "mp4", "3gp":
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+// deviceOf is not known outside Unix, so files are never held back per
+// device there.
+func deviceOf(path string) (uint64, bool) {
+	return 0, false
+}
+
+func isRotational(dev uint64) bool {
+	return false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ExecOptions control how many files an import copies at once.
+type ExecOptions struct {
+	// Workers is the number of files copied, verified and moved at the same
+	// time. Values below 1 mean one at a time.
+	Workers int
+	// DeviceWorkers caps the files in flight on any one device, counting
+	// both the source and the destination. 0 means automatic: one at a time
+	// on spinning disks, where parallel reads only make the heads seek, and
+	// no cap on SSDs, NVMe and network shares.
+	DeviceWorkers int
+}
+
+// DefaultExecOptions returns the options the importer uses unless told
+// otherwise.
+func DefaultExecOptions() ExecOptions {
+	return ExecOptions{Workers: 4}
+}
+
+// Execute runs every file of the journal's plan on a pool of opts.Workers
+// goroutines and returns the results in plan order, whatever order they
+// finished in. done, when not nil, is called as each file finishes, from one
+// goroutine at a time.
+func (j *Journal) Execute(opts ExecOptions, done func(i int, res FileResult)) []FileResult {
+	plan := j.Plan()
+	results := make([]FileResult, len(plan.Files))
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	limits := newDeviceLimits(opts.DeviceWorkers)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fp := plan.Files[i]
+				release := func() {}
+				if fp.copies() {
+					release = limits.acquire(fp.SourcePath, fp.DestPath)
+				}
+				res := j.ExecuteOne(i)
+				release()
+
+				mu.Lock()
+				results[i] = res
+				if done != nil {
+					done(i, res)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range plan.Files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// deviceLimits hands out per-device slots so that files sharing a spinning
+// disk are copied one after another.
+type deviceLimits struct {
+	perDevice int // 0: automatic, see ExecOptions.DeviceWorkers
+
+	mu    sync.Mutex
+	slots map[uint64]chan struct{} // nil entry: no cap on that device
+}
+
+func newDeviceLimits(perDevice int) *deviceLimits {
+	return &deviceLimits{perDevice: perDevice, slots: make(map[uint64]chan struct{})}
+}
+
+// acquire takes a slot on the device of every path, waiting while they are
+// all in use, and returns the function that gives them back. Slots are
+// taken in device order so two files never wait on each other.
+func (d *deviceLimits) acquire(paths ...string) (release func()) {
+	var devs []uint64
+	for _, path := range paths {
+		if dev, ok := deviceOf(existingParent(path)); ok {
+			devs = append(devs, dev)
+		}
+	}
+	sort.Slice(devs, func(a, b int) bool { return devs[a] < devs[b] })
+
+	var held []chan struct{}
+	for k, dev := range devs {
+		if k > 0 && dev == devs[k-1] {
+			continue
+		}
+		if slot := d.slot(dev); slot != nil {
+			slot <- struct{}{}
+			held = append(held, slot)
+		}
+	}
+	return func() {
+		for _, slot := range held {
+			<-slot
+		}
+	}
+}
+
+// slot returns the semaphore of dev, or nil when dev is not capped.
+func (d *deviceLimits) slot(dev uint64) chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	slot, ok := d.slots[dev]
+	if !ok {
+		n := d.perDevice
+		if n == 0 && isRotational(dev) {
+			n = 1
+		}
+		if n > 0 {
+			slot = make(chan struct{}, n)
+		}
+		d.slots[dev] = slot
+	}
+	return slot
+}
+
+// existingParent returns path, or its nearest ancestor that exists: the
+// destination folder of a file may not have been created yet.
+func existingParent(path string) string {
+	for {
+		if exists(path) {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Exit codes, shared by the TUI and headless runs.
+const (
+	exitOK      = 0
+	exitFailed  = 1 // could not run: bad flags, unreadable directories, report not written
+	exitPartial = 2 // ran, but some files collided or failed to copy
+	exitAborted = 3 // declined at the prompt, or no one was there to confirm
+)
+
+// headlessOptions control an import run without the TUI, for cron jobs,
+// udev scripts and SSH pipes.
+type headlessOptions struct {
+	Dest   string        // destination; the parent of the source when empty
+	Report ReportOptions // where the report goes and in which formats
+	Exec   ExecOptions   // how many files are copied at once
+	Yes    bool          // import without asking
+	DryRun bool          // list what would happen and stop
+	Quiet  bool          // print only collisions and errors
+	// Resume and Rollback say what to do with an unfinished import found in
+	// the destination. With neither, the user is asked (or, with Yes, the run
+	// fails).
+	Resume   bool
+	Rollback bool
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// runHeadless scans source, asks on out for confirmation read from in (unless
+// h.Yes), then executes the plan one file per line of output. Problems go to
+// errOut. It returns the process exit code.
+func runHeadless(source string, opts ScanOptions, h headlessOptions, in io.Reader, out, errOut io.Writer) int {
+	dest := NormaliseDir(h.Dest)
+	if dest == "" {
+		dest = DefaultDest(source)
+	}
+	if err := ValidateDirectories(source, dest); err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailed
+	}
+
+	if j, err := OpenJournal(dest); err == nil {
+		return runUnfinished(j, h, in, out, errOut)
+	} else if !os.IsNotExist(err) {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailed
+	}
+
+	plan, err := ScanDir(source, dest, opts)
+	if err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailed
+	}
+	if h.DryRun {
+		printPlanFiles(out, plan)
+	}
+	if !h.Quiet {
+		printPlanSummary(out, plan)
+	}
+	if h.DryRun {
+		return exitOK
+	}
+
+	if !h.Yes && !confirm(in, out) {
+		fmt.Fprintln(errOut, "Aborted. Pass -yes to import without confirmation.")
+		return exitAborted
+	}
+
+	j, err := BeginJournal(plan)
+	if err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailed
+	}
+	return executeJournal(j, h, out, errOut)
+}
+
+// executeJournal runs every file of j's plan, writes the report and then
+// finishes the journal; if the report cannot be written the journal is kept.
+// Files are copied in parallel but printed in plan order.
+func executeJournal(j *Journal, h headlessOptions, out, errOut io.Writer) int {
+	plan := j.Plan()
+	report := &ImportReport{
+		StartedAt:   time.Now(),
+		Source:      plan.Source,
+		Destination: plan.Destination,
+		Output:      h.Report,
+	}
+	printed, waiting := 0, make(map[int]FileResult)
+	report.Results = j.Execute(h.Exec, func(i int, res FileResult) {
+		waiting[i] = res
+		for res, ok := waiting[printed]; ok; res, ok = waiting[printed] {
+			delete(waiting, printed)
+			printed++
+			printResult(out, errOut, printed, len(plan.Files), res, h.Quiet)
+		}
+	})
+	if err := catalogImport(report); err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+	}
+	// the journal is the only record of the import until the report is written
+	if err := FinaliseReport(report); err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailed
+	}
+	if err := j.Finish(); err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+	}
+	if !h.Quiet {
+		printReportSummary(out, report)
+	}
+	return reportExitCode(report)
+}
+
+// runUnfinished deals with the journal of an import that never finished:
+// resume it, roll it back, or (without -resume or -rollback) ask which.
+func runUnfinished(j *Journal, h headlessOptions, in io.Reader, out, errOut io.Writer) int {
+	done, total := j.Progress()
+	fmt.Fprintf(errOut, "Unfinished import from %s into %s, started %s: %d of %d files done.\n",
+		j.Source, j.Destination, j.StartedAt.Local().Format("2006-01-02 15:04:05"), done, total)
+	resume, rollback := h.Resume, h.Rollback
+	switch {
+	case resume && rollback:
+		fmt.Fprintln(errOut, "Error: pass only one of -resume and -rollback.")
+		return exitFailed
+	case h.DryRun:
+		fmt.Fprintln(errOut, "Run with -resume or -rollback to finish it.")
+		return exitOK
+	case !resume && !rollback && h.Yes:
+		fmt.Fprintln(errOut, "Error: pass -resume or -rollback to deal with it first.")
+		return exitFailed
+	case !resume && !rollback:
+		fmt.Fprint(out, "Resume it, roll it back, or quit? [r/b/Q]: ")
+		line, _ := bufio.NewReader(in).ReadString('\n')
+		fmt.Fprintln(out)
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "r", "resume":
+			resume = true
+		case "b", "rollback":
+			rollback = true
+		default:
+			fmt.Fprintln(errOut, "Aborted. The journal is kept; pass -resume or -rollback.")
+			return exitAborted
+		}
+	}
+
+	if resume {
+		return executeJournal(j, h, out, errOut)
+	}
+	report := j.Rollback()
+	for _, res := range report.Results {
+		switch {
+		case res.Err != nil:
+			fmt.Fprintf(errOut, "%s: error: %v\n", res.Dest, res.Err)
+		case !h.Quiet:
+			fmt.Fprintf(out, "rolled back %s\n", res.Source)
+		}
+	}
+	if err := FinaliseUndoReport(report, j.Destination); err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailed
+	}
+	if !h.Quiet {
+		fmt.Fprintf(out, "Rolled back: %d  Errors: %d\nReport written to %s\n",
+			report.Restored(), len(report.Errors()), report.ReportPath)
+	}
+	if len(report.Errors()) > 0 {
+		return exitPartial
+	}
+	return exitOK
+}
+
+// confirm asks "Proceed? [y/N]" and reads one line. End of input is a no.
+func confirm(in io.Reader, out io.Writer) bool {
+	fmt.Fprint(out, "Proceed? [y/N]: ")
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	fmt.Fprintln(out)
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// reportExitCode is exitPartial when any file collided or failed, else exitOK.
+func reportExitCode(r *ImportReport) int {
+	if len(r.Collisions()) > 0 || len(r.Errors()) > 0 {
+		return exitPartial
+	}
+	return exitOK
+}
+
+// printPlanFiles lists what an import would do with every file, for -dry-run.
+func printPlanFiles(out io.Writer, p *ImportPlan) {
+	for _, fp := range p.Files {
+		switch {
+		case fp.Class == ClassProcessable:
+			fmt.Fprintf(out, "copy    %s → %s\n", fp.RelPath, fp.DestPath)
+		case fp.Class == ClassSuspiciousDate && fp.DestPath != "":
+			fmt.Fprintf(out, "review  %s → %s  (%s)\n", fp.RelPath, fp.DestPath, fp.SkipReason)
+		case fp.Class == ClassSuspiciousDate:
+			fmt.Fprintf(out, "hold    %s  (%s)\n", fp.RelPath, fp.SkipReason)
+		default:
+			fmt.Fprintf(out, "skip    %s  (%s)\n", fp.RelPath, fp.SkipReason)
+		}
+	}
+}
+
+// printPlanSummary is the plain-text counterpart of viewPlan.
+func printPlanSummary(out io.Writer, p *ImportPlan) {
+	items, suspicious, skipped := 0, 0, 0
+	for _, n := range p.Groups {
+		items += n
+	}
+	for _, fp := range p.Files {
+		switch fp.Class {
+		case ClassProcessable:
+		case ClassSuspiciousDate:
+			suspicious++
+		default:
+			skipped++
+		}
+	}
+	fmt.Fprintf(out, "Found %d processable items in %s\n", items, p.Source)
+	dirs := make([]string, 0, len(p.Groups))
+	for d := range p.Groups {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	for _, d := range dirs {
+		fmt.Fprintf(out, "  → %s%s  (%d items)\n", p.Destination, d, p.Groups[d])
+	}
+	if suspicious > 0 {
+		fmt.Fprintf(out, "Suspicious dates: %d file(s)\n", suspicious)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(out, "Skipped: %d file(s)\n", skipped)
+	}
+}
+
+// printResult prints one line per file that was copied or went wrong, e.g.
+// "[3/120] IMG_1234.HEIC → /lib/2024/03/...". Skipped files are left to the
+// report. Collisions and errors go to errOut, even when quiet.
+func printResult(out, errOut io.Writer, n, total int, res FileResult, quiet bool) {
+	prefix := fmt.Sprintf("[%d/%d] %s", n, total, res.Plan.RelPath)
+	switch {
+	case res.Err != nil:
+		fmt.Fprintf(errOut, "%s: error: %v\n", prefix, res.Err)
+	case res.Collision:
+		fmt.Fprintf(errOut, "%s: collision, a different file exists at %s\n", prefix, res.Plan.DestPath)
+	case res.Succeeded && !quiet:
+		fmt.Fprintf(out, "%s → %s\n", prefix, res.Plan.DestPath)
+	}
+}
+
+// printReportSummary is the plain-text counterpart of viewReport.
+func printReportSummary(out io.Writer, r *ImportReport) {
+	fmt.Fprintf(out, "Done. Processed: %d  Skipped: %d  Suspicious: %d  Collisions: %d  Errors: %d\n",
+		r.Processed(), len(r.Skipped()), len(r.Suspicious()), len(r.Collisions()), len(r.Errors()))
+	fmt.Fprintf(out, "Report written to %s\n", strings.Join(r.ReportPaths, ", "))
+}
@@ -3,18 +3,21 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"time"
-	"unicode"
 
-	"github.com/rwcarlsen/goexif/exif"
-	"github.com/rwcarlsen/goexif/mknote"
+	"github.com/cemeng/photos-organiser/internal/catalog"
+	"github.com/cemeng/photos-organiser/internal/copyfile"
+	"github.com/cemeng/photos-organiser/internal/layout"
+	"github.com/cemeng/photos-organiser/internal/media"
 )
 
 var alreadyProcessedPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-`)
@@ -26,58 +29,204 @@ const (
 	ClassProcessable FileClass = iota
 	ClassAlreadyProcessed
 	ClassUnsupported
+	ClassOrphanSidecar  // .AAE/.XMP sidecar whose photo is not part of the import
+	ClassSuspiciousDate // date looks wrong; copied to review/ or held back
+	ClassInLibrary      // same content is already in the library's catalog
 )
 
+// String names the class in machine-readable reports.
+func (c FileClass) String() string {
+	switch c {
+	case ClassProcessable:
+		return "processable"
+	case ClassAlreadyProcessed:
+		return "already-processed"
+	case ClassUnsupported:
+		return "unsupported"
+	case ClassOrphanSidecar:
+		return "orphan-sidecar"
+	case ClassSuspiciousDate:
+		return "suspicious-date"
+	case ClassInLibrary:
+		return "in-library"
+	}
+	return fmt.Sprintf("FileClass(%d)", int(c))
+}
+
+// DateSource records where a file's capture date came from.
+type DateSource string
+
+const (
+	DateFromExif      DateSource = "exif"      // EXIF DateTimeOriginal (JPEG, HEIC, RAW)
+	DateFromContainer DateSource = "container" // MOV/MP4/3GP movie header or Apple metadata
+	DateFromFilename  DateSource = "filename"  // date in the name, e.g. IMG-20240315-WA0001.jpg
+	DateFromPath      DateSource = "path"      // date in a folder name, e.g. "2024-03-15 Trip/"
+	DateFromModTime   DateSource = "mtime"     // file modification time fallback
+)
+
+// processedDirName is the folder inside the source that originals are moved to.
+const processedDirName = "processed"
+
 // FilePlan describes what will happen to one source file.
 type FilePlan struct {
-	SourceName string    // original filename, e.g. IMG_1234.JPG
-	SourcePath string    // full path to source file
-	DestPath   string    // full destination path after rename
-	DestDir    string    // YYYY/MM directory relative to dest root, e.g. "2024/03"
-	Class      FileClass // how the file was classified
-	SkipReason string    // set when Class != ClassProcessable
+	SourceName string        // original filename, e.g. IMG_1234.JPG
+	SourcePath string        // full path to source file
+	RelPath    string        // path relative to the source root, e.g. DCIM/100APPLE/IMG_1234.JPG
+	DestPath   string        // full destination path after rename
+	DestDir    string        // directory relative to dest root rendered from the layout, e.g. "2024/03"
+	TakenAt    time.Time     // capture time DestDir and DestPath were built from
+	Camera     string        // EXIF camera model, for {camera}
+	Seq        int           // per-day sequence number, for {seq}; 0 unless the layout uses it
+	Hash       string        // SHA-256 of the content, for {hash}; empty unless the layout uses it
+	DateSource DateSource    // where TakenAt came from
+	ShotOffset string        // UTC offset recorded in the file (EXIF OffsetTimeOriginal, Apple creationdate), e.g. "+02:00"; empty when none
+	ClockFix   time.Duration // correction from the clock table added to the camera's time; zero when none
+	ContentID  string        // Apple ContentIdentifier shared by Live Photo halves
+	Group      string        // key shared by companion files (Live Photo, RAW+JPEG); empty when standalone
+	Class      FileClass     // how the file was classified
+	SkipReason string        // set when Class != ClassProcessable; why the date is suspect for ClassSuspiciousDate
+	KeepSource bool          // leave the original in place after copying instead of moving it to processed/
+	TrustFsync bool          // skip re-reading the copy; trust the hash taken while copying
+
+	base string // basename the destination name is rendered from; the primary's for companions
+}
+
+// copies reports whether executing fp copies it somewhere: processable files,
+// and suspicious files routed to review/.
+func (fp FilePlan) copies() bool {
+	return fp.DestPath != "" && (fp.Class == ClassProcessable || fp.Class == ClassSuspiciousDate)
 }
 
 // FileResult records what actually happened during execution.
 type FileResult struct {
-	Plan      FilePlan
-	Succeeded bool
-	Collision bool // dest existed with different content
-	Err       error
+	Plan        FilePlan
+	Succeeded   bool
+	Collision   bool // dest existed with different content
+	Existed     bool // dest existed before the copy (with the same content unless Collision)
+	Err         error
+	SourceHash  string        // SHA-256 of the original; empty if it was never compared
+	DestHash    string        // SHA-256 of the file found at DestPath after copying
+	MovedTo     string        // where the original was moved, under processed/; empty if it stayed put
+	CreatedDirs []string      // folders made for the copy or under processed/, which undo may remove
+	Duration    time.Duration // time spent copying, verifying and moving
+}
+
+// Status summarises what happened to the file: copied, review (copied to
+// review/), held, skipped, collision or error.
+func (res FileResult) Status() string {
+	switch {
+	case res.Err != nil:
+		return "error"
+	case res.Collision:
+		return "collision"
+	case res.Succeeded && res.Plan.Class == ClassSuspiciousDate:
+		return "review"
+	case res.Succeeded:
+		return "copied"
+	case res.Plan.Class == ClassSuspiciousDate:
+		return "held"
+	}
+	return "skipped"
+}
+
+// ScanOptions controls how ScanDir walks the source directory.
+type ScanOptions struct {
+	// Recursive walks nested folders (e.g. DCIM/100APPLE, DCIM/101APPLE)
+	// instead of only the top level. processed/ folders are always skipped.
+	Recursive bool
+	// Suspicious configures the bad-date checks. The zero value only flags
+	// future dates and holds nothing back; see DefaultSuspiciousOptions.
+	Suspicious SuspiciousOptions
+	// Zone is the time zone filenames and YYYY/MM folders are expressed in.
+	// Nil keeps each file's own offset where it records one ("as shot") and
+	// reads naive EXIF times and mtimes in the local zone.
+	Zone *time.Location
+	// Clocks corrects cameras whose clocks are known to be wrong. It applies
+	// to EXIF dates only, matched on the photo's Make, Model and serial.
+	Clocks ClockTable
+	// DatePatterns are tried before media.DefaultDatePatterns when a file
+	// has no embedded date.
+	DatePatterns []media.DatePattern
+	// Layout renders each file's folder and name. The zero value means
+	// layout.Default(): YYYY/MM/YYYY-MM-DD-HH-mm-<NAME>.<EXT>.
+	Layout layout.Layout
+	// Extensions limits the import to these lower-case extensions. Listed
+	// extensions the importer has no reader for are dated from their name,
+	// folder or mod time, like PNGs. Nil imports every supported extension.
+	// Sidecars always follow their primary.
+	Extensions []string
+	// KeepOriginals leaves originals in the source after they are copied
+	// instead of moving them to processed/.
+	KeepOriginals bool
+	// TrustFsync skips re-reading each copy to verify it: the original's
+	// SHA-256 is taken while copying and the copy is fsynced, so only a
+	// disk that lies about fsync could hold a bad copy. Files that were
+	// already at the destination are still compared in full.
+	TrustFsync bool
 }
 
 // ImportPlan is the full plan produced by ScanDir.
 type ImportPlan struct {
 	Source      string
 	Destination string
+	Options     ScanOptions
 	Files       []FilePlan
-	// Grouped summary: destDir → count, for display
+	// Grouped summary: destDir → item count, for display. Companion files
+	// (e.g. a Live Photo's HEIC and MOV) count as one item.
 	Groups map[string]int
 }
 
 // ImportReport is the final result of Execute.
 type ImportReport struct {
 	StartedAt   time.Time
+	FinishedAt  time.Time // set by FinaliseReport when zero
 	Source      string
 	Destination string
 	Results     []FileResult
-	ReportPath  string
+	Output      ReportOptions
+	ReportPath  string   // the first report written, the text one by default
+	ReportPaths []string // every report written, one per format
+	RecordPath  string   // the JSON record undo reads; hidden unless JSON was asked for
 }
 
+// Processed counts files copied into the library (not review/).
 func (r *ImportReport) Processed() int {
 	n := 0
 	for _, res := range r.Results {
-		if res.Succeeded {
+		if res.Succeeded && res.Plan.Class == ClassProcessable {
 			n++
 		}
 	}
 	return n
 }
 
+// OrphanSidecars returns sidecars that had no primary to follow.
+func (r *ImportReport) OrphanSidecars() []FileResult {
+	var out []FileResult
+	for _, res := range r.Results {
+		if res.Plan.Class == ClassOrphanSidecar {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// Suspicious returns files whose date looked wrong, whether they were copied
+// to review/ or held back in the source.
+func (r *ImportReport) Suspicious() []FileResult {
+	var out []FileResult
+	for _, res := range r.Results {
+		if res.Plan.Class == ClassSuspiciousDate {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
 func (r *ImportReport) Skipped() []FileResult {
 	var out []FileResult
 	for _, res := range r.Results {
-		if res.Plan.Class != ClassProcessable {
+		if res.Plan.Class != ClassProcessable && res.Plan.Class != ClassSuspiciousDate {
 			out = append(out, res)
 		}
 	}
@@ -139,50 +288,134 @@ func ValidateDirectories(src, dest string) error {
 	return nil
 }
 
-// ScanDir classifies all top-level files in src and builds an ImportPlan.
-func ScanDir(src, dest string) (*ImportPlan, error) {
-	entries, err := os.ReadDir(src)
+// ScanDir classifies all files in src and builds an ImportPlan.
+// Only top-level files are considered unless opts.Recursive is set.
+func ScanDir(src, dest string, opts ScanOptions) (*ImportPlan, error) {
+	relPaths, err := listSourceFiles(src, opts.Recursive)
 	if err != nil {
 		return nil, fmt.Errorf("reading source directory: %w", err)
 	}
+	if opts.Layout == (layout.Layout{}) {
+		opts.Layout = layout.Default()
+	}
+	cat, err := catalog.Open(dest)
+	if err != nil {
+		return nil, fmt.Errorf("reading library catalog: %w", err)
+	}
 
 	plan := &ImportPlan{
 		Source:      src,
 		Destination: dest,
+		Options:     opts,
 		Groups:      make(map[string]int),
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() || entry.Name() == ".DS_Store" {
-			continue
-		}
-
-		fp, err := classifyFile(src, dest, entry.Name())
+	for _, rel := range relPaths {
+		fp, err := classifyFile(src, dest, rel, opts)
 		if err != nil {
 			// non-fatal: treat as unsupported
 			fp = FilePlan{
-				SourceName: entry.Name(),
-				SourcePath: filepath.Join(src, entry.Name()),
+				SourceName: filepath.Base(rel),
+				SourcePath: filepath.Join(src, rel),
+				RelPath:    rel,
 				Class:      ClassUnsupported,
 				SkipReason: err.Error(),
 			}
 		}
+		fp.KeepSource = opts.KeepOriginals
+		fp.TrustFsync = opts.TrustFsync
 		plan.Files = append(plan.Files, fp)
-		if fp.Class == ClassProcessable {
-			plan.Groups[fp.DestDir]++
-		}
 	}
 
+	markInLibrary(plan.Files, cat)
+	groupCompanions(plan.Files, dest, opts.Layout)
+	if opts.Layout.Uses(layout.FieldSeq) {
+		numberByDay(plan.Files, dest, opts.Layout)
+	}
+	attachVariants(plan.Files, opts.Layout)
+	flagSuspicious(plan.Files, dest, opts.Suspicious, time.Now())
+	plan.Groups = countGroups(plan.Files)
+
 	return plan, nil
 }
 
-func classifyFile(src, dest, name string) (FilePlan, error) {
+// countGroups counts the items going into each destination directory;
+// companion files are one item.
+func countGroups(files []FilePlan) map[string]int {
+	groups := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, fp := range files {
+		if fp.Class != ClassProcessable {
+			continue
+		}
+		if fp.Group != "" {
+			if seen[fp.Group] {
+				continue
+			}
+			seen[fp.Group] = true
+		}
+		groups[fp.DestDir]++
+	}
+	return groups
+}
+
+// listSourceFiles returns the paths, relative to src, of the files to classify.
+// Directories named processed/ are never descended into, so originals moved
+// there by an earlier import are not picked up again.
+func listSourceFiles(src string, recursive bool) ([]string, error) {
+	if !recursive {
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return nil, err
+		}
+		var out []string
+		for _, entry := range entries {
+			if entry.IsDir() || entry.Name() == ".DS_Store" {
+				continue
+			}
+			out = append(out, entry.Name())
+		}
+		return out, nil
+	}
+
+	var out []string
+	err := filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == processedDirName && filepath.Clean(path) != filepath.Clean(src) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == ".DS_Store" {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		out = append(out, rel)
+		return nil
+	})
+	return out, err
+}
+
+// classifyFile classifies the file at rel (relative to src) and works out its destination.
+// EXIF dates are corrected with opts.Clocks, then converted to opts.Zone when set.
+func classifyFile(src, dest, rel string, opts ScanOptions) (FilePlan, error) {
+	name := filepath.Base(rel)
+	path := filepath.Join(src, rel)
 	fp := FilePlan{
 		SourceName: name,
-		SourcePath: filepath.Join(src, name),
+		SourcePath: path,
+		RelPath:    rel,
 	}
 
-	if alreadyProcessedPattern.MatchString(name) {
+	// A template without date fields matches almost any name, so only a
+	// dated match counts as already processed.
+	if f, ok := opts.Layout.Name.Match(name, time.Local); (ok && !f.Time.IsZero()) || alreadyProcessedPattern.MatchString(name) {
 		fp.Class = ClassAlreadyProcessed
 		fp.SkipReason = "already processed"
 		return fp, nil
@@ -195,44 +428,139 @@ func classifyFile(src, dest, name string) (FilePlan, error) {
 		return fp, nil
 	}
 
-	extLower := strings.ToLower(ext)
-	var t time.Time
+	// Sidecars are placed by attachVariants once their primary is known.
+	if isSidecar(ext) {
+		fp.Class = ClassOrphanSidecar
+		fp.SkipReason = "sidecar without a matching photo"
+		return fp, nil
+	}
 
-	switch extLower {
-	case "jpg", "jpeg", "heic":
-		t, err = timeFromExif(filepath.Join(src, name))
-		if err != nil {
-			// fallback to mod time
-			t, err = timeFromModTime(filepath.Join(src, name))
-			if err != nil {
-				fp.Class = ClassUnsupported
-				fp.SkipReason = fmt.Sprintf("could not determine date: %v", err)
-				return fp, nil
-			}
-		}
-	case "mov", "png", "mp4", "3gp":
-		t, err = timeFromModTime(filepath.Join(src, name))
-		if err != nil {
-			fp.Class = ClassUnsupported
-			fp.SkipReason = fmt.Sprintf("could not read mod time: %v", err)
-			return fp, nil
-		}
+	extLower := strings.ToLower(ext)
+	if opts.Extensions != nil && !slices.Contains(opts.Extensions, extLower) {
+		fp.Class = ClassUnsupported
+		fp.SkipReason = fmt.Sprintf("extension .%s not in the configured extensions", ext)
+		return fp, nil
+	}
+	var info captureInfo
+
+	switch {
+	case extLower == "jpg", extLower == "jpeg", extLower == "heic", media.IsRaw(extLower):
+		info, err = captureFromExif(path, opts.Zone)
+	case extLower == "mov", extLower == "mp4", extLower == "3gp":
+		info, err = captureFromContainer(path)
+	case extLower == "png":
+		err = media.ErrNoDate // PNGs carry no date we read
+	case opts.Extensions != nil:
+		err = media.ErrNoDate // configured, but no reader for it
 	default:
 		fp.Class = ClassUnsupported
 		fp.SkipReason = fmt.Sprintf("unsupported extension: .%s", ext)
 		return fp, nil
 	}
+	if err != nil {
+		// no embedded date — try the name and folders, then mod time
+		info.Time, info.Source, err = fallbackDate(src, rel, opts)
+		if err != nil {
+			fp.Class = ClassUnsupported
+			fp.SkipReason = fmt.Sprintf("could not determine date: %v", err)
+			return fp, nil
+		}
+	}
 
-	destFilename := buildDestFilename(t, base, ext)
-	destDir := fmt.Sprintf("%04d/%02d", t.Year(), t.Month())
-	destPath := filepath.Join(dest, destDir, destFilename)
+	if info.Source == DateFromExif {
+		if c, ok := opts.Clocks.Lookup(info.Camera); ok {
+			info.Time = info.Time.Add(c.Offset)
+			fp.ClockFix = c.Offset
+		}
+	}
+	if opts.Zone != nil {
+		info.Time = info.Time.In(opts.Zone)
+	}
+
+	if opts.Layout.Uses(layout.FieldHash) {
+		if fp.Hash, err = fileHash(path); err != nil {
+			fp.Class = ClassUnsupported
+			fp.SkipReason = fmt.Sprintf("could not hash: %v", err)
+			return fp, nil
+		}
+	}
 
 	fp.Class = ClassProcessable
-	fp.DestDir = destDir
-	fp.DestPath = destPath
+	fp.DateSource = info.Source
+	fp.ShotOffset = info.Offset
+	fp.Camera = info.Camera.Model
+	fp.ContentID = info.ContentID
+	fp.place(dest, opts.Layout, info.Time, base, ext)
 	return fp, nil
 }
 
+// place sets fp's capture time and renders DestDir and DestPath from the
+// layout, naming the file after base with its own extension.
+func (fp *FilePlan) place(dest string, lay layout.Layout, t time.Time, base, ext string) {
+	fp.TakenAt = t
+	fp.base = base
+	dir, name := lay.Render(layout.Fields{
+		Time:   t,
+		Name:   base,
+		Ext:    ext,
+		Camera: fp.Camera,
+		Seq:    fp.Seq,
+		Hash:   fp.Hash,
+	})
+	fp.DestDir = dir
+	fp.DestPath = filepath.Join(dest, filepath.FromSlash(dir), name)
+}
+
+// numberByDay gives each item a sequence number within its capture day, in
+// capture order, and re-renders its destination. Companions share their
+// primary's number. Numbers start at 1 for every import; a name already
+// taken by an earlier import shows up as a collision.
+func numberByDay(files []FilePlan, dest string, lay layout.Layout) {
+	var items []int
+	seen := make(map[string]bool)
+	for i, fp := range files {
+		if fp.Class != ClassProcessable {
+			continue
+		}
+		if fp.Group != "" {
+			if seen[fp.Group] {
+				continue
+			}
+			seen[fp.Group] = true
+		}
+		items = append(items, i)
+	}
+	sort.SliceStable(items, func(a, b int) bool {
+		fa, fb := files[items[a]], files[items[b]]
+		if !fa.TakenAt.Equal(fb.TakenAt) {
+			return fa.TakenAt.Before(fb.TakenAt)
+		}
+		return fa.RelPath < fb.RelPath
+	})
+
+	next := make(map[string]int)
+	seq := make(map[string]int) // group key → number
+	for _, i := range items {
+		day := files[i].TakenAt.Format("2006-01-02")
+		next[day]++
+		files[i].Seq = next[day]
+		if files[i].Group != "" {
+			seq[files[i].Group] = next[day]
+		}
+	}
+	for i := range files {
+		fp := &files[i]
+		if fp.Class != ClassProcessable {
+			continue
+		}
+		if fp.Group != "" {
+			fp.Seq = seq[fp.Group]
+		}
+		ext, _, _ := splitExtension(fp.SourceName)
+		fp.place(dest, lay, fp.TakenAt, fp.base, ext)
+	}
+}
+
 // splitExtension returns (ext, base, error) for a filename.
 // Rejects files with no extension or multiple dots in a way that is ambiguous.
 func splitExtension(name string) (ext, base string, err error) {
@@ -248,43 +576,75 @@ func splitExtension(name string) (ext, base string, err error) {
 	return ext, base, nil
 }
 
-// buildDestFilename produces YYYY-MM-DD-HH-mm-<sanitized-base>.<EXT>
-func buildDestFilename(t time.Time, base, ext string) string {
-	sanitized := sanitizeBasename(base)
-	return fmt.Sprintf("%04d-%02d-%02d-%02d-%02d-%s.%s",
-		t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(),
-		sanitized, strings.ToUpper(ext))
+// captureInfo is what a file's embedded metadata says about its capture.
+type captureInfo struct {
+	Time      time.Time
+	Source    DateSource
+	Offset    string // UTC offset recorded alongside Time; empty when the file has none
+	Camera    media.Camera
+	ContentID string
 }
 
-// sanitizeBasename uppercases and replaces non-alphanumeric characters with _.
-func sanitizeBasename(name string) string {
-	var b strings.Builder
-	for _, r := range strings.ToUpper(name) {
-		if unicode.IsLetter(r) || unicode.IsDigit(r) {
-			b.WriteRune(r)
-		} else {
-			b.WriteRune('_')
-		}
+// captureFromExif reads DateTimeOriginal and its offset tag. Times without an
+// offset are taken to be wall-clock time in zone, or the local zone if nil.
+func captureFromExif(path string, zone *time.Location) (captureInfo, error) {
+	data, err := media.DecodeExif(path)
+	if err != nil {
+		return captureInfo{}, err
+	}
+	naive := zone
+	if naive == nil {
+		naive = time.Local
 	}
-	// collapse consecutive underscores
-	result := regexp.MustCompile(`_+`).ReplaceAllString(b.String(), "_")
-	// trim leading/trailing underscores
-	return strings.Trim(result, "_")
+	t, hasOffset, err := media.ExifCaptureTime(data, naive)
+	if err != nil {
+		return captureInfo{}, err
+	}
+	info := captureInfo{Time: t, Source: DateFromExif, Camera: media.CameraOf(data), ContentID: media.AppleContentID(data)}
+	if hasOffset {
+		info.Offset = media.FormatOffset(t)
+	}
+	return info, nil
 }
 
-func timeFromExif(path string) (time.Time, error) {
-	f, err := os.Open(path)
+func captureFromContainer(path string) (captureInfo, error) {
+	info, err := media.ReadVideo(path)
 	if err != nil {
-		return time.Time{}, err
+		return captureInfo{}, err
 	}
-	defer f.Close()
+	if info.CreationTime.IsZero() {
+		// keep the identifier so a Live Photo MOV still pairs with its still
+		return captureInfo{ContentID: info.ContentIdentifier}, media.ErrNoDate
+	}
+	c := captureInfo{Time: info.CreationTime, Source: DateFromContainer, ContentID: info.ContentIdentifier}
+	if info.HasOffset {
+		c.Offset = media.FormatOffset(info.CreationTime)
+	}
+	return c, nil
+}
 
-	exif.RegisterParsers(mknote.All...)
-	data, err := exif.Decode(f)
-	if err != nil {
-		return time.Time{}, err
+// fallbackDate dates the file at rel under root with no embedded date: from
+// a date in its name (see media.DefaultDatePatterns, after
+// opts.DatePatterns), then in the names of its folders below root, then from
+// its mod time. Folders above root, such as a backup volume named by date,
+// say nothing about the file.
+func fallbackDate(root, rel string, opts ScanOptions) (time.Time, DateSource, error) {
+	naive := opts.Zone
+	if naive == nil {
+		naive = time.Local
+	}
+	name := filepath.Base(rel)
+	patterns := append(append([]media.DatePattern{}, opts.DatePatterns...), media.DefaultDatePatterns...)
+	if t, _, ok := media.DateFromName(name, patterns, naive); ok {
+		return t, DateFromFilename, nil
+	}
+	if dir := filepath.Dir(rel); dir != "." {
+		if t, ok := media.DateFromPath(dir, naive); ok {
+			return t, DateFromPath, nil
+		}
 	}
-	return data.DateTime()
+	t, err := timeFromModTime(filepath.Join(root, rel))
+	return t, DateFromModTime, err
 }
 
 func timeFromModTime(path string) (time.Time, error) {
@@ -298,96 +658,196 @@ func timeFromModTime(path string) (time.Time, error) {
 // ExecuteOne processes a single FilePlan and returns the result.
 // Call this for each file in plan.Files, then call FinaliseReport when all done.
 func ExecuteOne(fp FilePlan, src string) FileResult {
-	if fp.Class != ClassProcessable {
+	if !fp.copies() {
 		return FileResult{Plan: fp}
 	}
-	return executeFile(fp, src)
+	start := time.Now()
+	result := executeFile(fp, src, nil, 0)
+	result.Duration = time.Since(start)
+	return result
 }
 
-// FinaliseReport writes the report file and attaches the path to the report.
+// FinaliseReport writes the report files (see ReportOptions) and attaches
+// their paths to the report.
 func FinaliseReport(report *ImportReport) error {
-	reportPath, err := writeReport(report)
+	if report.FinishedAt.IsZero() {
+		report.FinishedAt = time.Now()
+	}
+	paths, record, err := writeReports(report)
 	if err != nil {
 		return fmt.Errorf("writing report: %w", err)
 	}
-	report.ReportPath = reportPath
+	report.ReportPaths = paths
+	report.ReportPath = paths[0]
+	report.RecordPath = record
 	return nil
 }
 
-func executeFile(fp FilePlan, src string) FileResult {
+// executeFile copies, verifies and moves fp, recording each step as file i
+// of j (when not nil).
+func executeFile(fp FilePlan, src string, j *Journal, i int) FileResult {
 	result := FileResult{Plan: fp}
 
 	// Ensure destination directory exists
-	if err := os.MkdirAll(filepath.Dir(fp.DestPath), 0755); err != nil {
+	created, err := mkdirs(filepath.Dir(fp.DestPath))
+	result.CreatedDirs = created
+	if err != nil {
 		result.Err = fmt.Errorf("creating dest dir: %w", err)
 		return result
 	}
 
-	// Copy preserving attributes; -n means no overwrite if dest exists.
-	// On macOS, cp -n returns exit 1 when a file was skipped due to -n,
-	// so we don't treat a non-zero exit as a fatal error here.
-	exec.Command("cp", "-an", fp.SourcePath, fp.DestPath).Run() //nolint
-
-	// Check whether dest exists at all — if not, the copy genuinely failed.
-	if _, err := os.Stat(fp.DestPath); os.IsNotExist(err) {
-		result.Err = fmt.Errorf("destination file missing after copy attempt")
+	// Copy preserving attributes, never overwriting, hashing the original as
+	// it streams so it is read only once. A file already there is either
+	// identical (and not ours to delete on undo) or a collision.
+	hash := sha256.New()
+	if err := copyfile.CopyTo(fp.SourcePath, fp.DestPath, hash); errors.Is(err, copyfile.ErrExists) {
+		result.Existed = true
+	} else if err != nil {
+		result.Err = err
 		return result
+	} else {
+		result.SourceHash = hex.EncodeToString(hash.Sum(nil))
 	}
-
-	// Dest exists — determine whether it's our copy or a pre-existing collision.
-	collision, err := isCollision(fp.SourcePath, fp.DestPath)
-	if err != nil {
-		result.Err = fmt.Errorf("verifying copy: %w", err)
+	if result.Err = j.record(i, stepCopied, result); result.Err != nil {
 		return result
 	}
-	if collision {
-		result.Collision = true
+
+	if result.Existed {
+		// Determine whether it's the same file or a pre-existing collision.
+		collision, srcHash, destHash, err := isCollision(fp.SourcePath, fp.DestPath)
+		result.SourceHash, result.DestHash = srcHash, destHash
+		if err != nil {
+			result.Err = fmt.Errorf("verifying copy: %w", err)
+			return result
+		}
+		if collision {
+			result.Collision = true
+			return result
+		}
+	} else if result.Err = verifyCopy(&result); result.Err != nil {
 		return result
 	}
-
-	// Move original to processed/
-	processedDir := filepath.Join(src, "processed")
-	if err := os.MkdirAll(processedDir, 0755); err != nil {
-		result.Err = fmt.Errorf("creating processed dir: %w", err)
+	if result.Err = j.record(i, stepVerified, result); result.Err != nil {
 		return result
 	}
-	dest := filepath.Join(processedDir, fp.SourceName)
-	if err := os.Rename(fp.SourcePath, dest); err != nil {
-		result.Err = fmt.Errorf("moving to processed: %w", err)
+	if fp.KeepSource {
+		result.Succeeded = true
 		return result
 	}
+	moveOriginal(&result, src, j, i)
+	return result
+}
 
+// moveOriginal moves a verified file's original to processed/, mirroring its
+// location inside the source, and marks the result succeeded.
+func moveOriginal(result *FileResult, src string, j *Journal, i int) {
+	fp := result.Plan
+	dest := processedPath(src, fp)
+	created, err := mkdirs(filepath.Dir(dest))
+	result.CreatedDirs = append(result.CreatedDirs, created...)
+	if err != nil {
+		result.Err = fmt.Errorf("creating processed dir: %w", err)
+		return
+	}
+	// A crash may have come between the move and recording it.
+	if _, err := os.Lstat(fp.SourcePath); j == nil || !os.IsNotExist(err) || !exists(dest) {
+		if err := os.Rename(fp.SourcePath, dest); err != nil {
+			result.Err = fmt.Errorf("moving to processed: %w", err)
+			return
+		}
+	}
+	result.MovedTo = dest
+	if result.Err = j.record(i, stepMoved, *result); result.Err != nil {
+		return
+	}
 	result.Succeeded = true
-	return result
 }
 
-// isCollision returns true when dest exists but has different content from src.
+// mkdirs creates dir and any missing parents, like os.MkdirAll, and
+// returns the folders it made, outermost first. Each is made with os.Mkdir,
+// so when workers race to make the same folder only one reports it.
+func mkdirs(dir string) ([]string, error) {
+	var missing []string
+	for d := filepath.Clean(dir); !exists(d); d = filepath.Dir(d) {
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	var created []string
+	for i := len(missing) - 1; i >= 0; i-- {
+		err := os.Mkdir(missing[i], 0755)
+		if err == nil {
+			created = append(created, missing[i])
+		} else if !os.IsExist(err) {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// verifyCopy sets the DestHash of a fresh copy: the SHA-256 of the file read
+// back from disk, or with TrustFsync the hash taken while copying. A copy that
+// does not match the original is deleted.
+func verifyCopy(result *FileResult) error {
+	fp := result.Plan
+	if fp.TrustFsync {
+		result.DestHash = result.SourceHash
+		return nil
+	}
+	destHash, err := fileHash(fp.DestPath)
+	if err != nil {
+		return fmt.Errorf("verifying copy: %w", err)
+	}
+	result.DestHash = destHash
+	if destHash != result.SourceHash {
+		os.Remove(fp.DestPath)
+		return fmt.Errorf("copy does not match the original (SHA-256 %.12s…, want %.12s…); deleted it", destHash, result.SourceHash)
+	}
+	return nil
+}
+
+// processedPath returns where the original of fp is moved to once it has been copied:
+// <src>/processed/<relative path>, so files from different subfolders never collide.
+func processedPath(src string, fp FilePlan) string {
+	rel := fp.RelPath
+	if rel == "" {
+		rel = fp.SourceName
+	}
+	return filepath.Join(src, processedDirName, rel)
+}
+
+// isCollision returns true when dest exists but has different content from src,
+// along with both files' SHA-256 (empty when the sizes already differ).
 // Caller must ensure dest exists before calling.
-func isCollision(srcPath, destPath string) (bool, error) {
+func isCollision(srcPath, destPath string) (collision bool, srcHash, destHash string, err error) {
 	srcInfo, err := os.Stat(srcPath)
 	if err != nil {
-		return false, err
+		return false, "", "", err
 	}
 	destInfo, err := os.Stat(destPath)
 	if err != nil {
-		return false, err
+		return false, "", "", err
 	}
 
 	// Quick size check first
 	if srcInfo.Size() != destInfo.Size() {
-		return true, nil
+		return true, "", "", nil
 	}
 
 	// Same size: compare hashes to be sure
-	srcHash, err := fileHash(srcPath)
-	if err != nil {
-		return false, err
+	if srcHash, err = fileHash(srcPath); err != nil {
+		return false, "", "", err
 	}
-	destHash, err := fileHash(destPath)
-	if err != nil {
-		return false, err
+	if destHash, err = fileHash(destPath); err != nil {
+		return false, srcHash, "", err
 	}
-	return srcHash != destHash, nil
+	return srcHash != destHash, srcHash, destHash, nil
 }
 
 func fileHash(path string) (string, error) {
@@ -403,59 +863,40 @@ func fileHash(path string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// writeReport writes the import report to the working directory and returns the path.
-func writeReport(r *ImportReport) (string, error) {
-	name := fmt.Sprintf("import-report-%s.txt",
-		r.StartedAt.Format("2006-01-02-15-04-05"))
-	wd, err := os.Getwd()
-	if err != nil {
-		return "", fmt.Errorf("getting working directory: %w", err)
-	}
-	path := filepath.Join(wd, name)
-
-	f, err := os.Create(path)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	fmt.Fprintf(f, "Import Report — %s\n", r.StartedAt.Format("2006-01-02 15:04:05"))
-	fmt.Fprintf(f, "Source:      %s\n", r.Source)
-	fmt.Fprintf(f, "Destination: %s\n\n", r.Destination)
-
-	fmt.Fprintf(f, "Summary\n")
-	fmt.Fprintf(f, "  Processed:  %d\n", r.Processed())
-	fmt.Fprintf(f, "  Skipped:    %d\n", len(r.Skipped()))
-	fmt.Fprintf(f, "  Collisions: %d\n", len(r.Collisions()))
-	fmt.Fprintf(f, "  Errors:     %d\n\n", len(r.Errors()))
-
-	fmt.Fprintf(f, "Processed files\n")
-	for _, res := range r.Results {
-		if res.Succeeded {
-			fmt.Fprintf(f, "  %s  →  %s\n", res.Plan.SourceName, res.Plan.DestPath)
+// groupResults gathers companion files (same Plan.Group) into one item,
+// keeping the order in which each item first appears.
+func groupResults(results []FileResult) [][]FileResult {
+	var items [][]FileResult
+	index := make(map[string]int)
+	for _, res := range results {
+		if g := res.Plan.Group; g != "" {
+			if i, ok := index[g]; ok {
+				items[i] = append(items[i], res)
+				continue
+			}
+			index[g] = len(items)
 		}
+		items = append(items, []FileResult{res})
 	}
+	return items
+}
 
-	if len(r.Skipped()) > 0 {
-		fmt.Fprintf(f, "\nSkipped files\n")
-		for _, res := range r.Skipped() {
-			fmt.Fprintf(f, "  %s   reason: %s\n", res.Plan.SourceName, res.Plan.SkipReason)
-		}
+// timeNote describes how a file's time was resolved: where the date came from,
+// the UTC offset its name was built in, the offset recorded in the file when
+// that differs, and any clock correction, e.g.
+// "[date: exif, UTC+10:00, shot at UTC+02:00, clock +4m30s]".
+// It returns "" for plans without a capture time.
+func timeNote(fp FilePlan) string {
+	if fp.TakenAt.IsZero() {
+		return ""
 	}
-
-	if len(r.Collisions()) > 0 {
-		fmt.Fprintf(f, "\nCollisions (not copied — different file exists at destination)\n")
-		for _, res := range r.Collisions() {
-			fmt.Fprintf(f, "  %s  →  %s\n", res.Plan.SourceName, res.Plan.DestPath)
-		}
+	applied := media.FormatOffset(fp.TakenAt)
+	parts := []string{"date: " + string(fp.DateSource), "UTC" + applied}
+	if fp.ShotOffset != "" && fp.ShotOffset != applied {
+		parts = append(parts, "shot at UTC"+fp.ShotOffset)
 	}
-
-	if len(r.Errors()) > 0 {
-		fmt.Fprintf(f, "\nErrors\n")
-		for _, res := range r.Errors() {
-			fmt.Fprintf(f, "  %s   error: %v\n", res.Plan.SourceName, res.Plan.SkipReason)
-		}
+	if fp.ClockFix != 0 {
+		parts = append(parts, "clock "+formatCorrection(fp.ClockFix))
 	}
-
-	return path, nil
+	return "[" + strings.Join(parts, ", ") + "]"
 }
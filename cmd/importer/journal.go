@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/copyfile"
+)
+
+// journalName is the write-ahead journal an import keeps in the destination
+// root while it runs. It is removed once every file has been handled, so one
+// that is still there belongs to an import that never finished.
+const journalName = ".import-journal"
+
+// Journal steps, in the order a file goes through them. A file is finished
+// once it is moved (or verified, when its original is kept) or has failed.
+const (
+	stepPlanned  = "planned"  // nothing done yet; a copy found at the destination may be incomplete
+	stepCopied   = "copied"   // the copy is in place, or a file was already there
+	stepVerified = "verified" // the copy matches the original
+	stepMoved    = "moved"    // the original is in processed/
+	stepFailed   = "failed"   // collision or error; nothing more to do
+)
+
+// ErrJournalExists is returned by BeginJournal when the destination already
+// has a journal: an import into it is unfinished, or still running.
+var ErrJournalExists = errors.New("an unfinished import journal exists in the destination")
+
+// journalEntry is one line of the journal. The first line has Step "begin"
+// and the import's source and destination; each later line moves one file
+// to Step.
+type journalEntry struct {
+	Step        string    `json:"step"`
+	File        int       `json:"file"`
+	Source      string    `json:"source,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	StartedAt   time.Time `json:"started_at,omitzero"`
+	Plan        *FilePlan `json:"plan,omitempty"`
+	Existed     bool      `json:"existed,omitempty"`
+	SourceHash  string    `json:"source_sha256,omitempty"`
+	DestHash    string    `json:"dest_sha256,omitempty"`
+	MovedTo     string    `json:"moved_to,omitempty"`
+	CreatedDirs []string  `json:"created_dirs,omitempty"` // folders made since the file's last entry
+	Collision   bool      `json:"collision,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// journalFile is the last known state of one planned file.
+type journalFile struct {
+	Plan       FilePlan
+	Existed    bool // dest was there before the import started
+	Step       string
+	SourceHash string
+	DestHash   string
+	MovedTo    string
+	// CreatedDirs are the folders made for the file in every attempt.
+	CreatedDirs []string
+	Collision   bool
+	Err         string
+}
+
+// finished reports whether nothing is left to do for the file.
+func (jf journalFile) finished() bool {
+	switch jf.Step {
+	case stepMoved, stepFailed:
+		return true
+	case stepVerified:
+		return jf.Plan.KeepSource
+	}
+	return !jf.Plan.copies()
+}
+
+// result rebuilds the FileResult of a file finished before a crash.
+func (jf journalFile) result() FileResult {
+	res := FileResult{
+		Plan:        jf.Plan,
+		Existed:     jf.Existed,
+		Collision:   jf.Collision,
+		SourceHash:  jf.SourceHash,
+		DestHash:    jf.DestHash,
+		MovedTo:     jf.MovedTo,
+		CreatedDirs: jf.CreatedDirs,
+		Succeeded:   jf.Step == stepMoved || jf.Step == stepVerified,
+	}
+	if jf.Err != "" {
+		res.Err = errors.New(jf.Err)
+	}
+	return res
+}
+
+// Journal records each step of an import in the destination as it happens,
+// synced to disk before the next step starts, so that an import killed part
+// way (laptop asleep, card pulled) can be resumed or rolled back.
+type Journal struct {
+	Path        string
+	Source      string
+	Destination string
+	StartedAt   time.Time
+
+	mu    sync.Mutex    // guards files and f: files are executed in parallel
+	files []journalFile // indexed like the plan's Files
+	f     *os.File
+}
+
+// JournalPath returns where the journal of an import into dest lives.
+func JournalPath(dest string) string {
+	return filepath.Join(dest, journalName)
+}
+
+// BeginJournal creates the journal for plan, recording every file as planned
+// along with whether its destination already exists. It fails with
+// ErrJournalExists if the destination has one already.
+func BeginJournal(plan *ImportPlan) (*Journal, error) {
+	j := &Journal{
+		Path:        JournalPath(plan.Destination),
+		Source:      plan.Source,
+		Destination: plan.Destination,
+		StartedAt:   time.Now(),
+	}
+	f, err := os.OpenFile(j.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, ErrJournalExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating import journal: %w", err)
+	}
+	j.f = f
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	enc.Encode(journalEntry{Step: "begin", Source: j.Source, Destination: j.Destination, StartedAt: j.StartedAt}) //nolint:errcheck // reported by Flush
+	for i, fp := range plan.Files {
+		jf := journalFile{Plan: fp, Step: stepPlanned}
+		if fp.copies() {
+			if _, err := os.Lstat(fp.DestPath); err == nil {
+				jf.Existed = true
+			}
+		}
+		j.files = append(j.files, jf)
+		enc.Encode(journalEntry{Step: stepPlanned, File: i, Plan: &j.files[i].Plan, Existed: jf.Existed}) //nolint:errcheck // reported by Flush
+	}
+	if err := w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(j.Path)
+		return nil, fmt.Errorf("writing import journal: %w", err)
+	}
+	return j, nil
+}
+
+// OpenJournal reads the journal left in dest by an unfinished import. It
+// returns an error satisfying os.IsNotExist when there is none. A last line
+// cut short by the crash is ignored.
+func OpenJournal(dest string) (*Journal, error) {
+	path := JournalPath(dest)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{Path: path, f: f}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		var e journalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			break
+		}
+		if n == 1 {
+			if e.Step != "begin" {
+				f.Close()
+				return nil, fmt.Errorf("%s: not an import journal", path)
+			}
+			j.Source, j.Destination, j.StartedAt = e.Source, e.Destination, e.StartedAt
+			continue
+		}
+		if e.Step == stepPlanned && e.Plan != nil && e.File == len(j.files) {
+			j.files = append(j.files, journalFile{Plan: *e.Plan, Existed: e.Existed, Step: stepPlanned})
+			continue
+		}
+		if e.File < 0 || e.File >= len(j.files) {
+			f.Close()
+			return nil, fmt.Errorf("%s:%d: file %d was never planned", path, n, e.File)
+		}
+		jf := &j.files[e.File]
+		jf.Step = e.Step
+		if e.SourceHash != "" {
+			jf.SourceHash, jf.DestHash = e.SourceHash, e.DestHash
+		}
+		if e.MovedTo != "" {
+			jf.MovedTo = e.MovedTo
+		}
+		jf.CreatedDirs = addDirs(jf.CreatedDirs, e.CreatedDirs)
+		jf.Collision, jf.Err = e.Collision, e.Error
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if j.Destination == "" {
+		f.Close()
+		return nil, fmt.Errorf("%s: not an import journal", path)
+	}
+	return j, nil
+}
+
+// Plan returns the plan the journal was started with.
+func (j *Journal) Plan() *ImportPlan {
+	plan := &ImportPlan{Source: j.Source, Destination: j.Destination}
+	for _, jf := range j.files {
+		plan.Files = append(plan.Files, jf.Plan)
+	}
+	plan.Groups = countGroups(plan.Files)
+	return plan
+}
+
+// Progress returns how many files are finished, out of the whole plan.
+func (j *Journal) Progress() (done, total int) {
+	for _, jf := range j.files {
+		if jf.finished() {
+			done++
+		}
+	}
+	return done, len(j.files)
+}
+
+// record appends a step for file i and syncs it. A nil journal records
+// nothing, so ExecuteOne can share executeFile.
+func (j *Journal) record(i int, step string, res FileResult) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	jf := &j.files[i]
+	e := journalEntry{
+		Step:       step,
+		File:       i,
+		SourceHash: res.SourceHash,
+		DestHash:   res.DestHash,
+		MovedTo:    res.MovedTo,
+		Collision:  res.Collision,
+	}
+	for _, dir := range res.CreatedDirs {
+		if !slices.Contains(jf.CreatedDirs, dir) {
+			e.CreatedDirs = append(e.CreatedDirs, dir)
+		}
+	}
+	if res.Err != nil {
+		e.Error = res.Err.Error()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing import journal: %w", err)
+	}
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("syncing import journal: %w", err)
+	}
+	jf.Step = step
+	jf.SourceHash, jf.DestHash, jf.MovedTo = res.SourceHash, res.DestHash, res.MovedTo
+	jf.CreatedDirs = addDirs(jf.CreatedDirs, e.CreatedDirs)
+	jf.Collision, jf.Err = e.Collision, e.Error
+	return nil
+}
+
+// addDirs appends the folders of more not already in dirs.
+func addDirs(dirs, more []string) []string {
+	for _, dir := range more {
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// ExecuteOne handles file i of the journal's plan, carrying on from the
+// last step recorded for it. Files finished before a crash are not touched
+// again; a copy cut short leaves only a temporary file, which is deleted
+// before copying again; a copy already in place is verified; a verified copy
+// only needs its original moved.
+func (j *Journal) ExecuteOne(i int) FileResult {
+	j.mu.Lock()
+	jf := j.files[i]
+	j.mu.Unlock()
+	fp := jf.Plan
+	if jf.finished() {
+		return jf.result()
+	}
+
+	start := time.Now()
+	var result FileResult
+	if jf.Step == stepVerified {
+		result = jf.result()
+		result.Succeeded = false
+		moveOriginal(&result, j.Source, j, i)
+	} else {
+		if err := copyfile.RemoveTemps(fp.DestPath); err != nil {
+			result = FileResult{Plan: fp, Err: fmt.Errorf("removing incomplete copy: %w", err)}
+		}
+		if result.Err == nil {
+			result = executeFile(fp, j.Source, j, i)
+		}
+		result.Existed = jf.Existed
+		// folders made before the crash are not new this time
+		result.CreatedDirs = addDirs(slices.Clone(jf.CreatedDirs), result.CreatedDirs)
+	}
+	result.Duration = time.Since(start)
+	if result.Err != nil || result.Collision {
+		if err := j.record(i, stepFailed, result); err != nil && result.Err == nil {
+			result.Err = err
+		}
+	}
+	return result
+}
+
+// Finish closes the journal and removes it: the import is complete.
+func (j *Journal) Finish() error {
+	j.f.Close()
+	if err := os.Remove(j.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing import journal: %w", err)
+	}
+	return nil
+}
+
+// Rollback undoes everything the journalled import did, last file first:
+// originals are moved back out of processed/ and copies the import made are
+// deleted, as are temporary files of copies cut short. Files whose destination
+// existed before the import are left alone. Folders the import made and
+// left empty are removed, and so is the journal once every file is rolled
+// back.
+func (j *Journal) Rollback() *UndoReport {
+	report := &UndoReport{
+		StartedAt:   time.Now(),
+		Record:      j.Path,
+		Source:      j.Source,
+		Destination: j.Destination,
+	}
+	var created []string
+	for i := len(j.files) - 1; i >= 0; i-- {
+		jf := j.files[i]
+		if !jf.Plan.copies() {
+			continue
+		}
+		created = append(created, jf.CreatedDirs...)
+		copyfile.RemoveTemps(jf.Plan.DestPath) //nolint:errcheck // only hidden leftovers
+		if jf.Step == stepPlanned && (jf.Existed || !exists(jf.Plan.DestPath)) {
+			continue // never started
+		}
+		res := rollbackFile(j.Source, jf)
+		report.Results = append(report.Results, res)
+	}
+	removeEmptyDirs(created)
+	if len(report.Errors()) == 0 {
+		if err := j.Finish(); err != nil {
+			report.Results = append(report.Results, UndoResult{Dest: j.Path, Err: err})
+		}
+	}
+	return report
+}
+
+func rollbackFile(src string, jf journalFile) UndoResult {
+	fp := jf.Plan
+	res := UndoResult{Source: fp.SourcePath, Dest: fp.DestPath, MovedTo: jf.MovedTo}
+
+	// The move may have happened without being recorded.
+	moved := processedPath(src, fp)
+	if _, err := os.Lstat(fp.SourcePath); os.IsNotExist(err) {
+		if _, err := os.Stat(moved); err == nil {
+			res.MovedTo = moved
+		}
+	}
+	if res.MovedTo != "" {
+		if err := os.MkdirAll(filepath.Dir(fp.SourcePath), 0755); err != nil {
+			res.Err = fmt.Errorf("recreating source folder: %w", err)
+			return res
+		}
+		if err := os.Rename(res.MovedTo, fp.SourcePath); err != nil {
+			res.Err = fmt.Errorf("moving original back: %w", err)
+			return res
+		}
+	}
+	if !jf.Existed {
+		if err := os.Remove(fp.DestPath); err != nil && !os.IsNotExist(err) {
+			res.Err = fmt.Errorf("deleting copy: %w", err)
+			return res
+		}
+	}
+	res.Restored = true
+	return res
+}
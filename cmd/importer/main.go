@@ -1,29 +1,204 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/cemeng/photos-organiser/internal/config"
+	"github.com/cemeng/photos-organiser/internal/layout"
+	"github.com/cemeng/photos-organiser/internal/media"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: importer <source-directory>\n")
-		os.Exit(1)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: importer [options] <source-directory>\n")
+		fmt.Fprintf(os.Stderr, "       importer -dest <dir> [-yes] [-dry-run] [-quiet] <source-directory>\n")
+		fmt.Fprintf(os.Stderr, "       importer undo [-dry-run] [-report-dir <dir>] <import-report>\n")
+		fmt.Fprintf(os.Stderr, "       importer catalog rebuild [<library>]\n")
+		fmt.Fprintf(os.Stderr, "       importer -calibrate <photo> -actual <true time>\n")
+		fmt.Fprintf(os.Stderr, "       importer [-config <file>] [-profile <name>] config show\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nWithout a terminal, or with -yes, -dry-run, -quiet, -resume or -rollback, the\n")
+		fmt.Fprintf(os.Stderr, "importer runs without its interactive screens and prints one line per file.\n")
+		fmt.Fprintf(os.Stderr, "Exit status: %d success, %d error, %d collisions or failed files, %d aborted.\n",
+			exitOK, exitFailed, exitPartial, exitAborted)
 	}
 
-	source := NormaliseDir(os.Args[1])
+	cfg, sel, err := config.FromArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailed)
+	}
+
+	opts := ScanOptions{Suspicious: DefaultSuspiciousOptions()}
+	var dateFloor, suspicious, tz, clockTable, calibrate, actual string
+	h := headlessOptions{Exec: DefaultExecOptions()}
+	dirTemplate, nameTemplate := orDefault(cfg.Layout, layout.DefaultDir), orDefault(cfg.Name, layout.DefaultName)
+	// Flags that override config settings are read back through config.Apply.
+	flag.String("config", sel.Path, "config file (or $"+config.EnvConfig+")")
+	flag.String("profile", sel.Profile, "config profile to use (or $"+config.EnvProfile+")")
+	flag.String("dest", cfg.Library, "destination, e.g. your library root; pre-fills the prompt in the TUI (default: parent of the source)")
+	flag.BoolVar(&h.Yes, "yes", false, "import without asking for confirmation (runs without the TUI)")
+	flag.BoolVar(&h.DryRun, "dry-run", false, "list what would happen to each file, change nothing (runs without the TUI)")
+	flag.BoolVar(&h.Quiet, "quiet", false, "print only collisions and errors (runs without the TUI)")
+	flag.BoolVar(&h.Resume, "resume", false, "finish an import that was interrupted, from the journal it left in the destination (runs without the TUI)")
+	flag.BoolVar(&h.Rollback, "rollback", false, "undo an import that was interrupted, from the journal it left in the destination (runs without the TUI)")
+	flag.IntVar(&h.Exec.Workers, "workers", h.Exec.Workers, "files to copy, verify and move at the same time")
+	flag.IntVar(&h.Exec.DeviceWorkers, "device-workers", 0, "most files in flight on any one disk (default: 1 on spinning disks, no limit otherwise)")
+	flag.BoolVar(&opts.Recursive, "r", false, "scan nested folders of the source (processed/ folders are skipped)")
+	flag.StringVar(&dateFloor, "date-floor", opts.Suspicious.Floor.Format("2006-01-02"), "flag files dated before this day as suspicious (empty to disable)")
+	flag.DurationVar(&opts.Suspicious.MaxDrift, "max-drift", 0, "flag files whose EXIF/container date and mtime differ by more than this, e.g. 720h (0 disables)")
+	flag.StringVar(&suspicious, "suspicious", string(opts.Suspicious.Policy), "what to do with suspicious dates: review (copy to <dest>/review/) or hold (leave in source)")
+	flag.StringVar(&dirTemplate, "layout", dirTemplate, "folder template under the destination, e.g. {YYYY}/{YYYY}-{MM}-{DD} or {YYYY}/Q{Q}")
+	flag.StringVar(&nameTemplate, "name", nameTemplate, "filename template; must end with .{ext} (fields: YYYY YY MM MMM DD hh mm ss sub Q name ext camera seq hash)")
+	flag.String("ext", strings.Join(cfg.Extensions, ","), "comma-separated extensions to import, e.g. jpg,heic,mov (default: all supported)")
+	flag.String("processed", orDefault(cfg.Processed, config.ProcessedMove), "what to do with originals once copied: move (to <source>/processed/) or keep")
+	flag.String("verify", orDefault(cfg.Verify, config.VerifyHash), "how copies are checked: hash (read the copy back) or fsync (trust the hash taken while copying)")
+	flag.String("report-dir", cfg.ReportDir, "folder to write the import report to (default: the destination)")
+	flag.String("report-format", orDefault(cfg.ReportFormat, string(ReportText)), "comma-separated report formats: text, json, csv, md")
+	flag.StringVar(&tz, "tz", cfg.Timezone, "zone for filenames and folders, e.g. Australia/Sydney, UTC or +10:00 (default: as shot, local for files without an offset)")
+	flag.Var((*datePatternsFlag)(&opts.DatePatterns), "date-pattern", "extra regexp for dates in filenames, with (?P<year>) (?P<month>) (?P<day>) and optional (?P<hour>) (?P<minute>) (?P<second>) groups; repeatable")
+	flag.StringVar(&clockTable, "clocks", "", "clock table file correcting cameras with wrong clocks (make,model,serial,offset rows)")
+	flag.StringVar(&calibrate, "calibrate", "", "print the clock table row for the camera that took this reference photo, then exit")
+	flag.StringVar(&actual, "actual", "", "with -calibrate: the true time the photo was taken, \"2006-01-02 15:04:05\" or RFC 3339")
+	flag.Parse()
+
+	if err := config.Apply(&cfg, flag.CommandLine, map[string]string{
+		"dest": "library", "layout": "layout", "name": "name", "ext": "extensions",
+		"processed": "processed", "verify": "verify", "tz": "timezone", "report-dir": "report-dir", "report-format": "report-format",
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailed)
+	}
+	if flag.NArg() == 2 && flag.Arg(0) == "config" && flag.Arg(1) == "show" {
+		config.Show(os.Stdout, sel, cfg, map[string]string{
+			"library":       "parent of the source",
+			"layout":        layout.DefaultDir,
+			"name":          layout.DefaultName,
+			"extensions":    "all supported",
+			"processed":     config.ProcessedMove,
+			"verify":        config.VerifyHash,
+			"timezone":      "as shot",
+			"report-dir":    "the destination",
+			"report-format": string(ReportText),
+		})
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "undo" {
+		os.Exit(runUndo(flag.Args()[1:], h.DryRun, os.Stdout, os.Stderr))
+	}
+
+	if calibrate != "" {
+		c, err := CalibrateClock(calibrate, actual)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		fmt.Println(c)
+		return
+	}
+
+	opts.Suspicious.Floor = time.Time{}
+	if dateFloor != "" {
+		opts.Suspicious.Floor, err = time.ParseInLocation("2006-01-02", dateFloor, time.Local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -date-floor %q: %v\n", dateFloor, err)
+			os.Exit(exitFailed)
+		}
+	}
+	if opts.Layout.Dir, err = layout.ParseDir(dirTemplate); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -layout: %v\n", err)
+		os.Exit(exitFailed)
+	}
+	if opts.Layout.Name, err = layout.ParseName(nameTemplate); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -name: %v\n", err)
+		os.Exit(exitFailed)
+	}
+	if opts.Zone, err = media.ParseZone(tz); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -tz: %v\n", err)
+		os.Exit(exitFailed)
+	}
+	if clockTable != "" {
+		if opts.Clocks, err = LoadClockTable(clockTable); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailed)
+		}
+	}
+	if opts.Suspicious.Policy, err = ParseSuspiciousPolicy(suspicious); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailed)
+	}
+	report := ReportOptions{Dir: cfg.ReportDir}
+	if report.Formats, err = ParseReportFormats(cfg.ReportFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -report-format: %v\n", err)
+		os.Exit(exitFailed)
+	}
+	opts.Extensions = cfg.Extensions
+	opts.KeepOriginals = cfg.Processed == config.ProcessedKeep
+	opts.TrustFsync = cfg.Verify == config.VerifyFsync
+	if flag.NArg() > 0 && flag.Arg(0) == "catalog" {
+		os.Exit(runCatalog(flag.Args()[1:], cfg.Library, opts, os.Stdout, os.Stderr))
+	}
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(exitFailed)
+	}
+
+	source := NormaliseDir(flag.Arg(0))
 
 	info, err := os.Stat(source)
 	if err != nil || !info.IsDir() {
 		fmt.Fprintf(os.Stderr, "Error: %q is not a valid directory\n", source)
-		os.Exit(1)
+		os.Exit(exitFailed)
 	}
 
-	p := tea.NewProgram(newModel(source), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	if h.Yes || h.DryRun || h.Quiet || h.Resume || h.Rollback || !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		h.Dest, h.Report = cfg.Library, report
+		os.Exit(runHeadless(source, opts, h, os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	p := tea.NewProgram(newModel(source, NormaliseDir(cfg.Library), report, opts, h.Exec), tea.WithAltScreen())
+	final, err := p.Run()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFailed)
+	}
+	os.Exit(final.(model).exitCode())
+}
+
+// orDefault returns s, or def when s is empty.
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// datePatternsFlag collects repeated -date-pattern flags.
+type datePatternsFlag []media.DatePattern
+
+func (f *datePatternsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	var exprs []string
+	for _, p := range *f {
+		exprs = append(exprs, p.Re.String())
+	}
+	return strings.Join(exprs, " ")
+}
+
+func (f *datePatternsFlag) Set(expr string) error {
+	p, err := media.ParseDatePattern(expr)
+	if err != nil {
+		return err
 	}
+	*f = append(*f, p)
+	return nil
 }
@@ -1,11 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/cemeng/photos-organiser/internal/catalog"
+	"github.com/cemeng/photos-organiser/internal/layout"
+	"github.com/cemeng/photos-organiser/internal/media"
+	"github.com/cemeng/photos-organiser/internal/mediatest"
 )
 
 // fixtureJPG returns the path to gopher-stand.jpg in cmd/renamer/,
@@ -28,25 +41,45 @@ func fixtureJPG(t *testing.T) (path string, restore func()) {
 	}
 }
 
-// ── sanitizeBasename ──────────────────────────────────────────────────────────
+// fixtureHEIC returns the path to iphone-sample.heic in cmd/renamer/, a small
+// HEIC whose Exif item records DateTimeOriginal 2021:06:12 09:41:27.
+func fixtureHEIC(t *testing.T) string {
+	t.Helper()
+	abs, err := filepath.Abs("../renamer/iphone-sample.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return abs
+}
+
+// ── default layout ────────────────────────────────────────────────────────────
+
+// buildDestFilename renders the default filename template.
+func buildDestFilename(ts time.Time, base, ext string) string {
+	_, name := layout.Default().Render(layout.Fields{Time: ts, Name: base, Ext: ext})
+	return name
+}
 
+// Basenames and extensions are uppercased: the importer has always written
+// them that way, and existing libraries hold names in that form.
 func TestSanitizeBasename(t *testing.T) {
 	cases := []struct {
 		in   string
 		want string
 	}{
-		{"IMG_1234", "img_1234"},
-		{"My Photo 01", "my_photo_01"},
-		{"file-name.backup", "file_name_backup"},
-		{"HELLO WORLD", "hello_world"},
-		{"__leading__trailing__", "leading_trailing"},
-		{"abc", "abc"},
-		{"A B  C", "a_b_c"},
+		{"IMG_1234", "IMG_1234"},
+		{"My Photo 01", "MY_PHOTO_01"},
+		{"file-name.backup", "FILE_NAME_BACKUP"},
+		{"hello world", "HELLO_WORLD"},
+		{"__leading__trailing__", "LEADING_TRAILING"},
+		{"abc", "ABC"},
+		{"A B  C", "A_B_C"},
 	}
+	ts := time.Date(2024, 3, 15, 14, 22, 0, 0, time.UTC)
 	for _, c := range cases {
-		got := sanitizeBasename(c.in)
+		got := strings.TrimSuffix(strings.TrimPrefix(buildDestFilename(ts, c.in, "JPG"), "2024-03-15-14-22-"), ".JPG")
 		if got != c.want {
-			t.Errorf("sanitizeBasename(%q) = %q, want %q", c.in, got, c.want)
+			t.Errorf("sanitized %q = %q, want %q", c.in, got, c.want)
 		}
 	}
 }
@@ -57,17 +90,17 @@ func TestBuildDestFilename(t *testing.T) {
 	t.Run("produces correct format", func(t *testing.T) {
 		ts := time.Date(2024, 3, 15, 14, 22, 0, 0, time.UTC)
 		got := buildDestFilename(ts, "IMG_1234", "JPG")
-		want := "2024-03-15-14-22-img_1234.jpg"
+		want := "2024-03-15-14-22-IMG_1234.JPG"
 		if got != want {
 			t.Errorf("buildDestFilename() = %q, want %q", got, want)
 		}
 	})
 
-	t.Run("lowercases extension", func(t *testing.T) {
+	t.Run("uppercases extension", func(t *testing.T) {
 		ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
-		got := buildDestFilename(ts, "photo", "HEIC")
-		if !strings.HasSuffix(got, ".heic") {
-			t.Errorf("expected lowercase extension, got %q", got)
+		got := buildDestFilename(ts, "photo", "heic")
+		if !strings.HasSuffix(got, ".HEIC") {
+			t.Errorf("expected uppercase extension, got %q", got)
 		}
 	})
 }
@@ -174,7 +207,7 @@ func TestScanDir(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	plan, err := ScanDir(srcDir, destDir)
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{})
 	if err != nil {
 		t.Fatalf("ScanDir() error: %v", err)
 	}
@@ -202,194 +235,1943 @@ func TestScanDir(t *testing.T) {
 	}
 }
 
-// ── ExecuteOne (happy path) ───────────────────────────────────────────────────
+// ── ScanDir (recursive) ───────────────────────────────────────────────────────
 
-func TestExecuteOne_HappyPath(t *testing.T) {
-	fixturePath, restoreFixture := fixtureJPG(t)
-	defer restoreFixture()
+func TestScanDir_Recursive(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	for _, rel := range []string{
+		"top.png",
+		"DCIM/100APPLE/IMG_0001.PNG",
+		"DCIM/101APPLE/IMG_0001.PNG",
+		"processed/old.png",
+		"DCIM/processed/older.png",
+	} {
+		path := filepath.Join(srcDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(rel), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("flat scan ignores subfolders", func(t *testing.T) {
+		plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Files) != 1 || plan.Files[0].RelPath != "top.png" {
+			t.Errorf("flat scan = %+v, want only top.png", plan.Files)
+		}
+	})
+
+	t.Run("recursive scan walks nested folders and skips processed/", func(t *testing.T) {
+		plan, err := ScanDir(srcDir, destDir, ScanOptions{Recursive: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range plan.Files {
+			got = append(got, f.RelPath)
+		}
+		want := []string{"DCIM/100APPLE/IMG_0001.PNG", "DCIM/101APPLE/IMG_0001.PNG", "top.png"}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("recursive scan = %v, want %v", got, want)
+		}
+	})
+}
 
+func TestExecuteOne_RecursiveProcessedTree(t *testing.T) {
 	srcDir := t.TempDir() + "/"
 	destDir := t.TempDir() + "/"
 
-	data, err := os.ReadFile(fixturePath)
+	rels := []string{"DCIM/100APPLE/IMG_0001.PNG", "DCIM/101APPLE/IMG_0001.PNG"}
+	for i, rel := range rels {
+		path := filepath.Join(srcDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(rel), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Different minutes so the two copies don't collide at the destination.
+		mt := time.Date(2024, 3, 15, 14, 20+i, 0, 0, time.Local)
+		if err := os.Chtimes(path, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{Recursive: true})
 	if err != nil {
 		t.Fatal(err)
 	}
-	srcFile := filepath.Join(srcDir, "gopher-stand.jpg")
-	if err := os.WriteFile(srcFile, data, 0644); err != nil {
-		t.Fatal(err)
+	for _, fp := range plan.Files {
+		if res := ExecuteOne(fp, srcDir); !res.Succeeded {
+			t.Fatalf("ExecuteOne(%s) = %+v", fp.RelPath, res)
+		}
 	}
 
-	plan, err := ScanDir(srcDir, destDir)
-	if err != nil {
+	for _, rel := range rels {
+		if _, err := os.Stat(filepath.Join(srcDir, "processed", rel)); err != nil {
+			t.Errorf("original not moved to processed/%s: %v", rel, err)
+		}
+	}
+}
+
+// ── ScanDir (video container dates) ───────────────────────────────────────────
+
+func TestScanDir_VideoContainerDate(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	shot := time.Date(2023, 7, 4, 18, 30, 0, 0, time.UTC)
+	path := filepath.Join(srcDir, "IMG_0042.MOV")
+	if err := os.WriteFile(path, mediatest.Movie(shot), 0644); err != nil {
 		t.Fatal(err)
 	}
-	if len(plan.Files) == 0 {
-		t.Fatal("scan produced no files")
+	// Simulate a file that was synced much later.
+	synced := time.Date(2024, 1, 20, 9, 0, 0, 0, time.Local)
+	if err := os.Chtimes(path, synced, synced); err != nil {
+		t.Fatal(err)
 	}
 
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	fp := plan.Files[0]
-	if fp.Class != ClassProcessable {
-		t.Fatalf("expected ClassProcessable, got %v", fp.Class)
+	local := shot.In(time.Local)
+	wantDir := fmt.Sprintf("%04d/%02d", local.Year(), local.Month())
+	if fp.DestDir != wantDir {
+		t.Errorf("DestDir = %q, want %q (from mvhd, not mtime)", fp.DestDir, wantDir)
 	}
+	if want := buildDestFilename(local, "IMG_0042", "MOV"); filepath.Base(fp.DestPath) != want {
+		t.Errorf("dest filename = %q, want %q", filepath.Base(fp.DestPath), want)
+	}
+}
 
-	result := ExecuteOne(fp, srcDir)
-	if result.Err != nil {
-		t.Fatalf("ExecuteOne() error: %v", result.Err)
+// ── ScanDir (HEIC EXIF) ───────────────────────────────────────────────────────
+
+func TestScanDir_HEICExifDate(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	data, err := os.ReadFile(fixtureHEIC(t))
+	if err != nil {
+		t.Fatal(err)
 	}
-	if !result.Succeeded {
-		t.Error("expected Succeeded=true")
+	if err := os.WriteFile(filepath.Join(srcDir, "IMG_1234.HEIC"), data, 0644); err != nil {
+		t.Fatal(err)
 	}
 
-	// Dest file should exist
-	if _, err := os.Stat(fp.DestPath); err != nil {
-		t.Errorf("dest file missing: %v", err)
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	// Original should be in processed/
-	processedPath := filepath.Join(srcDir, "processed", "gopher-stand.jpg")
-	if _, err := os.Stat(processedPath); err != nil {
-		t.Errorf("original not moved to processed/: %v", err)
+	fp := plan.Files[0]
+	if fp.DestDir != "2021/06" {
+		t.Errorf("DestDir = %q, want 2021/06 (from EXIF, not mtime)", fp.DestDir)
+	}
+	if got, want := filepath.Base(fp.DestPath), "2021-06-12-09-41-IMG_1234.HEIC"; got != want {
+		t.Errorf("dest filename = %q, want %q", got, want)
 	}
 }
 
-// ── ExecuteOne (already processed — skip) ────────────────────────────────────
+// ── ScanDir (layout templates) ───────────────────────────────────────────────
 
-func TestExecuteOne_AlreadyProcessed(t *testing.T) {
+func TestScanDir_CustomLayout(t *testing.T) {
 	srcDir := t.TempDir() + "/"
 	destDir := t.TempDir() + "/"
 
-	name := "2024-03-15-14-22-img.jpg"
-	if err := os.WriteFile(filepath.Join(srcDir, name), []byte("x"), 0644); err != nil {
+	livePhoto(t, srcDir, "IMG_1234.HEIC", "IMG_1234.MOV", "", "")
+	earlier := mediatest.JPEG(mediatest.TIFF(
+		[]mediatest.Tag{{ID: mediatest.TagModel, Value: "X-T4"}},
+		[]mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: "2024:03:15 09:00:00"}},
+	))
+	if err := os.WriteFile(filepath.Join(srcDir, "DSCF0001.JPG"), earlier, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "IMG_1234.AAE"), []byte("<plist/>"), 0644); err != nil {
 		t.Fatal(err)
 	}
 
-	plan, err := ScanDir(srcDir, destDir)
+	opts := ScanOptions{Layout: layout.Layout{
+		Dir:  layout.MustParseDir("{YYYY}/{YYYY}-{MM}-{DD}"),
+		Name: layout.MustParseName("{YYYY}{MM}{DD}-{seq:3}-{camera:lower}.{ext:lower}"),
+	}}
+	plan, err := ScanDir(srcDir, destDir, opts)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	fp := plan.Files[0]
-	if fp.Class != ClassAlreadyProcessed {
-		t.Fatalf("expected ClassAlreadyProcessed, got %v", fp.Class)
+	want := map[string]string{
+		"DSCF0001.JPG":  "2024/2024-03-15/20240315-001-x_t4.jpg",
+		"IMG_1234.HEIC": "2024/2024-03-15/20240315-002-unknown.heic",
+		"IMG_1234.MOV":  "2024/2024-03-15/20240315-002-unknown.mov",
+		"IMG_1234.AAE":  "2024/2024-03-15/20240315-002-unknown.aae",
+	}
+	for _, fp := range plan.Files {
+		rel, _ := filepath.Rel(destDir, fp.DestPath)
+		if w := want[fp.SourceName]; filepath.ToSlash(rel) != w {
+			t.Errorf("%s → %q, want %q", fp.SourceName, rel, w)
+		}
+	}
+	if plan.Groups["2024/2024-03-15"] != 2 {
+		t.Errorf("Groups = %v, want 2 items in 2024/2024-03-15", plan.Groups)
 	}
 
-	result := ExecuteOne(fp, srcDir)
-	if result.Succeeded {
-		t.Error("already-processed file should not be marked Succeeded")
+	// files already named by the layout are recognised as processed
+	if err := os.WriteFile(filepath.Join(srcDir, "20240101-001-x_t4.jpg"), earlier, 0644); err != nil {
+		t.Fatal(err)
 	}
-	// Original file should be untouched
-	if _, err := os.Stat(filepath.Join(srcDir, name)); err != nil {
-		t.Errorf("original file should remain in src: %v", err)
+	plan, err = ScanDir(srcDir, destDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fp := range plan.Files {
+		if fp.SourceName == "20240101-001-x_t4.jpg" && fp.Class != ClassAlreadyProcessed {
+			t.Errorf("rendered name classified %v, want ClassAlreadyProcessed", fp.Class)
+		}
 	}
 }
 
-// ── ExecuteOne (unsupported extension — skip) ─────────────────────────────────
-
-func TestExecuteOne_UnsupportedExtension(t *testing.T) {
+func TestScanDir_DatelessNameTemplate(t *testing.T) {
 	srcDir := t.TempDir() + "/"
 	destDir := t.TempDir() + "/"
-
-	if err := os.WriteFile(filepath.Join(srcDir, "document.pdf"), []byte("pdf"), 0644); err != nil {
+	if err := os.WriteFile(filepath.Join(srcDir, "IMG-20240315-WA0001.jpg"), []byte("x"), 0644); err != nil {
 		t.Fatal(err)
 	}
-
-	plan, err := ScanDir(srcDir, destDir)
+	opts := ScanOptions{Layout: layout.Layout{
+		Dir:  layout.MustParseDir("{YYYY}"),
+		Name: layout.MustParseName("{name}.{ext}"),
+	}}
+	plan, err := ScanDir(srcDir, destDir, opts)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if fp := plan.Files[0]; fp.Class != ClassProcessable || fp.DestPath != filepath.Join(destDir, "2024", "IMG_20240315_WA0001.jpg") {
+		t.Errorf("class %v, dest %q; want processable into 2024/", fp.Class, fp.DestPath)
+	}
+}
 
-	fp := plan.Files[0]
-	if fp.Class != ClassUnsupported {
-		t.Fatalf("expected ClassUnsupported, got %v", fp.Class)
+func TestLibraryDate_DatelessNameTemplate(t *testing.T) {
+	opts := ScanOptions{Layout: layout.Layout{
+		Dir:  layout.MustParseDir("{YYYY}"),
+		Name: layout.MustParseName("{name}.{ext}"),
+	}}
+	mtime := time.Date(2022, 7, 1, 12, 0, 0, 0, time.Local)
+	library := t.TempDir()
+	lib := filepath.Join(library, "photo.png")
+	if err := os.WriteFile(lib, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
 	}
-	if fp.SkipReason == "" {
-		t.Error("expected a skip reason")
+	if err := os.Chtimes(lib, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if got := libraryDate(library, lib, opts); !got.Equal(mtime) {
+		t.Errorf("libraryDate() = %v, want the mtime %v", got, mtime)
 	}
 }
 
-// ── Collision detection ───────────────────────────────────────────────────────
+func TestScanDir_HashLayout(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
 
-func TestExecuteOne_CollisionDetection(t *testing.T) {
-	fixturePath, restoreFixture := fixtureJPG(t)
-	defer restoreFixture()
+	if err := os.WriteFile(filepath.Join(srcDir, "a.png"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := ScanOptions{Layout: layout.Layout{
+		Dir:  layout.MustParseDir(""),
+		Name: layout.MustParseName("{hash:10}.{ext}"),
+	}}
+	plan, err := ScanDir(srcDir, destDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256("hello") = 2cf24dba5fb0a30e...
+	if got := plan.Files[0].DestPath; got != filepath.Join(destDir, "2cf24dba5f.png") {
+		t.Errorf("DestPath = %q", got)
+	}
+}
+
+// ── ScanDir (time zones) ──────────────────────────────────────────────────────
 
+func TestScanDir_TimeZones(t *testing.T) {
 	srcDir := t.TempDir() + "/"
 	destDir := t.TempDir() + "/"
 
-	data, err := os.ReadFile(fixturePath)
+	// Fixture: 2021-06-12 09:41:27 with OffsetTimeOriginal +10:00.
+	data, err := os.ReadFile(fixtureHEIC(t))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := os.WriteFile(filepath.Join(srcDir, "IMG_1234.HEIC"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// No offset tag: naive wall-clock time.
+	naive := mediatest.TIFF(nil, []mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: "2023:09:30 17:05:44"}})
+	if err := os.WriteFile(filepath.Join(srcDir, "DSC0001.JPG"), mediatest.JPEG(naive), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// MOV headers are UTC.
+	shot := time.Date(2023, 12, 31, 22, 30, 0, 0, time.UTC)
+	if err := os.WriteFile(filepath.Join(srcDir, "IMG_0042.MOV"), mediatest.Movie(shot), 0644); err != nil {
+		t.Fatal(err)
+	}
 
-	// Put the same file in src twice under different names but ensure they
-	// map to the same dest filename (same timestamp, same basename).
-	// Easiest: copy fixture, scan to get the dest path, pre-place a different
-	// file at that dest path, then run ExecuteOne — it should detect collision.
-	srcFile := filepath.Join(srcDir, "gopher-stand.jpg")
-	if err := os.WriteFile(srcFile, data, 0644); err != nil {
+	byName := func(plan *ImportPlan) map[string]FilePlan {
+		out := make(map[string]FilePlan)
+		for _, fp := range plan.Files {
+			out[fp.SourceName] = fp
+		}
+		return out
+	}
+
+	t.Run("as shot", func(t *testing.T) {
+		plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := byName(plan)
+		heic := files["IMG_1234.HEIC"]
+		if got := filepath.Base(heic.DestPath); got != "2021-06-12-09-41-IMG_1234.HEIC" {
+			t.Errorf("HEIC dest = %q, want the recorded wall-clock time", got)
+		}
+		if heic.ShotOffset != "+10:00" {
+			t.Errorf("HEIC ShotOffset = %q, want +10:00", heic.ShotOffset)
+		}
+		if jpg := files["DSC0001.JPG"]; jpg.ShotOffset != "" || jpg.TakenAt.Location() != time.Local {
+			t.Errorf("naive JPEG: ShotOffset %q, TakenAt %v; want no offset, local zone", jpg.ShotOffset, jpg.TakenAt)
+		}
+	})
+
+	t.Run("explicit zone", func(t *testing.T) {
+		plan, err := ScanDir(srcDir, destDir, ScanOptions{Zone: time.UTC})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := byName(plan)
+		heic := files["IMG_1234.HEIC"]
+		if got := filepath.Base(heic.DestPath); got != "2021-06-11-23-41-IMG_1234.HEIC" {
+			t.Errorf("HEIC dest = %q, want the time converted to UTC", got)
+		}
+		if heic.DestDir != "2021/06" || heic.ShotOffset != "+10:00" {
+			t.Errorf("HEIC DestDir %q ShotOffset %q", heic.DestDir, heic.ShotOffset)
+		}
+		// naive times are read as wall-clock time in the chosen zone
+		if got := filepath.Base(files["DSC0001.JPG"].DestPath); got != "2023-09-30-17-05-DSC0001.JPG" {
+			t.Errorf("JPEG dest = %q", got)
+		}
+		mov := files["IMG_0042.MOV"]
+		if mov.DestDir != "2023/12" || filepath.Base(mov.DestPath) != "2023-12-31-22-30-IMG_0042.MOV" {
+			t.Errorf("MOV DestDir %q dest %q, want 2023/12 in UTC", mov.DestDir, filepath.Base(mov.DestPath))
+		}
+
+		sydney := time.FixedZone("+11:00", 11*3600)
+		plan, err = ScanDir(srcDir, destDir, ScanOptions{Zone: sydney})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mov := byName(plan)["IMG_0042.MOV"]; mov.DestDir != "2024/01" {
+			t.Errorf("MOV DestDir = %q, want 2024/01 at +11:00", mov.DestDir)
+		}
+	})
+}
+
+// ── ScanDir (dates from filenames and folders) ───────────────────────────────
+
+func TestScanDir_FilenameDates(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	trip := filepath.Join(srcDir, "2022-12-24 Christmas")
+	if err := os.Mkdir(trip, 0755); err != nil {
 		t.Fatal(err)
 	}
+	stripped := mediatest.JPEG(mediatest.TIFF([]mediatest.Tag{{ID: mediatest.TagMake, Value: "Apple"}}, nil))
+	files := map[string][]byte{
+		"IMG-20240315-WA0001.jpg":           stripped,
+		"Screenshot_20240316-142233.png":    []byte("png"),
+		"scan_15032024.png":                 []byte("png"),
+		"IMG_1234.JPG":                      stripped,
+		"2022-12-24 Christmas/IMG_2000.JPG": stripped,
+	}
+	synced := time.Date(2025, 1, 20, 9, 0, 0, 0, time.Local)
+	for name, data := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, synced, synced); err != nil {
+			t.Fatal(err)
+		}
+	}
 
-	plan, err := ScanDir(srcDir, destDir)
+	scan, err := media.ParseDatePattern(`^scan_(?P<day>\d{2})(?P<month>\d{2})(?P<year>\d{4})`)
 	if err != nil {
 		t.Fatal(err)
 	}
-	fp := plan.Files[0]
-	if fp.Class != ClassProcessable {
-		t.Fatalf("expected ClassProcessable")
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{Recursive: true, DatePatterns: []media.DatePattern{scan}})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	// Pre-place a *different* file at the dest path to simulate a collision
-	if err := os.MkdirAll(filepath.Dir(fp.DestPath), 0755); err != nil {
+	want := map[string]struct {
+		name   string
+		source DateSource
+	}{
+		"IMG-20240315-WA0001.jpg":        {"2024-03-15-00-00-IMG_20240315_WA0001.JPG", DateFromFilename},
+		"Screenshot_20240316-142233.png": {"2024-03-16-14-22-SCREENSHOT_20240316_142233.PNG", DateFromFilename},
+		"scan_15032024.png":              {"2024-03-15-00-00-SCAN_15032024.PNG", DateFromFilename},
+		"IMG_1234.JPG":                   {"2025-01-20-09-00-IMG_1234.JPG", DateFromModTime},
+		"IMG_2000.JPG":                   {"2022-12-24-00-00-IMG_2000.JPG", DateFromPath},
+	}
+	for _, fp := range plan.Files {
+		w, ok := want[fp.SourceName]
+		if !ok {
+			t.Errorf("unexpected file %s", fp.SourceName)
+			continue
+		}
+		if got := filepath.Base(fp.DestPath); got != w.name || fp.DateSource != w.source {
+			t.Errorf("%s: dest %q source %q, want %q %q", fp.SourceName, got, fp.DateSource, w.name, w.source)
+		}
+	}
+}
+
+func TestScanDir_DatedParentFolder(t *testing.T) {
+	srcDir := filepath.Join(t.TempDir(), "Backup-2019-05-01", "card") + "/"
+	destDir := t.TempDir() + "/"
+	path := filepath.Join(srcDir, "DCIM", "IMG_1234.PNG")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		t.Fatal(err)
 	}
-	if err := os.WriteFile(fp.DestPath, []byte("different content"), 0644); err != nil {
+	if err := os.WriteFile(path, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	synced := time.Date(2025, 1, 20, 9, 0, 0, 0, time.Local)
+	if err := os.Chtimes(path, synced, synced); err != nil {
 		t.Fatal(err)
 	}
 
-	result := ExecuteOne(fp, srcDir)
-	if !result.Collision {
-		t.Error("expected Collision=true when dest exists with different content")
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
 	}
-	if result.Succeeded {
-		t.Error("colliding file should not be marked Succeeded")
+	if fp := plan.Files[0]; fp.DateSource != DateFromModTime || !fp.TakenAt.Equal(synced) {
+		t.Errorf("dated %v from %q, want the mtime: folders above -src say nothing", fp.TakenAt, fp.DateSource)
 	}
 }
 
-// ── FinaliseReport ────────────────────────────────────────────────────────────
+// ── ScanDir / ExecuteOne (config settings) ───────────────────────────────────
 
-func TestFinaliseReport(t *testing.T) {
+func TestScanDir_Extensions(t *testing.T) {
+	srcDir := t.TempDir() + "/"
 	destDir := t.TempDir() + "/"
-
-	report := &ImportReport{
-		StartedAt:   time.Date(2024, 3, 15, 14, 22, 0, 0, time.UTC),
-		Source:      "/src/",
-		Destination: destDir,
-		Results: []FileResult{
-			{Plan: FilePlan{SourceName: "a.jpg", Class: ClassProcessable}, Succeeded: true},
-			{Plan: FilePlan{SourceName: "b.pdf", Class: ClassUnsupported, SkipReason: "unsupported extension: .pdf"}},
-		},
+	for _, name := range []string{"IMG-20240315-WA0001.webp", "IMG_1.mov", "IMG_2.pdf", "IMG_1.XMP"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	if err := FinaliseReport(report); err != nil {
-		t.Fatalf("FinaliseReport() error: %v", err)
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{Extensions: []string{"webp", "jpg"}})
+	if err != nil {
+		t.Fatal(err)
 	}
-	if report.ReportPath == "" {
-		t.Error("expected ReportPath to be set")
+	for _, fp := range plan.Files {
+		switch fp.SourceName {
+		case "IMG-20240315-WA0001.webp":
+			if fp.Class != ClassProcessable || fp.DateSource != DateFromFilename {
+				t.Errorf("%s: class %v source %q, want processable from filename", fp.SourceName, fp.Class, fp.DateSource)
+			}
+		case "IMG_1.mov", "IMG_2.pdf":
+			if fp.Class != ClassUnsupported || !strings.Contains(fp.SkipReason, "configured extensions") {
+				t.Errorf("%s: class %v reason %q, want skipped as not configured", fp.SourceName, fp.Class, fp.SkipReason)
+			}
+		case "IMG_1.XMP":
+			if fp.Class != ClassOrphanSidecar {
+				t.Errorf("%s: class %v, want sidecars unaffected by extensions", fp.SourceName, fp.Class)
+			}
+		}
 	}
-	if _, err := os.Stat(report.ReportPath); err != nil {
-		t.Errorf("report file missing: %v", err)
+}
+
+func TestExecuteOne_KeepOriginals(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+	src := filepath.Join(srcDir, "Screenshot_20240316-142233.png")
+	if err := os.WriteFile(src, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
 	}
 
-	contents, err := os.ReadFile(report.ReportPath)
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{KeepOriginals: true})
 	if err != nil {
 		t.Fatal(err)
 	}
-	body := string(contents)
-	for _, want := range []string{"Import Report", "Processed:", "Skipped:", "a.jpg", "b.pdf"} {
-		if !strings.Contains(body, want) {
-			t.Errorf("report missing %q\n---\n%s", want, body)
+	res := ExecuteOne(plan.Files[0], srcDir)
+	if !res.Succeeded {
+		t.Fatalf("ExecuteOne() = %+v", res)
+	}
+	if _, err := os.Stat(res.Plan.DestPath); err != nil {
+		t.Errorf("dest file missing: %v", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("original not kept in place: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, processedDirName)); !os.IsNotExist(err) {
+		t.Errorf("processed/ created with KeepOriginals: %v", err)
+	}
+}
+
+// ── ScanDir (camera clock corrections) ───────────────────────────────────────
+
+func TestExecuteOne_Verify(t *testing.T) {
+	want := fmt.Sprintf("%x", sha256.Sum256([]byte("png")))
+	for _, trust := range []bool{false, true} {
+		srcDir := t.TempDir() + "/"
+		destDir := t.TempDir() + "/"
+		if err := os.WriteFile(filepath.Join(srcDir, "Screenshot_20240316-142233.png"), []byte("png"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		plan, err := ScanDir(srcDir, destDir, ScanOptions{TrustFsync: trust})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := ExecuteOne(plan.Files[0], srcDir)
+		if !res.Succeeded || res.SourceHash != want || res.DestHash != want {
+			t.Errorf("TrustFsync=%v: ExecuteOne() = %+v, want both hashes %s", trust, res, want)
+		}
+	}
+}
+
+func TestScanDir_ClockTable(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	body := func(serial string) []byte {
+		return mediatest.JPEG(mediatest.TIFF(
+			[]mediatest.Tag{{ID: mediatest.TagMake, Value: "FUJIFILM"}, {ID: mediatest.TagModel, Value: "X-T4"}},
+			[]mediatest.Tag{
+				{ID: mediatest.TagDateTimeOriginal, Value: "2023:09:30 17:05:44"},
+				{ID: mediatest.TagBodySerialNumber, Value: serial},
+			},
+		))
+	}
+	files := map[string][]byte{
+		"DSCF0001.JPG": body("111"), // slow body, has its own entry
+		"DSCF0002.JPG": body("222"), // same model, falls back to the model entry
+		"DSC0001.JPG": mediatest.JPEG(mediatest.TIFF(
+			[]mediatest.Tag{{ID: mediatest.TagMake, Value: "SONY"}},
+			[]mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: "2023:09:30 17:05:44"}},
+		)),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), data, 0644); err != nil {
+			t.Fatal(err)
 		}
 	}
+
+	table := ClockTable{
+		{Make: "fujifilm", Model: "X-T4", Offset: -time.Hour},
+		{Make: "FUJIFILM", Model: "X-T4", Serial: "111", Offset: 4*time.Minute + 30*time.Second},
+	}
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{Clocks: table})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct {
+		name string
+		fix  time.Duration
+	}{
+		"DSCF0001.JPG": {"2023-09-30-17-10-DSCF0001.JPG", 270 * time.Second},
+		"DSCF0002.JPG": {"2023-09-30-16-05-DSCF0002.JPG", -time.Hour},
+		"DSC0001.JPG":  {"2023-09-30-17-05-DSC0001.JPG", 0},
+	}
+	for _, fp := range plan.Files {
+		w := want[fp.SourceName]
+		if got := filepath.Base(fp.DestPath); got != w.name || fp.ClockFix != w.fix {
+			t.Errorf("%s: dest %q ClockFix %v, want %q %v", fp.SourceName, got, fp.ClockFix, w.name, w.fix)
+		}
+	}
+}
+
+func TestLoadClockTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clocks.csv")
+	body := "# make,model,serial,offset\nFUJIFILM,X-T4,12345678,4m30s\n\"Canon\", \"Canon EOS 80D\",,-1h\n"
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := LoadClockTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ClockTable{
+		{Make: "FUJIFILM", Model: "X-T4", Serial: "12345678", Offset: 270 * time.Second},
+		{Make: "Canon", Model: "Canon EOS 80D", Offset: -time.Hour},
+	}
+	if len(table) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(table), len(want))
+	}
+	for i := range want {
+		if table[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, table[i], want[i])
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("FUJIFILM,X-T4,,4 minutes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadClockTable(path); err == nil {
+		t.Error("expected error for a bad offset")
+	}
+}
+
+func TestCalibrateClock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ref.jpg")
+	data := mediatest.JPEG(mediatest.TIFF(
+		[]mediatest.Tag{{ID: mediatest.TagMake, Value: "FUJIFILM"}, {ID: mediatest.TagModel, Value: "X-T4"}},
+		[]mediatest.Tag{
+			{ID: mediatest.TagDateTimeOriginal, Value: "2023:09:30 17:05:44"},
+			{ID: mediatest.TagBodySerialNumber, Value: "12345678"},
+		},
+	))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := CalibrateClock(path, "2023-09-30 17:10:14")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Offset != 270*time.Second || c.Serial != "12345678" {
+		t.Errorf("CalibrateClock() = %+v, want +4m30s for serial 12345678", c)
+	}
+	if got := c.String(); got != "FUJIFILM,X-T4,12345678,4m30s" {
+		t.Errorf("String() = %q", got)
+	}
+
+	if _, err := CalibrateClock(path, "yesterday"); err == nil {
+		t.Error("expected error for an unparseable reference time")
+	}
+}
+
+// ── ScanDir (RAW formats) ─────────────────────────────────────────────────────
+
+func TestScanDir_RawFormats(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	tiff := mediatest.TIFF(
+		[]mediatest.Tag{{ID: mediatest.TagMake, Value: "SONY"}},
+		[]mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: "2023:09:30 17:05:44"}},
+	)
+	files := map[string][]byte{
+		"DSC0001.ARW":  tiff,
+		"IMG_0001.CR2": tiff,
+		"_DSC0001.NEF": tiff,
+		"DNG_0001.DNG": tiff,
+		"P0001.ORF":    mediatest.ORF(tiff),
+		"DSCF0001.RAF": mediatest.RAF(mediatest.JPEG(tiff)),
+		// In-camera JPEG without EXIF, copied off the card a month later.
+		"DSC0001.JPG": []byte("jpeg without exif"),
+	}
+	for name, data := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	later := time.Date(2023, 10, 31, 8, 0, 0, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(srcDir, "DSC0001.JPG"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fp := range plan.Files {
+		if fp.Class != ClassProcessable {
+			t.Errorf("%s: class %v (%s), want processable", fp.SourceName, fp.Class, fp.SkipReason)
+			continue
+		}
+		if fp.DestDir != "2023/09" {
+			t.Errorf("%s: DestDir = %q, want 2023/09", fp.SourceName, fp.DestDir)
+		}
+		if !strings.HasPrefix(filepath.Base(fp.DestPath), "2023-09-30-17-05-") {
+			t.Errorf("%s: dest = %q, want the RAW's timestamp prefix", fp.SourceName, fp.DestPath)
+		}
+		if fp.DateSource != DateFromExif {
+			t.Errorf("%s: DateSource = %q, want exif", fp.SourceName, fp.DateSource)
+		}
+	}
+}
+
+// ── ScanDir (Live Photo companions) ───────────────────────────────────────────
+
+// livePhoto writes a HEIC still and a MOV into dir. The MOV's own date is a
+// minute earlier than the still's so a missing grouping shows up in the prefix.
+func livePhoto(t *testing.T, dir, stillName, movieName, stillID, movieID string) {
+	t.Helper()
+	exifTags := []mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: "2024:03:15 14:22:33"}}
+	if stillID != "" {
+		exifTags = append(exifTags, mediatest.Tag{ID: mediatest.TagMakerNote, Raw: mediatest.AppleMakerNote(stillID)})
+	}
+	still := mediatest.HEIC(mediatest.TIFF([]mediatest.Tag{{ID: mediatest.TagMake, Value: "Apple"}}, exifTags), false)
+	meta := map[string]string{"com.apple.quicktime.creationdate": "2024-03-15T14:21:59+1100"}
+	if movieID != "" {
+		meta["com.apple.quicktime.content.identifier"] = movieID
+	}
+	movie := mediatest.Movie(time.Date(2024, 3, 15, 3, 21, 59, 0, time.UTC), mediatest.AppleMeta(meta))
+
+	if err := os.WriteFile(filepath.Join(dir, stillName), still, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, movieName), movie, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanDir_LivePhotoCompanions(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	livePhoto(t, srcDir, "IMG_1234.HEIC", "IMG_1234.MOV", "", "")
+	livePhoto(t, srcDir, "IMG_5678.HEIC", "IMG_5678 (1).MOV", "ID-5678", "ID-5678")
+	livePhoto(t, srcDir, "IMG_9000.HEIC", "IMG_9000.MOV", "ID-A", "ID-B")
+
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]FilePlan)
+	for _, fp := range plan.Files {
+		byName[fp.SourceName] = fp
+	}
+
+	t.Run("same basename shares group and prefix", func(t *testing.T) {
+		still, movie := byName["IMG_1234.HEIC"], byName["IMG_1234.MOV"]
+		if still.Group == "" || still.Group != movie.Group {
+			t.Errorf("groups = %q / %q, want one shared group", still.Group, movie.Group)
+		}
+		if got, want := filepath.Base(movie.DestPath), "2024-03-15-14-22-IMG_1234.MOV"; got != want {
+			t.Errorf("MOV dest = %q, want %q (the still's timestamp)", got, want)
+		}
+	})
+
+	t.Run("content identifier links different names", func(t *testing.T) {
+		still, movie := byName["IMG_5678.HEIC"], byName["IMG_5678 (1).MOV"]
+		if still.Group == "" || still.Group != movie.Group {
+			t.Errorf("groups = %q / %q, want one shared group", still.Group, movie.Group)
+		}
+		if got, want := filepath.Base(movie.DestPath), "2024-03-15-14-22-IMG_5678.MOV"; got != want {
+			t.Errorf("MOV dest = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("different content identifiers are not companions", func(t *testing.T) {
+		if g := byName["IMG_9000.MOV"].Group; g != "" {
+			t.Errorf("IMG_9000.MOV group = %q, want none", g)
+		}
+	})
+
+	t.Run("confirm screen counts a group once", func(t *testing.T) {
+		// 2 grouped Live Photos + 2 unrelated files
+		if got := plan.Groups["2024/03"]; got != 4 {
+			t.Errorf("Groups[2024/03] = %d, want 4 items for 6 files", got)
+		}
+	})
+}
+
+// ── ScanDir (sidecars and edited variants) ────────────────────────────────────
+
+func TestScanDir_SidecarsAndEdits(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	livePhoto(t, srcDir, "IMG_1234.HEIC", "IMG_1234.MOV", "", "")
+	raw := mediatest.TIFF(nil, []mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: "2023:09:30 17:05:44"}})
+	files := map[string][]byte{
+		"IMG_1234.AAE":    []byte("<plist/>"),
+		"IMG_O1234.AAE":   []byte("<plist/>"),
+		"IMG_E1234.JPG":   []byte("edited render without exif"),
+		"DSC0001.ARW":     raw,
+		"DSC0001.ARW.xmp": []byte("<x:xmpmeta/>"),
+		"IMG_9999.AAE":    []byte("<plist/>"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]FilePlan)
+	for _, fp := range plan.Files {
+		byName[fp.SourceName] = fp
+	}
+
+	want := map[string]string{
+		"IMG_1234.AAE":    "2024-03-15-14-22-IMG_1234.AAE",
+		"IMG_O1234.AAE":   "2024-03-15-14-22-IMG_1234_ORIGINAL.AAE",
+		"IMG_E1234.JPG":   "2024-03-15-14-22-IMG_1234_EDITED.JPG",
+		"DSC0001.ARW.xmp": "2023-09-30-17-05-DSC0001.ARW.XMP",
+	}
+	for name, wantDest := range want {
+		fp := byName[name]
+		if fp.Class != ClassProcessable {
+			t.Errorf("%s: class %v (%s), want processable", name, fp.Class, fp.SkipReason)
+			continue
+		}
+		if got := filepath.Base(fp.DestPath); got != wantDest {
+			t.Errorf("%s: dest = %q, want %q", name, got, wantDest)
+		}
+	}
+
+	if g := byName["IMG_1234.HEIC"].Group; g == "" || byName["IMG_1234.AAE"].Group != g || byName["IMG_E1234.JPG"].Group != g {
+		t.Errorf("sidecar and edit should join the Live Photo group %q", g)
+	}
+	if g := byName["DSC0001.ARW"].Group; g == "" || byName["DSC0001.ARW.xmp"].Group != g {
+		t.Errorf("XMP should join DSC0001.ARW's group, got %q / %q", g, byName["DSC0001.ARW.xmp"].Group)
+	}
+	if c := byName["IMG_9999.AAE"].Class; c != ClassOrphanSidecar {
+		t.Errorf("IMG_9999.AAE class = %v, want ClassOrphanSidecar", c)
+	}
+	if got := plan.Groups["2024/03"]; got != 1 {
+		t.Errorf("Groups[2024/03] = %d, want the whole Live Photo as 1 item", got)
+	}
+}
+
+// ── ScanDir (suspicious dates) ────────────────────────────────────────────────
+
+func TestScanDir_SuspiciousDates(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	mtimes := map[string]time.Time{
+		"reset.png":  time.Date(2000, 1, 1, 0, 3, 0, 0, time.Local),
+		"epoch.png":  time.Date(1970, 1, 1, 0, 0, 0, 0, time.Local),
+		"future.png": time.Now().AddDate(1, 0, 0),
+		"fine.png":   time.Date(2024, 3, 15, 14, 22, 0, 0, time.Local),
+	}
+	for name, mt := range mtimes {
+		path := filepath.Join(srcDir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// EXIF says 2021, mtime says 2024: flagged only when drift checking is on.
+	heic, err := os.ReadFile(fixtureHEIC(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	drifted := filepath.Join(srcDir, "IMG_1234.HEIC")
+	if err := os.WriteFile(drifted, heic, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(drifted, mtimes["fine.png"], mtimes["fine.png"]); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := func(t *testing.T, opts SuspiciousOptions) map[string]FilePlan {
+		t.Helper()
+		plan, err := ScanDir(srcDir, destDir, ScanOptions{Suspicious: opts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string]FilePlan)
+		for _, fp := range plan.Files {
+			out[fp.SourceName] = fp
+		}
+		return out
+	}
+
+	t.Run("review policy routes flagged files to review/", func(t *testing.T) {
+		opts := DefaultSuspiciousOptions()
+		opts.MaxDrift = 30 * 24 * time.Hour
+		files := scan(t, opts)
+		for _, name := range []string{"reset.png", "epoch.png", "future.png", "IMG_1234.HEIC"} {
+			fp := files[name]
+			if fp.Class != ClassSuspiciousDate || fp.SkipReason == "" {
+				t.Errorf("%s: class %v reason %q, want ClassSuspiciousDate with a reason", name, fp.Class, fp.SkipReason)
+			}
+			if !strings.HasPrefix(fp.DestDir, "review/") {
+				t.Errorf("%s: DestDir = %q, want under review/", name, fp.DestDir)
+			}
+		}
+		if files["reset.png"].DestDir != "review/2000/01" {
+			t.Errorf("reset.png DestDir = %q, want review/2000/01", files["reset.png"].DestDir)
+		}
+		if c := files["fine.png"].Class; c != ClassProcessable {
+			t.Errorf("fine.png class = %v, want processable", c)
+		}
+
+		res := ExecuteOne(files["reset.png"], srcDir)
+		if !res.Succeeded {
+			t.Fatalf("ExecuteOne(reset.png) = %+v", res)
+		}
+		if _, err := os.Stat(filepath.Join(destDir, "review", "2000", "01", filepath.Base(files["reset.png"].DestPath))); err != nil {
+			t.Errorf("reset.png not copied to review/: %v", err)
+		}
+	})
+
+	t.Run("hold policy leaves flagged files alone", func(t *testing.T) {
+		opts := DefaultSuspiciousOptions()
+		opts.Policy = SuspiciousHold
+		files := scan(t, opts)
+		fp := files["future.png"]
+		if fp.Class != ClassSuspiciousDate || fp.DestPath != "" {
+			t.Fatalf("future.png = %+v, want held back", fp)
+		}
+		if res := ExecuteOne(fp, srcDir); res.Succeeded {
+			t.Error("held file should not be copied")
+		}
+		if _, err := os.Stat(fp.SourcePath); err != nil {
+			t.Errorf("held file should remain in source: %v", err)
+		}
+	})
+
+	t.Run("drift check is off by default", func(t *testing.T) {
+		if c := scan(t, DefaultSuspiciousOptions())["IMG_1234.HEIC"].Class; c != ClassProcessable {
+			t.Errorf("IMG_1234.HEIC class = %v, want processable without -max-drift", c)
+		}
+	})
+}
+
+// ── ExecuteOne (happy path) ───────────────────────────────────────────────────
+
+func TestExecuteOne_HappyPath(t *testing.T) {
+	fixturePath, restoreFixture := fixtureJPG(t)
+	defer restoreFixture()
+
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	data, err := os.ReadFile(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcFile := filepath.Join(srcDir, "gopher-stand.jpg")
+	if err := os.WriteFile(srcFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Files) == 0 {
+		t.Fatal("scan produced no files")
+	}
+
+	fp := plan.Files[0]
+	if fp.Class != ClassProcessable {
+		t.Fatalf("expected ClassProcessable, got %v", fp.Class)
+	}
+
+	result := ExecuteOne(fp, srcDir)
+	if result.Err != nil {
+		t.Fatalf("ExecuteOne() error: %v", result.Err)
+	}
+	if !result.Succeeded {
+		t.Error("expected Succeeded=true")
+	}
+
+	// Dest file should exist
+	if _, err := os.Stat(fp.DestPath); err != nil {
+		t.Errorf("dest file missing: %v", err)
+	}
+
+	// Original should be in processed/
+	processedPath := filepath.Join(srcDir, "processed", "gopher-stand.jpg")
+	if _, err := os.Stat(processedPath); err != nil {
+		t.Errorf("original not moved to processed/: %v", err)
+	}
+	if result.MovedTo != processedPath {
+		t.Errorf("MovedTo = %q, want %q", result.MovedTo, processedPath)
+	}
+	if result.SourceHash == "" || result.SourceHash != result.DestHash {
+		t.Errorf("hashes = %q / %q, want the same SHA-256", result.SourceHash, result.DestHash)
+	}
+	if result.Duration <= 0 {
+		t.Errorf("Duration = %v, want it timed", result.Duration)
+	}
+}
+
+// ── ExecuteOne (already processed — skip) ────────────────────────────────────
+
+func TestExecuteOne_AlreadyProcessed(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	name := "2024-03-15-14-22-img.jpg"
+	if err := os.WriteFile(filepath.Join(srcDir, name), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp := plan.Files[0]
+	if fp.Class != ClassAlreadyProcessed {
+		t.Fatalf("expected ClassAlreadyProcessed, got %v", fp.Class)
+	}
+
+	result := ExecuteOne(fp, srcDir)
+	if result.Succeeded {
+		t.Error("already-processed file should not be marked Succeeded")
+	}
+	// Original file should be untouched
+	if _, err := os.Stat(filepath.Join(srcDir, name)); err != nil {
+		t.Errorf("original file should remain in src: %v", err)
+	}
+}
+
+// ── ExecuteOne (unsupported extension — skip) ─────────────────────────────────
+
+func TestExecuteOne_UnsupportedExtension(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	if err := os.WriteFile(filepath.Join(srcDir, "document.pdf"), []byte("pdf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp := plan.Files[0]
+	if fp.Class != ClassUnsupported {
+		t.Fatalf("expected ClassUnsupported, got %v", fp.Class)
+	}
+	if fp.SkipReason == "" {
+		t.Error("expected a skip reason")
+	}
+}
+
+// ── Collision detection ───────────────────────────────────────────────────────
+
+func TestExecuteOne_CollisionDetection(t *testing.T) {
+	fixturePath, restoreFixture := fixtureJPG(t)
+	defer restoreFixture()
+
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	data, err := os.ReadFile(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Put the same file in src twice under different names but ensure they
+	// map to the same dest filename (same timestamp, same basename).
+	// Easiest: copy fixture, scan to get the dest path, pre-place a different
+	// file at that dest path, then run ExecuteOne — it should detect collision.
+	srcFile := filepath.Join(srcDir, "gopher-stand.jpg")
+	if err := os.WriteFile(srcFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := ScanDir(srcDir, destDir, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := plan.Files[0]
+	if fp.Class != ClassProcessable {
+		t.Fatalf("expected ClassProcessable")
+	}
+
+	// Pre-place a *different* file at the dest path to simulate a collision
+	if err := os.MkdirAll(filepath.Dir(fp.DestPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fp.DestPath, []byte("different content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := ExecuteOne(fp, srcDir)
+	if !result.Collision {
+		t.Error("expected Collision=true when dest exists with different content")
+	}
+	if result.Succeeded {
+		t.Error("colliding file should not be marked Succeeded")
+	}
+}
+
+// ── FinaliseReport ────────────────────────────────────────────────────────────
+
+func TestFinaliseReport(t *testing.T) {
+	destDir := t.TempDir() + "/"
+
+	report := &ImportReport{
+		StartedAt:   time.Date(2024, 3, 15, 14, 22, 0, 0, time.UTC),
+		Source:      "/src/",
+		Destination: destDir,
+		Results: []FileResult{
+			{Plan: FilePlan{SourceName: "a.jpg", Class: ClassProcessable}, Succeeded: true},
+			{Plan: FilePlan{SourceName: "b.pdf", Class: ClassUnsupported, SkipReason: "unsupported extension: .pdf"}},
+		},
+	}
+
+	if err := FinaliseReport(report); err != nil {
+		t.Fatalf("FinaliseReport() error: %v", err)
+	}
+	if report.ReportPath == "" {
+		t.Error("expected ReportPath to be set")
+	}
+	if _, err := os.Stat(report.ReportPath); err != nil {
+		t.Errorf("report file missing: %v", err)
+	}
+
+	contents, err := os.ReadFile(report.ReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(contents)
+	for _, want := range []string{"Import Report", "Processed:", "Skipped:", "a.jpg", "b.pdf"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("report missing %q\n---\n%s", want, body)
+		}
+	}
+}
+
+func TestFinaliseReport_Dir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "reports")
+	report := &ImportReport{
+		StartedAt:   time.Date(2024, 3, 15, 14, 22, 0, 0, time.UTC),
+		Source:      "/src/",
+		Destination: t.TempDir() + "/",
+		Output:      ReportOptions{Dir: dir},
+	}
+	if err := FinaliseReport(report); err != nil {
+		t.Fatalf("FinaliseReport() error: %v", err)
+	}
+	if want := filepath.Join(dir, "import-report-2024-03-15-14-22-00.txt"); report.ReportPath != want {
+		t.Errorf("ReportPath = %q, want %q", report.ReportPath, want)
+	}
+	if _, err := os.Stat(report.ReportPath); err != nil {
+		t.Errorf("report file missing: %v", err)
+	}
+}
+
+func TestFinaliseReport_Formats(t *testing.T) {
+	dest := t.TempDir() + "/"
+	taken := time.Date(2024, 3, 15, 14, 22, 33, 0, time.FixedZone("", 10*3600))
+	report := &ImportReport{
+		StartedAt:   time.Date(2024, 3, 15, 14, 22, 4, 0, time.UTC),
+		FinishedAt:  time.Date(2024, 3, 15, 14, 22, 9, 0, time.UTC),
+		Source:      "/src/",
+		Destination: dest,
+		Output:      ReportOptions{Formats: []ReportFormat{ReportText, ReportJSON, ReportCSV, ReportMarkdown}},
+		Results: []FileResult{
+			{
+				Plan:       FilePlan{SourceName: "a.jpg", RelPath: "DCIM/a.jpg", DestPath: dest + "2024/03/A.JPG", TakenAt: taken, DateSource: DateFromExif, Class: ClassProcessable},
+				Succeeded:  true,
+				SourceHash: "abc", DestHash: "abc",
+				MovedTo:  "/src/processed/DCIM/a.jpg",
+				Duration: 1500 * time.Microsecond,
+			},
+			{Plan: FilePlan{SourceName: "b.pdf", RelPath: "b.pdf", Class: ClassUnsupported, SkipReason: "unsupported extension: .pdf"}},
+			{Plan: FilePlan{SourceName: "c|d.jpg", RelPath: "c|d.jpg", DestPath: dest + "x.jpg", Class: ClassProcessable}, Err: fmt.Errorf("disk full")},
+		},
+	}
+	if err := FinaliseReport(report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.ReportPaths) != 4 || report.ReportPath != report.ReportPaths[0] {
+		t.Fatalf("ReportPaths = %v", report.ReportPaths)
+	}
+	read := func(ext string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dest, "import-report-2024-03-15-14-22-04."+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if text := read("txt"); !strings.Contains(text, "c|d.jpg   error: disk full") {
+		t.Errorf("text report Errors section should show the error:\n%s", text)
+	}
+
+	var doc reportDocument
+	if err := json.Unmarshal([]byte(read("json")), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Summary.Processed != 1 || doc.Summary.Errors != 1 || len(doc.Files) != 3 {
+		t.Errorf("JSON summary = %+v, %d files", doc.Summary, len(doc.Files))
+	}
+	want := reportRecord{
+		Source: "DCIM/a.jpg", Dest: dest + "2024/03/A.JPG", MovedTo: "/src/processed/DCIM/a.jpg",
+		Class: "processable", Status: "copied", TakenAt: "2024-03-15T14:22:33+10:00", DateSource: "exif",
+		SourceHash: "abc", DestHash: "abc", DurationMS: 1.5,
+	}
+	if doc.Files[0] != want {
+		t.Errorf("JSON record = %+v\nwant %+v", doc.Files[0], want)
+	}
+	if doc.Files[1].Status != "skipped" || doc.Files[2].Status != "error" || doc.Files[2].Error != "disk full" {
+		t.Errorf("JSON records = %+v", doc.Files[1:])
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(read("csv"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || strings.Join(rows[0], ",") != strings.Join(recordColumns, ",") {
+		t.Fatalf("CSV rows = %q", rows)
+	}
+	if rows[2][0] != "b.pdf" || rows[2][6] != "skipped" || rows[2][7] != "unsupported extension: .pdf" {
+		t.Errorf("CSV row = %q", rows[2])
+	}
+
+	md := read("md")
+	for _, want := range []string{"| 1 | 1 | 0 | 0 | 0 | 1 |", "| c\\|d.jpg | error |", "- Took: 5s"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown report missing %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestParseReportFormats(t *testing.T) {
+	got, err := ParseReportFormats("txt, JSON,markdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[text json md]" {
+		t.Errorf("ParseReportFormats() = %v", got)
+	}
+	if _, err := ParseReportFormats("xml"); err == nil {
+		t.Error("ParseReportFormats(xml) succeeded, want error")
+	}
+}
+
+func TestTimeNote(t *testing.T) {
+	utc := time.Date(2021, 6, 11, 23, 41, 0, 0, time.UTC)
+	cases := []struct {
+		fp   FilePlan
+		want string
+	}{
+		{FilePlan{}, ""},
+		{FilePlan{TakenAt: utc, DateSource: DateFromModTime}, "[date: mtime, UTC+00:00]"},
+		{FilePlan{TakenAt: utc, DateSource: DateFromExif, ShotOffset: "+00:00"}, "[date: exif, UTC+00:00]"},
+		{FilePlan{TakenAt: utc, DateSource: DateFromExif, ShotOffset: "+10:00"}, "[date: exif, UTC+00:00, shot at UTC+10:00]"},
+		{FilePlan{TakenAt: utc, DateSource: DateFromExif, ClockFix: 270 * time.Second}, "[date: exif, UTC+00:00, clock +4m30s]"},
+		{FilePlan{TakenAt: utc, DateSource: DateFromFilename, ClockFix: -time.Hour}, "[date: filename, UTC+00:00, clock -1h0m0s]"},
+	}
+	for _, c := range cases {
+		if got := timeNote(c.fp); got != c.want {
+			t.Errorf("timeNote(%+v) = %q, want %q", c.fp, got, c.want)
+		}
+	}
+}
+
+func TestFinaliseReport_CompanionsAreOneItem(t *testing.T) {
+	report := &ImportReport{
+		StartedAt:   time.Date(2024, 3, 15, 14, 22, 1, 0, time.UTC),
+		Source:      "/src/",
+		Destination: t.TempDir() + "/",
+		Results: []FileResult{
+			{Plan: FilePlan{SourceName: "IMG_1234.HEIC", Group: "IMG_1234", Class: ClassProcessable}, Succeeded: true},
+			{Plan: FilePlan{SourceName: "IMG_2000.JPG", Class: ClassProcessable}, Succeeded: true},
+			{Plan: FilePlan{SourceName: "IMG_1234.MOV", Group: "IMG_1234", Class: ClassProcessable}, Succeeded: true},
+		},
+	}
+	if err := FinaliseReport(report); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(report.ReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), "IMG_1234.HEIC  →  \n    + IMG_1234.MOV") {
+		t.Errorf("Live Photo halves not reported as one item:\n%s", contents)
+	}
+}
+
+func TestFinaliseReport_SuspiciousSection(t *testing.T) {
+	report := &ImportReport{
+		StartedAt:   time.Date(2024, 3, 15, 14, 22, 3, 0, time.UTC),
+		Source:      "/src/",
+		Destination: t.TempDir() + "/",
+		Results: []FileResult{
+			{Plan: FilePlan{SourceName: "a.jpg", Class: ClassProcessable}, Succeeded: true},
+			{Plan: FilePlan{SourceName: "reset.jpg", DestPath: "/dest/review/2000/01/x.jpg", Class: ClassSuspiciousDate, SkipReason: "date 2000-01-01 00:03 is before 2000-01-02 (camera clock reset?)"}, Succeeded: true},
+			{Plan: FilePlan{SourceName: "future.jpg", Class: ClassSuspiciousDate, SkipReason: "date 2031-01-01 00:00 is in the future (wrong clock?)"}},
+		},
+	}
+	if err := FinaliseReport(report); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(report.ReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(contents)
+	for _, want := range []string{"Suspicious: 2", "Suspicious dates", "reset.jpg  →  /dest/review/2000/01/x.jpg", "future.jpg  held back in source", "camera clock reset"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("report missing %q\n---\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "Skipped files") {
+		t.Errorf("suspicious files should not be listed as skipped:\n%s", body)
+	}
+	if report.Processed() != 1 {
+		t.Errorf("Processed() = %d, want 1 (review copies are counted separately)", report.Processed())
+	}
+}
+
+func TestFinaliseReport_OrphanSidecars(t *testing.T) {
+	report := &ImportReport{
+		StartedAt:   time.Date(2024, 3, 15, 14, 22, 2, 0, time.UTC),
+		Source:      "/src/",
+		Destination: t.TempDir() + "/",
+		Results: []FileResult{
+			{Plan: FilePlan{SourceName: "IMG_9999.AAE", RelPath: "IMG_9999.AAE", Class: ClassOrphanSidecar, SkipReason: "sidecar without a matching photo"}},
+			{Plan: FilePlan{SourceName: "b.pdf", Class: ClassUnsupported, SkipReason: "unsupported extension: .pdf"}},
+		},
+	}
+	if err := FinaliseReport(report); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(report.ReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(contents)
+	orphans := strings.Index(body, "Orphan sidecars")
+	if orphans < 0 || !strings.Contains(body[orphans:], "IMG_9999.AAE") {
+		t.Errorf("orphan sidecar not in its own section:\n%s", body)
+	}
+	if skipped := strings.Index(body, "Skipped files"); skipped < 0 || strings.Contains(body[skipped:orphans], "IMG_9999.AAE") {
+		t.Errorf("orphan sidecar should only be listed in its own section:\n%s", body)
+	}
+}
+
+// ── runHeadless ───────────────────────────────────────────────────────────────
+
+func TestRunHeadless(t *testing.T) {
+	setup := func(t *testing.T) (src, dest string) {
+		src = t.TempDir() + "/"
+		dest = t.TempDir() + "/"
+		for _, name := range []string{"Screenshot_20240316-142233.png", "IMG-20240315-WA0001.jpg", "notes.pdf"} {
+			if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return src, dest
+	}
+	run := func(src string, h headlessOptions, stdin string) (int, string, string) {
+		var out, errOut bytes.Buffer
+		h.Report.Dir = t.TempDir()
+		code := runHeadless(src, ScanOptions{}, h, strings.NewReader(stdin), &out, &errOut)
+		return code, out.String(), errOut.String()
+	}
+
+	t.Run("dry run changes nothing", func(t *testing.T) {
+		src, dest := setup(t)
+		code, out, _ := run(src, headlessOptions{Dest: dest, DryRun: true}, "")
+		if code != exitOK {
+			t.Errorf("exit = %d, want %d", code, exitOK)
+		}
+		for _, want := range []string{
+			"copy    Screenshot_20240316-142233.png → " + dest + "2024/03/2024-03-16-14-22-SCREENSHOT_20240316_142233.PNG",
+			"skip    notes.pdf  (unsupported extension: .pdf)",
+			"Found 2 processable items",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("output missing %q:\n%s", want, out)
+			}
+		}
+		if entries, _ := os.ReadDir(dest); len(entries) != 0 {
+			t.Errorf("dry run wrote %d entries to dest", len(entries))
+		}
+	})
+
+	t.Run("no confirmation aborts", func(t *testing.T) {
+		src, dest := setup(t)
+		code, _, errOut := run(src, headlessOptions{Dest: dest}, "")
+		if code != exitAborted || !strings.Contains(errOut, "-yes") {
+			t.Errorf("exit = %d, stderr %q; want %d and a hint about -yes", code, errOut, exitAborted)
+		}
+		if _, err := os.Stat(filepath.Join(src, "notes.pdf")); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("confirmed on stdin", func(t *testing.T) {
+		src, dest := setup(t)
+		code, out, _ := run(src, headlessOptions{Dest: dest}, "y\n")
+		if code != exitOK {
+			t.Errorf("exit = %d, want %d", code, exitOK)
+		}
+		if !strings.Contains(out, "[2/3] Screenshot_20240316-142233.png → ") || !strings.Contains(out, "Report written to") {
+			t.Errorf("unexpected output:\n%s", out)
+		}
+	})
+
+	t.Run("parallel copies print in plan order", func(t *testing.T) {
+		src, dest := setup(t)
+		code, out, _ := run(src, headlessOptions{Dest: dest, Yes: true, Exec: ExecOptions{Workers: 4}}, "")
+		if code != exitOK {
+			t.Errorf("exit = %d, want %d", code, exitOK)
+		}
+		first := strings.Index(out, "[1/3] IMG-20240315-WA0001.jpg → ")
+		second := strings.Index(out, "[2/3] Screenshot_20240316-142233.png → ")
+		if first < 0 || second < first {
+			t.Errorf("results out of order:\n%s", out)
+		}
+	})
+
+	t.Run("quiet with a collision", func(t *testing.T) {
+		src, dest := setup(t)
+		taken := filepath.Join(dest, "2024", "03", "2024-03-15-00-00-IMG_20240315_WA0001.JPG")
+		if err := os.MkdirAll(filepath.Dir(taken), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(taken, []byte("someone else"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		code, out, errOut := run(src, headlessOptions{Dest: dest, Yes: true, Quiet: true}, "")
+		if code != exitPartial {
+			t.Errorf("exit = %d, want %d", code, exitPartial)
+		}
+		if out != "" {
+			t.Errorf("quiet run printed to stdout:\n%s", out)
+		}
+		if !strings.Contains(errOut, "IMG-20240315-WA0001.jpg: collision") {
+			t.Errorf("stderr missing collision:\n%s", errOut)
+		}
+	})
+
+	t.Run("bad destination", func(t *testing.T) {
+		src, _ := setup(t)
+		if code, _, _ := run(src, headlessOptions{Dest: src, Yes: true}, ""); code != exitFailed {
+			t.Errorf("exit = %d, want %d", code, exitFailed)
+		}
+	})
+}
+
+func TestRunUndo(t *testing.T) {
+	// importJSON imports two dated files into a fresh dest and returns the
+	// path of the JSON report.
+	importJSON := func(t *testing.T) (src, dest, record string) {
+		src = t.TempDir() + "/"
+		dest = t.TempDir() + "/"
+		for _, name := range []string{"Screenshot_20240316-142233.png", "IMG-20240315-WA0001.jpg"} {
+			if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		h := headlessOptions{Dest: dest, Yes: true, Quiet: true}
+		h.Report = ReportOptions{Dir: t.TempDir(), Formats: []ReportFormat{ReportJSON}}
+		if code := runHeadless(src, ScanOptions{}, h, strings.NewReader(""), io.Discard, io.Discard); code != exitOK {
+			t.Fatalf("import exit = %d", code)
+		}
+		matches, _ := filepath.Glob(filepath.Join(h.Report.Dir, "import-report-*.json"))
+		if len(matches) != 1 {
+			t.Fatalf("want one JSON report, got %v", matches)
+		}
+		return src, dest, matches[0]
+	}
+	undo := func(args ...string) (int, string, string) {
+		var out, errOut bytes.Buffer
+		code := runUndo(args, false, &out, &errOut)
+		return code, out.String(), errOut.String()
+	}
+
+	t.Run("restores originals and removes copies", func(t *testing.T) {
+		src, dest, record := importJSON(t)
+		code, out, errOut := undo(record)
+		if code != exitOK {
+			t.Fatalf("exit = %d, stderr:\n%s", code, errOut)
+		}
+		if !strings.Contains(out, "Restored: 2  Skipped: 0  Errors: 0") {
+			t.Errorf("unexpected output:\n%s", out)
+		}
+		for _, name := range []string{"Screenshot_20240316-142233.png", "IMG-20240315-WA0001.jpg"} {
+			if _, err := os.Stat(filepath.Join(src, name)); err != nil {
+				t.Errorf("original not restored: %v", err)
+			}
+		}
+		if entries, _ := os.ReadDir(dest); len(entries) != 0 {
+			t.Errorf("dest still has %d entries, want the empty YYYY/MM folders removed", len(entries))
+		}
+		if _, err := os.Stat(filepath.Join(src, processedDirName)); !os.IsNotExist(err) {
+			t.Errorf("empty processed/ left behind: %v", err)
+		}
+		reports, _ := filepath.Glob(filepath.Join(filepath.Dir(record), "undo-report-*.txt"))
+		if len(reports) != 1 {
+			t.Errorf("want an undo report next to the import report, got %v", reports)
+		}
+	})
+
+	t.Run("dry run changes nothing", func(t *testing.T) {
+		src, dest, record := importJSON(t)
+		code, out, _ := undo("-dry-run", record)
+		if code != exitOK || !strings.Contains(out, "would restore ") {
+			t.Errorf("exit = %d, output:\n%s", code, out)
+		}
+		if _, err := os.Stat(filepath.Join(src, "IMG-20240315-WA0001.jpg")); !os.IsNotExist(err) {
+			t.Errorf("dry run restored an original: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dest, "2024", "03", "2024-03-15-00-00-IMG_20240315_WA0001.JPG")); err != nil {
+			t.Errorf("dry run removed a copy: %v", err)
+		}
+	})
+
+	t.Run("edited copy is skipped", func(t *testing.T) {
+		src, dest, record := importJSON(t)
+		edited := filepath.Join(dest, "2024", "03", "2024-03-15-00-00-IMG_20240315_WA0001.JPG")
+		if err := os.WriteFile(edited, []byte("cropped"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		code, _, errOut := undo(record)
+		if code != exitPartial || !strings.Contains(errOut, "copy has changed since the import") {
+			t.Errorf("exit = %d, stderr:\n%s", code, errOut)
+		}
+		if _, err := os.Stat(edited); err != nil {
+			t.Errorf("edited copy deleted: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(src, processedDirName, "IMG-20240315-WA0001.jpg")); err != nil {
+			t.Errorf("original of the edited copy moved: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(src, "Screenshot_20240316-142233.png")); err != nil {
+			t.Errorf("other original not restored: %v", err)
+		}
+	})
+
+	t.Run("copy that was already there is kept", func(t *testing.T) {
+		src := t.TempDir() + "/"
+		dest := t.TempDir() + "/"
+		if err := os.WriteFile(filepath.Join(src, "IMG-20240315-WA0001.jpg"), []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		existing := filepath.Join(dest, "2024", "03", "2024-03-15-00-00-IMG_20240315_WA0001.JPG")
+		if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(existing, []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		h := headlessOptions{Dest: dest, Yes: true, Quiet: true}
+		h.Report = ReportOptions{Dir: t.TempDir(), Formats: []ReportFormat{ReportJSON}}
+		if code := runHeadless(src, ScanOptions{}, h, strings.NewReader(""), io.Discard, io.Discard); code != exitOK {
+			t.Fatalf("import exit = %d", code)
+		}
+		matches, _ := filepath.Glob(filepath.Join(h.Report.Dir, "import-report-*.json"))
+		if code, _, errOut := undo(matches[0]); code != exitOK {
+			t.Fatalf("exit = %d, stderr:\n%s", code, errOut)
+		}
+		if _, err := os.Stat(existing); err != nil {
+			t.Errorf("undo deleted a file the import did not create: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(src, "IMG-20240315-WA0001.jpg")); err != nil {
+			t.Errorf("original not restored: %v", err)
+		}
+	})
+
+	t.Run("text report without a record is refused", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "import-report-2024-03-16-14-22-33.txt")
+		if err := os.WriteFile(path, []byte("Import Report"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if code, _, errOut := undo(path); code != exitFailed || !strings.Contains(errOut, "no import record beside it") {
+			t.Errorf("exit = %d, stderr:\n%s", code, errOut)
+		}
+	})
+
+	t.Run("default text report can be undone", func(t *testing.T) {
+		src := t.TempDir() + "/"
+		dest := t.TempDir() + "/"
+		if err := os.WriteFile(filepath.Join(src, "IMG-20240315-WA0001.jpg"), []byte("photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		h := headlessOptions{Dest: dest, Yes: true, Quiet: true}
+		h.Report.Dir = t.TempDir()
+		if code := runHeadless(src, ScanOptions{}, h, strings.NewReader(""), io.Discard, io.Discard); code != exitOK {
+			t.Fatalf("import exit = %d", code)
+		}
+		reports, _ := filepath.Glob(filepath.Join(h.Report.Dir, "import-report-*"))
+		records, _ := filepath.Glob(filepath.Join(h.Report.Dir, ".import-report-*.json"))
+		if len(reports) != 1 || filepath.Ext(reports[0]) != ".txt" || len(records) != 1 {
+			t.Fatalf("reports %v, records %v; want one text report and a hidden record", reports, records)
+		}
+		if code, _, errOut := undo(reports[0]); code != exitOK {
+			t.Fatalf("exit = %d, stderr:\n%s", code, errOut)
+		}
+		if _, err := os.Stat(filepath.Join(src, "IMG-20240315-WA0001.jpg")); err != nil {
+			t.Errorf("original not restored: %v", err)
+		}
+	})
+
+	t.Run("only folders the import made are removed", func(t *testing.T) {
+		src := t.TempDir() + "/"
+		dest := t.TempDir() + "/"
+		if err := os.WriteFile(filepath.Join(src, "IMG-20240315-WA0001.jpg"), []byte("photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// empty folders the user made before the import
+		for _, dir := range []string{filepath.Join(dest, "2024"), filepath.Join(src, processedDirName)} {
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		h := headlessOptions{Dest: dest, Yes: true, Quiet: true}
+		h.Report = ReportOptions{Dir: t.TempDir(), Formats: []ReportFormat{ReportJSON}}
+		if code := runHeadless(src, ScanOptions{}, h, strings.NewReader(""), io.Discard, io.Discard); code != exitOK {
+			t.Fatalf("import exit = %d", code)
+		}
+		records, _ := filepath.Glob(filepath.Join(h.Report.Dir, "import-report-*.json"))
+		doc, err := ReadImportRecord(records[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{filepath.Join(dest, "2024", "03")}; !slices.Equal(doc.CreatedDirs, want) {
+			t.Errorf("CreatedDirs = %v, want %v", doc.CreatedDirs, want)
+		}
+		if code, _, errOut := undo(records[0]); code != exitOK {
+			t.Fatalf("exit = %d, stderr:\n%s", code, errOut)
+		}
+		if _, err := os.Stat(filepath.Join(dest, "2024", "03")); !os.IsNotExist(err) {
+			t.Errorf("folder made by the import left behind: %v", err)
+		}
+		for _, dir := range []string{filepath.Join(dest, "2024"), filepath.Join(src, processedDirName)} {
+			if _, err := os.Stat(dir); err != nil {
+				t.Errorf("folder that predates the import removed: %v", err)
+			}
+		}
+	})
+}
+
+// interruptedImport starts a journalled import of three dated files, finishes
+// the first, and stops the second part way through copying, as if the
+// machine had gone to sleep there: only a temporary file is left.
+func interruptedImport(t *testing.T) (src, dest string, plan *ImportPlan) {
+	t.Helper()
+	src = t.TempDir() + "/"
+	dest = t.TempDir() + "/"
+	for _, name := range []string{"IMG-20240315-WA0001.jpg", "IMG-20240315-WA0002.jpg", "Screenshot_20240316-142233.png"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte("contents of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan, err := ScanDir(src, dest, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := BeginJournal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := j.ExecuteOne(0); !res.Succeeded {
+		t.Fatalf("first file: %+v", res)
+	}
+	cut := plan.Files[1]
+	if err := os.WriteFile(filepath.Join(filepath.Dir(cut.DestPath), "."+filepath.Base(cut.DestPath)+".tmp-123"), []byte("cont"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j.f.Close()
+	return src, dest, plan
+}
+
+func TestJournal_Resume(t *testing.T) {
+	src, dest, plan := interruptedImport(t)
+
+	if _, err := BeginJournal(plan); err != ErrJournalExists {
+		t.Errorf("BeginJournal() over an unfinished journal = %v, want ErrJournalExists", err)
+	}
+	j, err := OpenJournal(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done, total := j.Progress(); done != 1 || total != 3 {
+		t.Errorf("Progress() = %d/%d, want 1/3", done, total)
+	}
+	made := []string{filepath.Join(dest, "2024"), filepath.Join(dest, "2024", "03"), filepath.Join(src, processedDirName)}
+	if got := j.files[0].CreatedDirs; !slices.Equal(got, made) {
+		t.Errorf("CreatedDirs = %v, want %v", got, made)
+	}
+	for i := range j.Plan().Files {
+		if res := j.ExecuteOne(i); !res.Succeeded || res.Existed {
+			t.Errorf("file %d: %+v", i, res)
+		}
+	}
+	if err := j.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fp := range plan.Files {
+		got, err := os.ReadFile(fp.DestPath)
+		if err != nil || string(got) != "contents of "+fp.SourceName {
+			t.Errorf("%s = %q, %v", fp.DestPath, got, err)
+		}
+		if _, err := os.Stat(filepath.Join(src, processedDirName, fp.SourceName)); err != nil {
+			t.Errorf("original not in processed/: %v", err)
+		}
+	}
+	if _, err := os.Stat(JournalPath(dest)); !os.IsNotExist(err) {
+		t.Errorf("journal left behind: %v", err)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(dest, "2024", "03", ".*.tmp-*")); len(temps) != 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+}
+
+func TestJournal_Plan(t *testing.T) {
+	src, dest := t.TempDir()+"/", t.TempDir()+"/"
+	for _, name := range []string{"IMG-20240315-WA0001.jpg", "IMG-20240416-WA0002.jpg", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte("contents of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan, err := ScanDir(src, dest, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := BeginJournal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.f.Close()
+
+	j, err = OpenJournal(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.f.Close()
+	got := j.Plan()
+	if !maps.Equal(got.Groups, plan.Groups) || len(got.Groups) != 2 {
+		t.Errorf("Groups = %v, want %v", got.Groups, plan.Groups)
+	}
+	if len(got.Files) != len(plan.Files) {
+		t.Fatalf("%d files, want %d", len(got.Files), len(plan.Files))
+	}
+	for i, fp := range got.Files {
+		if fp.SourceName != plan.Files[i].SourceName || fp.Class != plan.Files[i].Class {
+			t.Errorf("file %d = %s (%v), want %s (%v)", i, fp.SourceName, fp.Class, plan.Files[i].SourceName, plan.Files[i].Class)
+		}
+	}
+}
+
+func TestJournal_Rollback(t *testing.T) {
+	src, dest, _ := interruptedImport(t)
+
+	j, err := OpenJournal(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := j.Rollback()
+	if report.Restored() != 1 || len(report.Errors()) != 0 {
+		t.Errorf("Rollback() restored %d, errors %v; want 1 and none", report.Restored(), report.Errors())
+	}
+	for _, name := range []string{"IMG-20240315-WA0001.jpg", "IMG-20240315-WA0002.jpg", "Screenshot_20240316-142233.png"} {
+		if _, err := os.Stat(filepath.Join(src, name)); err != nil {
+			t.Errorf("original not back in source: %v", err)
+		}
+	}
+	if entries, _ := os.ReadDir(dest); len(entries) != 0 {
+		t.Errorf("dest still has %d entries, want none", len(entries))
+	}
+}
+
+func TestRunHeadless_Unfinished(t *testing.T) {
+	run := func(dest string, h headlessOptions, stdin string) (int, string) {
+		var out, errOut bytes.Buffer
+		h.Dest = dest
+		h.Report.Dir = t.TempDir()
+		code := runHeadless(t.TempDir(), ScanOptions{}, h, strings.NewReader(stdin), &out, &errOut)
+		return code, errOut.String()
+	}
+
+	_, dest, _ := interruptedImport(t)
+	if code, errOut := run(dest, headlessOptions{Yes: true}, ""); code != exitFailed || !strings.Contains(errOut, "1 of 3 files done") {
+		t.Errorf("-yes without a choice: exit = %d, stderr:\n%s", code, errOut)
+	}
+	if code, _ := run(dest, headlessOptions{}, ""); code != exitAborted {
+		t.Errorf("no answer: exit = %d, want %d", code, exitAborted)
+	}
+	if code, errOut := run(dest, headlessOptions{}, "r\n"); code != exitOK {
+		t.Errorf("resume: exit = %d, stderr:\n%s", code, errOut)
+	}
+	if _, err := os.Stat(JournalPath(dest)); !os.IsNotExist(err) {
+		t.Errorf("journal left after resume: %v", err)
+	}
+
+	_, dest, _ = interruptedImport(t)
+	var out, errOut bytes.Buffer
+	blocked := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := headlessOptions{Dest: dest, Resume: true, Report: ReportOptions{Dir: blocked}}
+	if code := runHeadless(t.TempDir(), ScanOptions{}, h, strings.NewReader(""), &out, &errOut); code != exitFailed {
+		t.Errorf("resume with an unwritable report: exit = %d, want %d", code, exitFailed)
+	}
+	if _, err := os.Stat(JournalPath(dest)); err != nil {
+		t.Errorf("journal removed although the report was not written: %v", err)
+	}
+	if code, errOut := run(dest, headlessOptions{Resume: true}, ""); code != exitOK {
+		t.Errorf("resume after a failed report: exit = %d, stderr:\n%s", code, errOut)
+	}
+
+	_, dest, _ = interruptedImport(t)
+	if code, errOut := run(dest, headlessOptions{Rollback: true}, ""); code != exitOK {
+		t.Errorf("rollback: exit = %d, stderr:\n%s", code, errOut)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(dest, "20*")); len(entries) != 0 {
+		t.Errorf("rollback left %v in dest", entries)
+	}
+}
+
+func TestJournal_Execute(t *testing.T) {
+	src := t.TempDir() + "/"
+	dest := t.TempDir() + "/"
+	for n := 1; n <= 20; n++ {
+		name := fmt.Sprintf("IMG-20240315-WA%04d.jpg", n)
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, "notes.pdf"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := ScanDir(src, dest, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := BeginJournal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int]bool)
+	results := j.Execute(ExecOptions{Workers: 8}, func(i int, res FileResult) {
+		if seen[i] {
+			t.Errorf("file %d reported twice", i)
+		}
+		seen[i] = true
+	})
+	if err := j.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != len(plan.Files) || len(results) != len(plan.Files) {
+		t.Fatalf("%d callbacks, %d results for %d files", len(seen), len(results), len(plan.Files))
+	}
+	for i, res := range results {
+		if res.Plan.SourcePath != plan.Files[i].SourcePath {
+			t.Errorf("results[%d] is %s, want plan order", i, res.Plan.SourceName)
+		}
+		if copies := plan.Files[i].copies(); res.Succeeded != copies || res.Err != nil {
+			t.Errorf("%s: %+v", res.Plan.SourceName, res)
+		}
+	}
+}
+
+func TestDeviceLimits(t *testing.T) {
+	dir := t.TempDir()
+	if _, ok := deviceOf(dir); !ok {
+		t.Skip("no device IDs on this system")
+	}
+	limits := newDeviceLimits(1)
+	release := limits.acquire(filepath.Join(dir, "a.jpg"), filepath.Join(dir, "not", "yet", "b.jpg"))
+
+	acquired := make(chan func())
+	go func() { acquired <- limits.acquire(filepath.Join(dir, "c.jpg")) }()
+	select {
+	case <-acquired:
+		t.Fatal("second file got a slot on a device capped at one")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case again := <-acquired:
+		again()
+	case <-time.After(time.Second):
+		t.Fatal("slot not released")
+	}
+}
+
+func TestCatalog_InLibrary(t *testing.T) {
+	src := t.TempDir() + "/"
+	dest := t.TempDir() + "/"
+	for _, name := range []string{"Screenshot_20240316-142233.png", "IMG-20240315-WA0001.jpg"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := headlessOptions{Dest: dest, Yes: true, Quiet: true, Report: ReportOptions{Dir: t.TempDir()}}
+	if code := runHeadless(src, ScanOptions{}, h, strings.NewReader(""), io.Discard, io.Discard); code != exitOK {
+		t.Fatalf("import exit = %d", code)
+	}
+	cat, err := catalog.Open(dest)
+	if err != nil || cat.Len() != 2 {
+		t.Fatalf("catalog after import has %v entries, err %v; want 2", cat.Len(), err)
+	}
+
+	// the same screenshot under another name, and new content of the same size
+	again := t.TempDir()
+	if err := os.WriteFile(filepath.Join(again, "Screenshot_20240401-090000.png"), []byte("Screenshot_20240316-142233.png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(again, "IMG-20240401-WA0002.jpg"), []byte("IMG-20240401-WA0002.jpg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := ScanDir(again, dest, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	classes := make(map[string]FilePlan)
+	for _, fp := range plan.Files {
+		classes[fp.SourceName] = fp
+	}
+	if fp := classes["Screenshot_20240401-090000.png"]; fp.Class != ClassInLibrary ||
+		fp.SkipReason != "already in library as 2024/03/2024-03-16-14-22-SCREENSHOT_20240316_142233.PNG" || fp.DestPath != "" {
+		t.Errorf("duplicate screenshot: class %v, reason %q, dest %q", fp.Class, fp.SkipReason, fp.DestPath)
+	}
+	if fp := classes["IMG-20240401-WA0002.jpg"]; fp.Class != ClassProcessable {
+		t.Errorf("new file of a catalogued size: class %v, want processable", fp.Class)
+	}
+
+	// a deleted library file no longer counts
+	os.Remove(filepath.Join(dest, "2024", "03", "2024-03-16-14-22-SCREENSHOT_20240316_142233.PNG"))
+	if plan, _ = ScanDir(again, dest, ScanOptions{}); plan.Files[0].Class == ClassInLibrary || plan.Files[1].Class == ClassInLibrary {
+		t.Errorf("file deleted from the library still reported in it")
+	}
+
+	t.Run("rebuild", func(t *testing.T) {
+		if err := os.Remove(catalog.Path(dest)); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(dest, "import-report-2024-04-01-09-00-00.txt"), []byte("report"), 0644)
+		var out, errOut bytes.Buffer
+		if code := runCatalog([]string{"rebuild", dest}, "", ScanOptions{Layout: layout.Default()}, &out, &errOut); code != exitOK {
+			t.Fatalf("exit = %d, stderr:\n%s", code, errOut.String())
+		}
+		if !strings.Contains(out.String(), "Catalogued 1 files") {
+			t.Errorf("unexpected output:\n%s", out.String())
+		}
+		cat, err := catalog.Open(dest)
+		if err != nil || cat.Len() != 1 {
+			t.Fatalf("rebuilt catalog has %d entries, err %v; want 1", cat.Len(), err)
+		}
+		hash, _ := fileHash(filepath.Join(src, processedDirName, "IMG-20240315-WA0001.jpg"))
+		e := cat.Lookup(hash)
+		if len(e) != 1 || e[0].Path != "2024/03/2024-03-15-00-00-IMG_20240315_WA0001.JPG" || e[0].Session != catalog.SessionRebuild {
+			t.Errorf("rebuilt entries = %+v", e)
+		}
+		if code := runCatalog([]string{"rebuild"}, "", ScanOptions{}, io.Discard, io.Discard); code != exitFailed {
+			t.Errorf("rebuild with no library: exit = %d, want %d", code, exitFailed)
+		}
+	})
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"sort"
 	"strings"
 	"time"
@@ -30,6 +31,7 @@ type screen int
 
 const (
 	screenDestInput screen = iota
+	screenJournal          // an unfinished import was found in the destination
 	screenScanning
 	screenConfirm
 	screenExecuting
@@ -48,31 +50,51 @@ type msgFileResult struct {
 	index  int // index of the file just processed
 }
 
+// msgResults is the stream of msgFileResult from a running import.
+type msgResults <-chan msgFileResult
+
 type msgReportDone struct {
 	err error
 }
 
+type msgRollbackDone struct {
+	report *UndoReport
+	err    error
+}
+
 // ── Model ─────────────────────────────────────────────────────────────────────
 
 type model struct {
-	source string
-	dest   string
-	screen screen
-	err    error
+	source    string
+	dest      string
+	reportOut ReportOptions
+	opts      ScanOptions
+	exec      ExecOptions
+	screen    screen
+	err       error
 
 	input textinput.Model
 	spin  spinner.Model
 	prog  progress.Model
 
-	plan    *ImportPlan
-	report  *ImportReport
-	execIdx int // next file index to process
+	plan     *ImportPlan
+	journal  *Journal
+	results  msgResults // file results as workers finish them
+	report   *ImportReport
+	rollback *UndoReport // set when an unfinished import was rolled back
+	execIdx  int         // number of files processed so far
 }
 
-func newModel(source string) model {
+// newModel starts the importer on source. dest pre-fills the destination
+// prompt (the parent of source when empty); report says where the report goes
+// and exec how many files are copied at once.
+func newModel(source, dest string, report ReportOptions, opts ScanOptions, exec ExecOptions) model {
+	if dest == "" {
+		dest = DefaultDest(source)
+	}
 	ti := textinput.New()
-	ti.Placeholder = DefaultDest(source)
-	ti.SetValue(DefaultDest(source))
+	ti.Placeholder = dest
+	ti.SetValue(dest)
 	ti.Focus()
 	ti.Width = 60
 	ti.Prompt = stylePrompt.Render("  Destination: ")
@@ -84,11 +106,14 @@ func newModel(source string) model {
 	pr := progress.New(progress.WithDefaultGradient())
 
 	return model{
-		source: source,
-		input:  ti,
-		spin:   sp,
-		prog:   pr,
-		screen: screenDestInput,
+		source:    source,
+		reportOut: report,
+		opts:      opts,
+		exec:      exec,
+		input:     ti,
+		spin:      sp,
+		prog:      pr,
+		screen:    screenDestInput,
 	}
 }
 
@@ -136,8 +161,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case msgFileResult:
-		m.report.Results = append(m.report.Results, msg.result)
-		m.execIdx = msg.index + 1
+		// Results arrive in the order workers finish them; the report keeps
+		// plan order.
+		m.report.Results[msg.index] = msg.result
+		m.execIdx++
 		total := len(m.plan.Files)
 		pct := float64(m.execIdx) / float64(total)
 
@@ -145,12 +172,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// all files processed — write report
 			return m, tea.Batch(
 				m.prog.SetPercent(1.0),
-				cmdFinalise(m.report),
+				cmdFinalise(m.journal, m.report),
 			)
 		}
 		return m, tea.Batch(
 			m.prog.SetPercent(pct),
-			cmdProcessOne(m.plan, m.execIdx),
+			cmdNextResult(m.results),
 		)
 
 	case msgReportDone:
@@ -159,11 +186,18 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		m.screen = screenDone
 		return m, nil
+
+	case msgRollbackDone:
+		m.rollback, m.err = msg.report, msg.err
+		m.screen = screenDone
+		return m, nil
 	}
 
 	switch m.screen {
 	case screenDestInput:
 		return m.updateDestInput(msg)
+	case screenJournal:
+		return m.updateJournal(msg)
 	case screenConfirm:
 		return m.updateConfirm(msg)
 	}
@@ -179,7 +213,7 @@ func (m model) updateDestInput(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "enter":
 			dest := NormaliseDir(strings.TrimSpace(m.input.Value()))
 			if dest == "" {
-				dest = DefaultDest(m.source)
+				dest = NormaliseDir(m.input.Placeholder)
 			}
 			if err := ValidateDirectories(m.source, dest); err != nil {
 				m.err = err
@@ -187,30 +221,53 @@ func (m model) updateDestInput(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 			m.dest = dest
+			if j, err := OpenJournal(dest); err == nil {
+				m.journal = j
+				m.screen = screenJournal
+				return m, nil
+			} else if !os.IsNotExist(err) {
+				m.err = err
+				m.screen = screenDone
+				return m, nil
+			}
 			m.screen = screenScanning
-			return m, tea.Batch(m.spin.Tick, cmdScan(m.source, dest))
+			return m, tea.Batch(m.spin.Tick, cmdScan(m.source, dest, m.opts))
 		}
 	}
 	m.input, cmd = m.input.Update(msg)
 	return m, cmd
 }
 
+func (m model) updateJournal(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch strings.ToLower(msg.String()) {
+		case "r":
+			m.plan = m.journal.Plan()
+			return m.execute()
+		case "b":
+			m.screen = screenScanning
+			return m, tea.Batch(m.spin.Tick, cmdRollback(m.journal))
+		case "q", "enter":
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
 func (m model) updateConfirm(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch strings.ToLower(msg.String()) {
 		case "y":
-			m.screen = screenExecuting
-			m.execIdx = 0
-			m.report = &ImportReport{
-				StartedAt:   time.Now(),
-				Source:      m.plan.Source,
-				Destination: m.plan.Destination,
+			j, err := BeginJournal(m.plan)
+			if err != nil {
+				m.err = err
+				m.screen = screenDone
+				return m, nil
 			}
-			return m, tea.Batch(
-				m.prog.SetPercent(0),
-				cmdProcessOne(m.plan, 0),
-			)
+			m.journal = j
+			return m.execute()
 		case "n", "enter":
 			return m, tea.Quit
 		}
@@ -218,6 +275,42 @@ func (m model) updateConfirm(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// execute starts running m.journal on a pool of workers.
+func (m model) execute() (tea.Model, tea.Cmd) {
+	m.screen = screenExecuting
+	m.execIdx = 0
+	m.report = &ImportReport{
+		StartedAt:   time.Now(),
+		Source:      m.plan.Source,
+		Destination: m.plan.Destination,
+		Output:      m.reportOut,
+		Results:     make([]FileResult, len(m.plan.Files)),
+	}
+	if len(m.plan.Files) == 0 {
+		return m, cmdFinalise(m.journal, m.report)
+	}
+	m.results = startExecute(m.journal, m.exec)
+	return m, tea.Batch(
+		m.prog.SetPercent(0),
+		cmdNextResult(m.results),
+	)
+}
+
+// exitCode is the process exit status once the TUI has quit.
+func (m model) exitCode() int {
+	switch {
+	case m.err != nil:
+		return exitFailed
+	case m.rollback != nil && len(m.rollback.Errors()) > 0:
+		return exitPartial
+	case m.rollback != nil:
+		return exitOK
+	case m.report == nil:
+		return exitAborted
+	}
+	return reportExitCode(m.report)
+}
+
 // ── View ──────────────────────────────────────────────────────────────────────
 
 func (m model) View() string {
@@ -235,7 +328,20 @@ func (m model) View() string {
 		b.WriteString("\n\n")
 		b.WriteString(styleMuted.Render("  Press Enter to confirm, Ctrl+C to quit"))
 
+	case screenJournal:
+		done, total := m.journal.Progress()
+		b.WriteString(styleWarn.Render(fmt.Sprintf("  An import into %s did not finish.", m.journal.Destination)))
+		b.WriteString("\n")
+		b.WriteString(styleMuted.Render(fmt.Sprintf("  From %s, started %s: %d of %d files done.",
+			m.journal.Source, m.journal.StartedAt.Local().Format("2006-01-02 15:04:05"), done, total)))
+		b.WriteString("\n\n")
+		b.WriteString(stylePrompt.Render("  [r] resume it   [b] roll it back   [q] quit: "))
+
 	case screenScanning:
+		if m.journal != nil {
+			b.WriteString(fmt.Sprintf("  %s Rolling back…", m.spin.View()))
+			break
+		}
 		b.WriteString(fmt.Sprintf("  %s Scanning source directory…", m.spin.View()))
 
 	case screenConfirm:
@@ -251,6 +357,8 @@ func (m model) View() string {
 	case screenDone:
 		if m.err != nil {
 			b.WriteString(styleError.Render(fmt.Sprintf("  Error: %v", m.err)))
+		} else if m.rollback != nil {
+			b.WriteString(viewRollback(m.rollback))
 		} else if m.report != nil {
 			b.WriteString(viewReport(m.report))
 		} else {
@@ -267,16 +375,28 @@ func (m model) View() string {
 func viewPlan(p *ImportPlan) string {
 	var b strings.Builder
 
-	processable := 0
+	processable, orphans, suspicious, held := 0, 0, 0, 0
 	for _, f := range p.Files {
-		if f.Class == ClassProcessable {
+		switch f.Class {
+		case ClassProcessable:
 			processable++
+		case ClassOrphanSidecar:
+			orphans++
+		case ClassSuspiciousDate:
+			suspicious++
+			if f.DestPath == "" {
+				held++
+			}
 		}
 	}
-	skipped := len(p.Files) - processable
+	items := 0
+	for _, n := range p.Groups {
+		items += n
+	}
+	skipped := len(p.Files) - processable - suspicious
 
-	b.WriteString(fmt.Sprintf("  Found %s processable files:\n",
-		styleGood.Render(fmt.Sprintf("%d", processable))))
+	b.WriteString(fmt.Sprintf("  Found %s processable items:\n",
+		styleGood.Render(fmt.Sprintf("%d", items))))
 
 	dirs := make([]string, 0, len(p.Groups))
 	for d := range p.Groups {
@@ -284,14 +404,33 @@ func viewPlan(p *ImportPlan) string {
 	}
 	sort.Strings(dirs)
 	for _, d := range dirs {
-		b.WriteString(styleMuted.Render(fmt.Sprintf("    → %s%s  (%d files)\n",
+		b.WriteString(styleMuted.Render(fmt.Sprintf("    → %s%s  (%d items)\n",
 			p.Destination, d, p.Groups[d])))
 	}
 
+	if processable > items {
+		b.WriteString(styleMuted.Render(fmt.Sprintf("\n  %d files in total — Live Photos, RAW+JPEG pairs, edits and sidecars count as one item", processable)))
+		b.WriteString("\n")
+	}
+
+	if suspicious > 0 {
+		b.WriteString(styleWarn.Render(fmt.Sprintf("\n  Suspicious dates: %d file(s) (details in report)\n", suspicious)))
+		if copied := suspicious - held; copied > 0 {
+			b.WriteString(styleMuted.Render(fmt.Sprintf("    → %s%s/  (%d files, for review)\n", p.Destination, reviewDirName, copied)))
+		}
+		if held > 0 {
+			b.WriteString(styleMuted.Render(fmt.Sprintf("    held back in source  (%d files)\n", held)))
+		}
+	}
+
 	if skipped > 0 {
 		b.WriteString(styleWarn.Render(fmt.Sprintf("\n  Skipped: %d file(s) (details in report)", skipped)))
 		b.WriteString("\n")
 	}
+	if orphans > 0 {
+		b.WriteString(styleWarn.Render(fmt.Sprintf("  Orphan sidecars: %d (no matching photo, left in source)", orphans)))
+		b.WriteString("\n")
+	}
 
 	return b.String()
 }
@@ -306,6 +445,12 @@ func viewReport(r *ImportReport) string {
 	if len(r.Skipped()) > 0 {
 		b.WriteString(fmt.Sprintf("  Skipped:    %s\n", styleWarn.Render(fmt.Sprintf("%d", len(r.Skipped())))))
 	}
+	if len(r.Suspicious()) > 0 {
+		b.WriteString(fmt.Sprintf("  Suspicious: %s\n", styleWarn.Render(fmt.Sprintf("%d", len(r.Suspicious())))))
+	}
+	if len(r.OrphanSidecars()) > 0 {
+		b.WriteString(fmt.Sprintf("  Orphans:    %s\n", styleWarn.Render(fmt.Sprintf("%d", len(r.OrphanSidecars())))))
+	}
 	if len(r.Collisions()) > 0 {
 		b.WriteString(fmt.Sprintf("  Collisions: %s\n", styleWarn.Render(fmt.Sprintf("%d", len(r.Collisions())))))
 	}
@@ -313,32 +458,82 @@ func viewReport(r *ImportReport) string {
 		b.WriteString(fmt.Sprintf("  Errors:     %s\n", styleError.Render(fmt.Sprintf("%d", len(r.Errors())))))
 	}
 
-	b.WriteString(fmt.Sprintf("\n  Report written to:\n  %s\n",
-		styleMuted.Render(r.ReportPath)))
+	b.WriteString("\n  Report written to:\n")
+	for _, path := range r.ReportPaths {
+		b.WriteString(fmt.Sprintf("  %s\n", styleMuted.Render(path)))
+	}
+
+	return b.String()
+}
+
+func viewRollback(r *UndoReport) string {
+	var b strings.Builder
+
+	b.WriteString(styleGood.Render("  Rolled back."))
+	b.WriteString("\n\n")
+	b.WriteString(fmt.Sprintf("  Restored: %s\n", styleGood.Render(fmt.Sprintf("%d", r.Restored()))))
+	if len(r.Errors()) > 0 {
+		b.WriteString(fmt.Sprintf("  Errors:   %s\n", styleError.Render(fmt.Sprintf("%d", len(r.Errors())))))
+		b.WriteString(styleMuted.Render("  The journal is kept; run the importer again to retry."))
+		b.WriteString("\n")
+	}
+	if r.ReportPath != "" {
+		b.WriteString("\n  Report written to:\n")
+		b.WriteString(fmt.Sprintf("  %s\n", styleMuted.Render(r.ReportPath)))
+	}
 
 	return b.String()
 }
 
 // ── Commands ──────────────────────────────────────────────────────────────────
 
-func cmdScan(src, dest string) tea.Cmd {
+func cmdScan(src, dest string, opts ScanOptions) tea.Cmd {
 	return func() tea.Msg {
-		plan, err := ScanDir(src, dest)
+		plan, err := ScanDir(src, dest, opts)
 		return msgScanDone{plan: plan, err: err}
 	}
 }
 
-// cmdProcessOne processes a single file at index i and returns its result as a message.
-func cmdProcessOne(plan *ImportPlan, i int) tea.Cmd {
+// startExecute runs the journal's plan in the background, sending each file's
+// result as it finishes.
+func startExecute(j *Journal, exec ExecOptions) msgResults {
+	results := make(chan msgFileResult, max(exec.Workers, 1))
+	go func() {
+		j.Execute(exec, func(i int, res FileResult) {
+			results <- msgFileResult{result: res, index: i}
+		})
+		close(results)
+	}()
+	return results
+}
+
+// cmdNextResult waits for the next file to finish.
+func cmdNextResult(results msgResults) tea.Cmd {
 	return func() tea.Msg {
-		result := ExecuteOne(plan.Files[i], plan.Source)
-		return msgFileResult{result: result, index: i}
+		return <-results
 	}
 }
 
-func cmdFinalise(report *ImportReport) tea.Cmd {
+// cmdFinalise adds the files of a finished import to the library catalog,
+// writes its report and then removes its journal. The journal stays when
+// the report cannot be written, so the import can still be rolled back.
+func cmdFinalise(j *Journal, report *ImportReport) tea.Cmd {
 	return func() tea.Msg {
-		err := FinaliseReport(report)
+		err := catalogImport(report)
+		if rerr := FinaliseReport(report); rerr != nil {
+			return msgReportDone{err: rerr}
+		}
+		if ferr := j.Finish(); err == nil {
+			err = ferr
+		}
 		return msgReportDone{err: err}
 	}
 }
+
+func cmdRollback(j *Journal) tea.Cmd {
+	return func() tea.Msg {
+		report := j.Rollback()
+		err := FinaliseUndoReport(report, j.Destination)
+		return msgRollbackDone{report: report, err: err}
+	}
+}
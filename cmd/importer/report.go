@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportFormat names a report output, e.g. "json". It is also the report
+// file's extension, except text which is written as .txt.
+type ReportFormat string
+
+const (
+	ReportText     ReportFormat = "text" // the human-readable report
+	ReportJSON     ReportFormat = "json" // one document with a record per file
+	ReportCSV      ReportFormat = "csv"  // one row per file, same fields as JSON
+	ReportMarkdown ReportFormat = "md"   // summary and per-file table
+)
+
+// reportWriters renders an ImportReport in each format.
+var reportWriters = map[ReportFormat]func(io.Writer, *ImportReport) error{
+	ReportText:     writeTextReport,
+	ReportJSON:     writeJSONReport,
+	ReportCSV:      writeCSVReport,
+	ReportMarkdown: writeMarkdownReport,
+}
+
+// ParseReportFormats validates a comma-separated -report-format value.
+// "txt" and "markdown" are accepted as aliases.
+func ParseReportFormats(s string) ([]ReportFormat, error) {
+	var out []ReportFormat
+	for _, name := range strings.Split(s, ",") {
+		f := ReportFormat(strings.ToLower(strings.TrimSpace(name)))
+		switch f {
+		case "":
+			continue
+		case "txt":
+			f = ReportText
+		case "markdown":
+			f = ReportMarkdown
+		}
+		if _, ok := reportWriters[f]; !ok {
+			return nil, fmt.Errorf("unknown report format %q (want text, json, csv or md)", name)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// ext is the report file extension for f.
+func (f ReportFormat) ext() string {
+	if f == ReportText {
+		return "txt"
+	}
+	return string(f)
+}
+
+// ReportOptions say where FinaliseReport writes an ImportReport and in which
+// formats.
+type ReportOptions struct {
+	// Dir is the folder the report files are written to. Empty means the
+	// destination, or the working directory when there is none.
+	Dir string
+	// Formats to write, one file each. Nil means text only.
+	Formats []ReportFormat
+}
+
+// recordPrefix starts the name of the hidden JSON record written next to
+// the reports when JSON is not among their formats, so that every import
+// can be undone.
+const recordPrefix = ".import-report-"
+
+// writeReports writes r in every format in r.Output and returns the paths, in
+// format order, and the path of the JSON record undo reads: the JSON report,
+// or a hidden copy of it when JSON was not asked for.
+func writeReports(r *ImportReport) (paths []string, record string, err error) {
+	dir := r.Output.Dir
+	if dir == "" {
+		dir = r.Destination
+	}
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, "", fmt.Errorf("getting working directory: %w", err)
+		}
+		dir = wd
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, "", fmt.Errorf("creating report dir: %w", err)
+	}
+
+	formats := r.Output.Formats
+	if len(formats) == 0 {
+		formats = []ReportFormat{ReportText}
+	}
+	stamp := r.StartedAt.Format("2006-01-02-15-04-05")
+	for _, format := range formats {
+		path := filepath.Join(dir, fmt.Sprintf("import-report-%s.%s", stamp, format.ext()))
+		if err := writeReportFile(path, r, reportWriters[format]); err != nil {
+			return paths, "", err
+		}
+		paths = append(paths, path)
+		if format == ReportJSON {
+			record = path
+		}
+	}
+	if record == "" {
+		record = filepath.Join(dir, recordPrefix+stamp+".json")
+		if err := writeReportFile(record, r, writeJSONReport); err != nil {
+			return paths, "", err
+		}
+	}
+	return paths, record, nil
+}
+
+// recordFor returns the JSON record undo reads for the report at path: path
+// itself for a JSON report, otherwise the hidden record written beside it.
+func recordFor(path string) string {
+	name := filepath.Base(path)
+	if filepath.Ext(name) == ".json" || !strings.HasPrefix(name, "import-report-") {
+		return path
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, "import-report-"), filepath.Ext(name))
+	return filepath.Join(filepath.Dir(path), recordPrefix+stamp+".json")
+}
+
+func writeReportFile(path string, r *ImportReport, write func(io.Writer, *ImportReport) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeTextReport writes the human-readable report.
+func writeTextReport(w io.Writer, r *ImportReport) error {
+	fmt.Fprintf(w, "Import Report — %s\n", r.StartedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "Source:      %s\n", r.Source)
+	fmt.Fprintf(w, "Destination: %s\n\n", r.Destination)
+
+	fmt.Fprintf(w, "Summary\n")
+	fmt.Fprintf(w, "  Processed:  %d\n", r.Processed())
+	fmt.Fprintf(w, "  Skipped:    %d\n", len(r.Skipped()))
+	if len(r.OrphanSidecars()) > 0 {
+		fmt.Fprintf(w, "    of which orphan sidecars: %d\n", len(r.OrphanSidecars()))
+	}
+	fmt.Fprintf(w, "  Suspicious: %d\n", len(r.Suspicious()))
+	fmt.Fprintf(w, "  Collisions: %d\n", len(r.Collisions()))
+	fmt.Fprintf(w, "  Errors:     %d\n\n", len(r.Errors()))
+
+	fmt.Fprintf(w, "Processed files\n")
+	var succeeded []FileResult
+	for _, res := range r.Results {
+		if res.Succeeded && res.Plan.Class == ClassProcessable {
+			succeeded = append(succeeded, res)
+		}
+	}
+	for _, item := range groupResults(succeeded) {
+		line := fmt.Sprintf("  %s  →  %s", item[0].Plan.SourceName, item[0].Plan.DestPath)
+		if note := timeNote(item[0].Plan); note != "" {
+			line += "  " + note
+		}
+		fmt.Fprintln(w, line)
+		for _, res := range item[1:] {
+			fmt.Fprintf(w, "    + %s  →  %s\n", res.Plan.SourceName, res.Plan.DestPath)
+		}
+	}
+
+	if len(r.Skipped()) > len(r.OrphanSidecars()) {
+		fmt.Fprintf(w, "\nSkipped files\n")
+		for _, res := range r.Skipped() {
+			if res.Plan.Class == ClassOrphanSidecar {
+				continue
+			}
+			fmt.Fprintf(w, "  %s   reason: %s\n", res.Plan.SourceName, res.Plan.SkipReason)
+		}
+	}
+
+	if len(r.OrphanSidecars()) > 0 {
+		fmt.Fprintf(w, "\nOrphan sidecars (left in source — no matching photo in this import)\n")
+		for _, res := range r.OrphanSidecars() {
+			fmt.Fprintf(w, "  %s\n", res.Plan.RelPath)
+		}
+	}
+
+	if len(r.Suspicious()) > 0 {
+		fmt.Fprintf(w, "\nSuspicious dates (check these by hand)\n")
+		for _, res := range r.Suspicious() {
+			where := "held back in source"
+			if res.Succeeded {
+				where = "→  " + res.Plan.DestPath
+			} else if res.Plan.DestPath != "" {
+				where = "not copied, see errors/collisions"
+			}
+			fmt.Fprintf(w, "  %s  %s\n    reason: %s\n", res.Plan.SourceName, where, res.Plan.SkipReason)
+		}
+	}
+
+	if len(r.Collisions()) > 0 {
+		fmt.Fprintf(w, "\nCollisions (not copied — different file exists at destination)\n")
+		for _, res := range r.Collisions() {
+			fmt.Fprintf(w, "  %s  →  %s\n", res.Plan.SourceName, res.Plan.DestPath)
+		}
+	}
+
+	if len(r.Errors()) > 0 {
+		fmt.Fprintf(w, "\nErrors\n")
+		for _, res := range r.Errors() {
+			fmt.Fprintf(w, "  %s   error: %v\n", res.Plan.SourceName, res.Err)
+		}
+	}
+
+	return nil
+}
+
+// reportRecord is one file in the JSON, CSV and Markdown reports.
+type reportRecord struct {
+	Source      string  `json:"source"`                  // path relative to the source root
+	Dest        string  `json:"dest,omitempty"`          // where it was (or would have been) copied
+	DestExisted bool    `json:"dest_existed,omitempty"`  // a file was already at Dest; nothing was copied there
+	MovedTo     string  `json:"moved_to,omitempty"`      // where the original went, under processed/
+	Group       string  `json:"group,omitempty"`         // companion group key
+	Class       string  `json:"class"`                   // see FileClass.String
+	Status      string  `json:"status"`                  // see FileResult.Status
+	SkipReason  string  `json:"skip_reason,omitempty"`   // why it was skipped, or why its date is suspect
+	Error       string  `json:"error,omitempty"`         // what went wrong copying it
+	TakenAt     string  `json:"taken_at,omitempty"`      // RFC 3339 capture time the name was built from
+	DateSource  string  `json:"date_source,omitempty"`   // exif, container, filename, path or mtime
+	ShotOffset  string  `json:"shot_offset,omitempty"`   // UTC offset recorded in the file
+	ClockFix    string  `json:"clock_fix,omitempty"`     // clock table correction
+	SourceHash  string  `json:"source_sha256,omitempty"` // SHA-256 of the original
+	DestHash    string  `json:"dest_sha256,omitempty"`   // SHA-256 of the file at Dest
+	DurationMS  float64 `json:"duration_ms"`             // time spent copying and verifying
+}
+
+// recordColumns are the CSV header, in reportRecord field order.
+var recordColumns = []string{
+	"source", "dest", "dest_existed", "moved_to", "group", "class", "status", "skip_reason", "error",
+	"taken_at", "date_source", "shot_offset", "clock_fix", "source_sha256", "dest_sha256", "duration_ms",
+}
+
+func newRecord(res FileResult) reportRecord {
+	fp := res.Plan
+	rec := reportRecord{
+		Source:      fp.RelPath,
+		Dest:        fp.DestPath,
+		DestExisted: res.Existed,
+		MovedTo:     res.MovedTo,
+		Group:       fp.Group,
+		Class:       fp.Class.String(),
+		Status:      res.Status(),
+		SkipReason:  fp.SkipReason,
+		DateSource:  string(fp.DateSource),
+		ShotOffset:  fp.ShotOffset,
+		SourceHash:  res.SourceHash,
+		DestHash:    res.DestHash,
+		DurationMS:  float64(res.Duration.Microseconds()) / 1000,
+	}
+	if rec.Source == "" {
+		rec.Source = fp.SourceName
+	}
+	if res.Err != nil {
+		rec.Error = res.Err.Error()
+	}
+	if !fp.TakenAt.IsZero() {
+		rec.TakenAt = fp.TakenAt.Format(time.RFC3339Nano)
+	}
+	if fp.ClockFix != 0 {
+		rec.ClockFix = formatCorrection(fp.ClockFix)
+	}
+	return rec
+}
+
+func (rec reportRecord) columns() []string {
+	return []string{
+		rec.Source, rec.Dest, strconv.FormatBool(rec.DestExisted), rec.MovedTo, rec.Group, rec.Class, rec.Status, rec.SkipReason, rec.Error,
+		rec.TakenAt, rec.DateSource, rec.ShotOffset, rec.ClockFix, rec.SourceHash, rec.DestHash,
+		strconv.FormatFloat(rec.DurationMS, 'f', 3, 64),
+	}
+}
+
+// reportDocument is the JSON report.
+type reportDocument struct {
+	StartedAt   time.Time      `json:"started_at"`
+	FinishedAt  time.Time      `json:"finished_at"`
+	Source      string         `json:"source"`
+	Destination string         `json:"destination"`
+	Summary     reportSummary  `json:"summary"`
+	Files       []reportRecord `json:"files"`
+	// CreatedDirs are the folders the import made, under the destination
+	// and processed/; undo removes those left empty.
+	CreatedDirs []string `json:"created_dirs,omitempty"`
+}
+
+type reportSummary struct {
+	Processed      int `json:"processed"`
+	Skipped        int `json:"skipped"`
+	OrphanSidecars int `json:"orphan_sidecars"`
+	Suspicious     int `json:"suspicious"`
+	Collisions     int `json:"collisions"`
+	Errors         int `json:"errors"`
+}
+
+func summarise(r *ImportReport) reportSummary {
+	return reportSummary{
+		Processed:      r.Processed(),
+		Skipped:        len(r.Skipped()),
+		OrphanSidecars: len(r.OrphanSidecars()),
+		Suspicious:     len(r.Suspicious()),
+		Collisions:     len(r.Collisions()),
+		Errors:         len(r.Errors()),
+	}
+}
+
+// writeJSONReport writes the report as one indented JSON document.
+func writeJSONReport(w io.Writer, r *ImportReport) error {
+	doc := reportDocument{
+		StartedAt:   r.StartedAt,
+		FinishedAt:  r.FinishedAt,
+		Source:      r.Source,
+		Destination: r.Destination,
+		Summary:     summarise(r),
+		Files:       []reportRecord{},
+	}
+	for _, res := range r.Results {
+		doc.Files = append(doc.Files, newRecord(res))
+		doc.CreatedDirs = append(doc.CreatedDirs, res.CreatedDirs...)
+	}
+	slices.Sort(doc.CreatedDirs)
+	doc.CreatedDirs = slices.Compact(doc.CreatedDirs)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// writeCSVReport writes a header row and one row per file.
+func writeCSVReport(w io.Writer, r *ImportReport) error {
+	cw := csv.NewWriter(w)
+	cw.Write(recordColumns) //nolint:errcheck // reported by cw.Error
+	for _, res := range r.Results {
+		cw.Write(newRecord(res).columns()) //nolint:errcheck // reported by cw.Error
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeMarkdownReport writes the summary and a table of every file.
+func writeMarkdownReport(w io.Writer, r *ImportReport) error {
+	s := summarise(r)
+	fmt.Fprintf(w, "# Import report — %s\n\n", r.StartedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "- Source: `%s`\n- Destination: `%s`\n", r.Source, r.Destination)
+	if !r.FinishedAt.IsZero() {
+		fmt.Fprintf(w, "- Took: %s\n", r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond))
+	}
+	fmt.Fprintf(w, "\n| Processed | Skipped | Orphan sidecars | Suspicious | Collisions | Errors |\n")
+	fmt.Fprintf(w, "|---:|---:|---:|---:|---:|---:|\n")
+	fmt.Fprintf(w, "| %d | %d | %d | %d | %d | %d |\n\n",
+		s.Processed, s.Skipped, s.OrphanSidecars, s.Suspicious, s.Collisions, s.Errors)
+
+	fmt.Fprintf(w, "| Source | Status | Destination | Date | Note |\n")
+	fmt.Fprintf(w, "|---|---|---|---|---|\n")
+	for _, res := range r.Results {
+		rec := newRecord(res)
+		note := rec.SkipReason
+		if rec.Error != "" {
+			note = rec.Error
+		}
+		date := ""
+		if rec.TakenAt != "" {
+			date = rec.TakenAt + " (" + rec.DateSource + ")"
+		}
+		_, err := fmt.Fprintf(w, "| %s | %s | %s | %s | %s |\n",
+			markdownCell(rec.Source), rec.Status, markdownCell(rec.Dest), date, markdownCell(note))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markdownCell escapes the characters that would break a table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", " ")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SuspiciousPolicy says what happens to files whose date looks wrong.
+type SuspiciousPolicy string
+
+const (
+	// SuspiciousReview copies flagged files to <dest>/review/YYYY/MM/ so they
+	// can be checked by hand, and moves the original to processed/ as usual.
+	SuspiciousReview SuspiciousPolicy = "review"
+	// SuspiciousHold leaves flagged files untouched in the source.
+	SuspiciousHold SuspiciousPolicy = "hold"
+)
+
+// reviewDirName is the folder under the destination that flagged files go to.
+const reviewDirName = "review"
+
+// DefaultDateFloor is the earliest date accepted without question. Cameras
+// with a reset clock start at 2000-01-01 (or 1970-01-01), so anything before
+// 2000-01-02 is almost certainly a wrong clock rather than a real photo.
+var DefaultDateFloor = time.Date(2000, 1, 2, 0, 0, 0, 0, time.Local)
+
+// futureTolerance allows for clocks in a zone ahead of ours before a date
+// counts as being in the future.
+const futureTolerance = 36 * time.Hour
+
+// SuspiciousOptions configures the bad-date checks run by ScanDir.
+type SuspiciousOptions struct {
+	// Floor flags dates before it. Zero disables the check.
+	Floor time.Time
+	// MaxDrift flags files whose embedded (EXIF or container) date and mtime
+	// differ by more than this. Zero disables the check, since copies made
+	// without preserving mtime legitimately disagree.
+	MaxDrift time.Duration
+	// Policy decides whether flagged files go to review/ or stay in the source.
+	Policy SuspiciousPolicy
+}
+
+// DefaultSuspiciousOptions returns the checks used when nothing is configured.
+func DefaultSuspiciousOptions() SuspiciousOptions {
+	return SuspiciousOptions{Floor: DefaultDateFloor, Policy: SuspiciousReview}
+}
+
+// ParseSuspiciousPolicy validates a -suspicious flag value.
+func ParseSuspiciousPolicy(s string) (SuspiciousPolicy, error) {
+	switch p := SuspiciousPolicy(s); p {
+	case SuspiciousReview, SuspiciousHold:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown suspicious-date policy %q (want %q or %q)", s, SuspiciousReview, SuspiciousHold)
+}
+
+// suspectReason returns why fp's date looks wrong, or "" when it looks fine.
+func suspectReason(fp FilePlan, opts SuspiciousOptions, now time.Time) string {
+	t := fp.TakenAt
+	if !opts.Floor.IsZero() && t.Before(opts.Floor) {
+		return fmt.Sprintf("date %s is before %s (camera clock reset?)",
+			t.Format("2006-01-02 15:04"), opts.Floor.Format("2006-01-02"))
+	}
+	if t.After(now.Add(futureTolerance)) {
+		return fmt.Sprintf("date %s is in the future (wrong clock?)", t.Format("2006-01-02 15:04"))
+	}
+	if opts.MaxDrift > 0 && fp.DateSource != DateFromModTime {
+		fi, err := os.Stat(fp.SourcePath)
+		if err != nil {
+			return ""
+		}
+		drift := fi.ModTime().Sub(t)
+		if drift < 0 {
+			drift = -drift
+		}
+		if drift > opts.MaxDrift {
+			return fmt.Sprintf("%s date %s and mtime %s are %s apart",
+				fp.DateSource, t.Format("2006-01-02"), fi.ModTime().Format("2006-01-02"),
+				drift.Round(time.Hour))
+		}
+	}
+	return ""
+}
+
+// flagSuspicious reclassifies processable files with implausible dates as
+// ClassSuspiciousDate. Companions are flagged together so a group is never
+// split between the library and review/. Under SuspiciousReview the
+// destination moves to <dest>/review/YYYY/MM/; under SuspiciousHold it is
+// cleared so the file is left in the source.
+func flagSuspicious(files []FilePlan, dest string, opts SuspiciousOptions, now time.Time) {
+	reasons := make(map[int]string)
+	groupReason := make(map[string]string)
+	for i, fp := range files {
+		if fp.Class != ClassProcessable {
+			continue
+		}
+		if r := suspectReason(fp, opts, now); r != "" {
+			reasons[i] = r
+			if fp.Group != "" && groupReason[fp.Group] == "" {
+				groupReason[fp.Group] = r
+			}
+		}
+	}
+	for i := range files {
+		fp := &files[i]
+		if fp.Class != ClassProcessable {
+			continue
+		}
+		reason := reasons[i]
+		if reason == "" && fp.Group != "" {
+			reason = groupReason[fp.Group]
+		}
+		if reason == "" {
+			continue
+		}
+		fp.Class = ClassSuspiciousDate
+		fp.SkipReason = reason
+		if opts.Policy == SuspiciousHold {
+			fp.DestDir, fp.DestPath = "", ""
+			continue
+		}
+		fp.DestDir = filepath.Join(reviewDirName, fp.DestDir)
+		fp.DestPath = filepath.Join(dest, fp.DestDir, filepath.Base(fp.DestPath))
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/catalog"
+)
+
+// UndoResult records what undoing one imported file did, or would do.
+type UndoResult struct {
+	Source  string // where the original is restored to
+	Dest    string // the copy the import made
+	MovedTo string // where the original was, under processed/; empty if the import kept it in place
+	// Restored is set once the original is back and the copy deleted
+	// (or would be, in a dry run).
+	Restored bool
+	Skipped  string // why the file was left alone, e.g. the copy was edited since
+	Err      error
+}
+
+// UndoReport is the result of Undo.
+type UndoReport struct {
+	StartedAt   time.Time
+	Record      string // path of the import's JSON report
+	Source      string
+	Destination string
+	DryRun      bool
+	Results     []UndoResult
+	ReportPath  string
+}
+
+// Restored counts files put back (or that would be, in a dry run).
+func (r *UndoReport) Restored() int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Restored {
+			n++
+		}
+	}
+	return n
+}
+
+// Skipped returns files left alone because undoing them was not safe.
+func (r *UndoReport) Skipped() []UndoResult {
+	var out []UndoResult
+	for _, res := range r.Results {
+		if res.Skipped != "" {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// Errors returns files that failed part way.
+func (r *UndoReport) Errors() []UndoResult {
+	var out []UndoResult
+	for _, res := range r.Results {
+		if res.Err != nil {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// ReadImportRecord loads the JSON record of an import: path when it is the
+// JSON report, otherwise the hidden record written beside the text, CSV or
+// Markdown report at path.
+func ReadImportRecord(path string) (*reportDocument, error) {
+	record := recordFor(path)
+	data, err := os.ReadFile(record)
+	if os.IsNotExist(err) && record != path {
+		return nil, fmt.Errorf("%s has no import record beside it (%s)", path, filepath.Base(record))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc reportDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON import report: %w", record, err)
+	}
+	if doc.Source == "" || doc.Destination == "" {
+		return nil, fmt.Errorf("%s is not a JSON import report: no source or destination", path)
+	}
+	return &doc, nil
+}
+
+// Undo reverses the import described by doc, last file first. For each file
+// that was copied it checks the copy still has the SHA-256 recorded at import,
+// moves the original back out of processed/, then deletes the copy. Copies
+// that were already at the destination before the import are left alone.
+// Folders the import made under the destination and processed/ are removed
+// once empty, and the deleted copies are dropped from the library catalog.
+// With dryRun nothing is changed, but every check is still made.
+func Undo(doc *reportDocument, dryRun bool) *UndoReport {
+	report := &UndoReport{
+		StartedAt:   time.Now(),
+		Source:      doc.Source,
+		Destination: doc.Destination,
+		DryRun:      dryRun,
+	}
+	var deleted []string
+	for i := len(doc.Files) - 1; i >= 0; i-- {
+		rec := doc.Files[i]
+		if rec.Status != "copied" && rec.Status != "review" {
+			continue
+		}
+		res := undoFile(doc, rec, dryRun)
+		report.Results = append(report.Results, res)
+		if res.Restored && !dryRun && !rec.DestExisted {
+			deleted = append(deleted, res.Dest)
+		}
+	}
+	if !dryRun {
+		removeEmptyDirs(doc.CreatedDirs)
+	}
+	if err := uncatalog(doc.Destination, deleted); err != nil {
+		report.Results = append(report.Results, UndoResult{Dest: catalog.Path(doc.Destination), Err: err})
+	}
+	return report
+}
+
+// uncatalog drops deleted copies from the library catalog.
+func uncatalog(dest string, deleted []string) error {
+	if len(deleted) == 0 {
+		return nil
+	}
+	cat, err := catalog.Open(dest)
+	if err != nil {
+		return fmt.Errorf("reading library catalog: %w", err)
+	}
+	var rels []string
+	for _, path := range deleted {
+		if rel, err := filepath.Rel(dest, path); err == nil {
+			rels = append(rels, rel)
+		}
+	}
+	if err := cat.Remove(rels...); err != nil {
+		return fmt.Errorf("updating library catalog: %w", err)
+	}
+	return nil
+}
+
+func undoFile(doc *reportDocument, rec reportRecord, dryRun bool) UndoResult {
+	res := UndoResult{
+		Source:  filepath.Join(doc.Source, rec.Source),
+		Dest:    rec.Dest,
+		MovedTo: rec.MovedTo,
+	}
+
+	deleteCopy := !rec.DestExisted
+	if deleteCopy {
+		switch hash, err := fileHash(rec.Dest); {
+		case os.IsNotExist(err):
+			deleteCopy = false // already gone; still put the original back
+		case err != nil:
+			res.Err = fmt.Errorf("hashing copy: %w", err)
+			return res
+		case rec.DestHash == "":
+			res.Skipped = "no hash in the import record to check the copy against"
+			return res
+		case hash != rec.DestHash:
+			res.Skipped = "copy has changed since the import"
+			return res
+		}
+	}
+
+	if rec.MovedTo != "" {
+		if _, err := os.Lstat(res.Source); err == nil {
+			res.Skipped = "a file is already back at the source location"
+			return res
+		}
+		if _, err := os.Stat(rec.MovedTo); err != nil {
+			res.Err = fmt.Errorf("original not found in processed/: %w", err)
+			return res
+		}
+	}
+	if dryRun {
+		res.Restored = true
+		return res
+	}
+
+	if rec.MovedTo != "" {
+		if err := os.MkdirAll(filepath.Dir(res.Source), 0755); err != nil {
+			res.Err = fmt.Errorf("recreating source folder: %w", err)
+			return res
+		}
+		if err := os.Rename(rec.MovedTo, res.Source); err != nil {
+			res.Err = fmt.Errorf("moving original back: %w", err)
+			return res
+		}
+	}
+	if deleteCopy {
+		if err := os.Remove(rec.Dest); err != nil {
+			res.Err = fmt.Errorf("deleting copy: %w", err)
+			return res
+		}
+	}
+	res.Restored = true
+	return res
+}
+
+// removeEmptyDirs removes those of dirs, folders an import made, that are
+// empty, innermost first. Folders that hold anything are left alone.
+func removeEmptyDirs(dirs []string) {
+	dirs = slices.Clone(dirs)
+	sort.Slice(dirs, func(a, b int) bool { return len(dirs[a]) > len(dirs[b]) })
+	for _, dir := range dirs {
+		os.Remove(dir) //nolint:errcheck // not empty, or already gone
+	}
+}
+
+// FinaliseUndoReport writes the undo report to dir and attaches its path.
+func FinaliseUndoReport(r *UndoReport, dir string) error {
+	name := fmt.Sprintf("undo-report-%s.txt", r.StartedAt.Format("2006-01-02-15-04-05"))
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("writing undo report: %w", err)
+	}
+	writeUndoReport(f, r)
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing undo report: %w", err)
+	}
+	r.ReportPath = path
+	return nil
+}
+
+func writeUndoReport(w io.Writer, r *UndoReport) {
+	title, restored := "Undo Report", "Restored files"
+	if r.DryRun {
+		title, restored = "Undo Report (dry run, nothing changed)", "Would restore"
+	}
+	fmt.Fprintf(w, "%s — %s\n", title, r.StartedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "Import record: %s\n", r.Record)
+	fmt.Fprintf(w, "Source:        %s\n", r.Source)
+	fmt.Fprintf(w, "Destination:   %s\n\n", r.Destination)
+
+	fmt.Fprintf(w, "Summary\n")
+	fmt.Fprintf(w, "  Restored: %d\n", r.Restored())
+	fmt.Fprintf(w, "  Skipped:  %d\n", len(r.Skipped()))
+	fmt.Fprintf(w, "  Errors:   %d\n\n", len(r.Errors()))
+
+	fmt.Fprintf(w, "%s\n", restored)
+	for _, res := range r.Results {
+		if res.Restored {
+			fmt.Fprintf(w, "  %s  ←  %s\n", res.Source, res.Dest)
+		}
+	}
+	if len(r.Skipped()) > 0 {
+		fmt.Fprintf(w, "\nSkipped (left as they are)\n")
+		for _, res := range r.Skipped() {
+			fmt.Fprintf(w, "  %s   reason: %s\n", res.Dest, res.Skipped)
+		}
+	}
+	if len(r.Errors()) > 0 {
+		fmt.Fprintf(w, "\nErrors\n")
+		for _, res := range r.Errors() {
+			fmt.Fprintf(w, "  %s   error: %v\n", res.Dest, res.Err)
+		}
+	}
+}
+
+// runUndo implements "importer undo [-dry-run] [-report-dir dir] <report>",
+// where report is any of the import's reports.
+// dryRun is the default of its -dry-run flag, so "importer -dry-run undo"
+// works too. It returns the process exit code.
+func runUndo(args []string, dryRun bool, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("undo", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.BoolVar(&dryRun, "dry-run", dryRun, "check and list what undo would do, change nothing")
+	reportDir := fs.String("report-dir", "", "folder for the undo report (default: next to the import report)")
+	fs.Usage = func() {
+		fmt.Fprintf(errOut, "Usage: importer undo [-dry-run] [-report-dir <dir>] <import-report>\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitFailed
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitFailed
+	}
+
+	doc, err := ReadImportRecord(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailed
+	}
+	report := Undo(doc, dryRun)
+	report.Record = fs.Arg(0)
+	for _, res := range report.Results {
+		switch {
+		case res.Err != nil:
+			fmt.Fprintf(errOut, "%s: error: %v\n", res.Dest, res.Err)
+		case res.Skipped != "":
+			fmt.Fprintf(errOut, "%s: skipped, %s\n", res.Dest, res.Skipped)
+		case dryRun:
+			fmt.Fprintf(out, "would restore %s ← %s\n", res.Source, res.Dest)
+		default:
+			fmt.Fprintf(out, "restored %s ← %s\n", res.Source, res.Dest)
+		}
+	}
+
+	dir := *reportDir
+	if dir == "" {
+		dir = filepath.Dir(fs.Arg(0))
+	}
+	if err := FinaliseUndoReport(report, dir); err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailed
+	}
+	fmt.Fprintf(out, "Restored: %d  Skipped: %d  Errors: %d\nReport written to %s\n",
+		report.Restored(), len(report.Skipped()), len(report.Errors()), report.ReportPath)
+	if len(report.Skipped()) > 0 || len(report.Errors()) > 0 {
+		return exitPartial
+	}
+	return exitOK
+}
@@ -7,54 +7,116 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/cemeng/photos-organiser/internal/config"
+	"github.com/cemeng/photos-organiser/internal/layout"
+	"github.com/cemeng/photos-organiser/internal/media"
 	"github.com/pkg/errors"
 )
 
+// processedNames are the filename templates organiser recognises, tried in
+// order: renamer's, importer's, and any name starting with a YYYY-MM-DD date.
+var processedNames = []string{layout.RenamerName, layout.DefaultName, "{YYYY}-{MM}-{DD}-{name}.{ext}"}
+
 func main() {
 	// Set custom usage message
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Organiser - A tool to organize processed photos into monthly folders\n\n")
 		fmt.Fprintf(os.Stderr, "Usage:\n")
-		fmt.Fprintf(os.Stderr, "  %s -src=<source_dir>/ [-dry-run]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -src=<source_dir>/ [-layout=<template>] [-from=<template>] [-dry-run]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [-config=<file>] [-profile=<name>] config show\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Description:\n")
 		fmt.Fprintf(os.Stderr, "  Organiser takes processed photos (in YYYY-MM-DD-HH-mm-SS-xxxx.ext format)\n")
 		fmt.Fprintf(os.Stderr, "  and organizes them into monthly folders (01/ through 12/).\n")
 		fmt.Fprintf(os.Stderr, "  Files are copied to the appropriate month folder based on their names.\n")
-		fmt.Fprintf(os.Stderr, "  Original files remain in the source directory.\n\n")
+		fmt.Fprintf(os.Stderr, "  Original files remain in the source directory.\n")
+		fmt.Fprintf(os.Stderr, "  -layout picks a different folder scheme, e.g. {YYYY}-{MM}-{DD} or Q{Q}.\n\n")
 		fmt.Fprintf(os.Stderr, "Arguments:\n")
 		fmt.Fprintf(os.Stderr, "  -src     Source directory containing the processed files (required)\n")
+		fmt.Fprintf(os.Stderr, "  -layout  Folder template under src (optional, defaults to %s)\n", layout.OrganiserDir)
+		fmt.Fprintf(os.Stderr, "  -from    Filename template the files were named with (optional, defaults to\n")
+		fmt.Fprintf(os.Stderr, "           renamer's and importer's names, or any name starting YYYY-MM-DD-;\n")
+		fmt.Fprintf(os.Stderr, "           the config file's name template when it sets one)\n")
+		fmt.Fprintf(os.Stderr, "  -tz      Zone the filenames are in (optional, defaults to the config's timezone, then local)\n")
+		fmt.Fprintf(os.Stderr, "  -ext     Comma-separated extensions to move, e.g. jpg,heic (optional, defaults to all)\n")
+		fmt.Fprintf(os.Stderr, "  -config  Config file (optional, defaults to $XDG_CONFIG_HOME/photos-organiser/config)\n")
+		fmt.Fprintf(os.Stderr, "  -profile Config profile to use (optional)\n")
 		fmt.Fprintf(os.Stderr, "  -dry-run Show what would be done without making any changes\n")
 		fmt.Fprintf(os.Stderr, "  -help    Show this help message\n\n")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
 		fmt.Fprintf(os.Stderr, "  organiser -src=~/Pictures/2023/\n")
+		fmt.Fprintf(os.Stderr, "  organiser -src=~/Pictures/2023/ -layout='{YYYY}-{MM}-{DD}'\n")
 		fmt.Fprintf(os.Stderr, "  organiser -src=~/Pictures/2023/ -dry-run\n\n")
 		fmt.Fprintf(os.Stderr, "Note: Directory paths must end with a trailing slash (/)\n")
 	}
 
+	cfg, sel, err := config.FromArgs(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	var srcDirectory string
+	var dirFlag, fromFlag, tz string
 	var dryRun bool
+	flag.String("config", sel.Path, "config file")
+	flag.String("profile", sel.Profile, "config profile to use")
 	flag.StringVar(&srcDirectory, "src", "", "source directory containing the processed files")
+	flag.StringVar(&dirFlag, "layout", layout.OrganiserDir, "folder template under src")
+	flag.StringVar(&fromFlag, "from", cfg.Name, "filename template the files were named with")
+	flag.StringVar(&tz, "tz", cfg.Timezone, "zone the filenames are in (default: local)")
+	flag.String("ext", strings.Join(cfg.Extensions, ","), "comma-separated extensions to move (default: all)")
 	flag.BoolVar(&dryRun, "dry-run", false, "show what would be done without making any changes")
 	flag.Parse()
 
+	// The config's layout describes the library, not the folders inside one
+	// year that organiser sorts into, so only -layout sets dirFlag.
+	err = config.Apply(&cfg, flag.CommandLine, map[string]string{"from": "name", "tz": "timezone", "ext": "extensions"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flag.NArg() == 2 && flag.Arg(0) == "config" && flag.Arg(1) == "show" {
+		config.Show(os.Stdout, sel, cfg, map[string]string{
+			"name":       "renamer's or importer's names, or YYYY-MM-DD-*",
+			"extensions": "all",
+			"timezone":   "local",
+		})
+		return
+	}
+
 	if srcDirectory == "" && !containsHelpFlag() {
 		fmt.Fprintf(os.Stderr, "Error: src argument is required\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	files, err := os.ReadDir(srcDirectory)
+	dirTemplate, err := layout.ParseDir(dirFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	zone, err := media.ParseZone(tz)
 	if err != nil {
 		log.Fatal(err)
 	}
-	// create month buckets
-	for i := 1; i <= 12; i++ {
-		m := fmt.Sprintf("%02d", i)
-		err = createDirIfNotExist(srcDirectory+m+"/", dryRun)
+	if zone == nil {
+		zone = time.Local
+	}
+	sources := processedNames
+	if fromFlag != "" {
+		sources = []string{fromFlag}
+	}
+	var names []*layout.Template
+	for _, src := range sources {
+		name, err := layout.ParseName(src)
 		if err != nil {
-			log.Fatalf("Error creating directory %s", m)
+			log.Fatal(err)
 		}
+		names = append(names, name)
+	}
+
+	files, err := os.ReadDir(srcDirectory)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	for _, f := range files {
@@ -63,32 +125,49 @@ func main() {
 			continue
 		}
 
-		// Check if file matches our expected format (YYYY-MM-DD-...)
-		if !strings.HasPrefix(filename, "20") || len(strings.Split(filename, "-")) < 2 {
+		if ext := strings.TrimPrefix(filepath.Ext(filename), "."); !cfg.Allows(ext) {
+			if dryRun {
+				fmt.Printf("[DRY-RUN] Skipping file with extension not in the configured extensions: %s\n", filename)
+			}
+			continue
+		}
+
+		// Check if file matches one of the processed formats (YYYY-MM-DD-...)
+		fields, ok := matchProcessed(names, filename, zone)
+		if !ok {
 			if dryRun {
 				fmt.Printf("[DRY-RUN] Skipping non-processed file: %s\n", filename)
 			}
 			continue
 		}
 
-		err := processFile(srcDirectory, filename, dryRun)
+		err := processFile(srcDirectory, filename, dirTemplate.Render(fields), dryRun)
 		if err != nil {
 			log.Fatalf("Error processing file %s: %s", filename, err)
 		}
 	}
 }
 
-func processFile(srcDirectory, filename string, dryRun bool) error {
-	// Filename has to be on the form of: 2018-07-19-13-18-s8fx.JPG
-	r := strings.Split(filename, "-")
-	if len(r) < 2 {
-		return fmt.Errorf("invalid filename format: %s", filename)
+// matchProcessed reads the date and name back out of a processed filename,
+// whose date and time are wall-clock time in loc.
+func matchProcessed(names []*layout.Template, filename string, loc *time.Location) (layout.Fields, bool) {
+	for _, name := range names {
+		if fields, ok := name.Match(filename, loc); ok && !fields.Time.IsZero() {
+			return fields, true
+		}
 	}
-	month := r[1]
+	return layout.Fields{}, false
+}
 
-	destDirectory := fmt.Sprintf("%s%s/", srcDirectory, month)
+func processFile(srcDirectory, filename, dir string, dryRun bool) error {
+	destDirectory := filepath.Join(srcDirectory, filepath.FromSlash(dir)) + "/"
 	destPath := filepath.Join(destDirectory, filename)
 
+	err := createDirIfNotExist(destDirectory, dryRun)
+	if err != nil {
+		return err
+	}
+
 	if dryRun {
 		fmt.Printf("[DRY-RUN] Would move:\n")
 		fmt.Printf("  From: %s\n", filepath.Join(srcDirectory, filename))
@@ -98,7 +177,7 @@ func processFile(srcDirectory, filename string, dryRun bool) error {
 	}
 
 	// Move file to month directory
-	err := os.Rename(filepath.Join(srcDirectory, filename), destPath)
+	err = os.Rename(filepath.Join(srcDirectory, filename), destPath)
 	if err != nil {
 		return errors.Wrap(err, "Error moving file")
 	}
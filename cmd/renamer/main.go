@@ -1,26 +1,49 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/cemeng/photos-organiser/internal/config"
+	"github.com/cemeng/photos-organiser/internal/copyfile"
+	"github.com/cemeng/photos-organiser/internal/layout"
+	"github.com/cemeng/photos-organiser/internal/media"
 	"github.com/pkg/errors"
-	"github.com/rwcarlsen/goexif/exif"
-	"github.com/rwcarlsen/goexif/mknote"
 )
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
-
 var (
-	processedFilePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}-[a-zA-Z0-9]{4}\.[a-zA-Z0-9]+$`)
+	// dirTemplate and nameTemplate say where files go under the destination,
+	// set by -layout and -name. Files already named by nameTemplate are skipped.
+	dirTemplate  = layout.MustParseDir("")
+	nameTemplate = layout.MustParseName(layout.RenamerName)
+
+	// daySequence is the last {seq} number handed out per day.
+	daySequence = make(map[string]int)
+
+	// zone is the time zone filenames are expressed in, set by -tz.
+	// Nil keeps each file's recorded offset and reads naive times as local.
+	zone *time.Location
+
+	// datePatterns are extra filename date patterns from -date-pattern,
+	// tried before media.DefaultDatePatterns.
+	datePatterns []media.DatePattern
+
+	// extensions limits renaming to these lower-case extensions, set by -ext
+	// or the config file. Nil renames every supported extension.
+	extensions []string
+
+	// keepOriginals leaves source files in place instead of moving them to
+	// processed/, set by -processed=keep or the config file.
+	keepOriginals bool
 )
 
 func main() {
@@ -28,41 +51,127 @@ func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Renamer - A tool to organize photos and videos by their creation date\n\n")
 		fmt.Fprintf(os.Stderr, "Usage:\n")
-		fmt.Fprintf(os.Stderr, "  go run renamer/main.go -src=<source_dir>/ [-dest=<destination_dir>/] [-dry-run]\n\n")
+		fmt.Fprintf(os.Stderr, "  go run renamer/main.go -src=<source_dir>/ [-dest=<destination_dir>/] [-tz=<zone>] [-dry-run]\n")
+		fmt.Fprintf(os.Stderr, "  go run renamer/main.go [-config=<file>] [-profile=<name>] config show\n\n")
 		fmt.Fprintf(os.Stderr, "Description:\n")
 		fmt.Fprintf(os.Stderr, "  Renamer processes photos and videos, organizing them by their creation date.\n")
 		fmt.Fprintf(os.Stderr, "  For photos (JPG, HEIC), it uses EXIF data to get the creation date.\n")
-		fmt.Fprintf(os.Stderr, "  For videos and other files (MOV, PNG, MP4, 3gp), it uses file modification time.\n")
+		fmt.Fprintf(os.Stderr, "  For videos (MOV, MP4, 3gp), it uses the creation date stored in the container.\n")
+		fmt.Fprintf(os.Stderr, "  Otherwise (PNG, or no date in the file), it looks for a date in the filename\n")
+		fmt.Fprintf(os.Stderr, "  (IMG-20240315-WA0001, PXL_20240315_142233123, Screenshot_20240315-142233, ...)\n")
+		fmt.Fprintf(os.Stderr, "  or the folder name (2024-03-15 Trip/), then uses file modification time.\n")
+		fmt.Fprintf(os.Stderr, "  EXIF offset tags are honoured; times without one are read in -tz (or local time).\n")
 		fmt.Fprintf(os.Stderr, "  Files are renamed to: YYYY-MM-DD-HH-mm-SS-xxxx.ext format\n")
-		fmt.Fprintf(os.Stderr, "  where xxxx is a random suffix to prevent naming conflicts.\n\n")
+		fmt.Fprintf(os.Stderr, "  where xxxx is a random suffix to prevent naming conflicts.\n")
+		fmt.Fprintf(os.Stderr, "  -layout and -name change this; see internal/layout for the template fields.\n\n")
 		fmt.Fprintf(os.Stderr, "Arguments:\n")
 		fmt.Fprintf(os.Stderr, "  -src    Source directory containing the files to process (required)\n")
-		fmt.Fprintf(os.Stderr, "  -dest   Destination directory for processed files (optional, defaults to source)\n")
+		fmt.Fprintf(os.Stderr, "  -dest   Destination directory for processed files (optional, defaults to the\n")
+		fmt.Fprintf(os.Stderr, "          configured library, then source)\n")
+		fmt.Fprintf(os.Stderr, "  -layout Folder template under dest, e.g. {YYYY}/{MM} (optional, defaults to none)\n")
+		fmt.Fprintf(os.Stderr, "  -name   Filename template (optional, defaults to %s)\n", layout.RenamerName)
+		fmt.Fprintf(os.Stderr, "  -tz     Zone for filenames, e.g. Australia/Sydney, UTC or +10:00 (optional, defaults to as shot)\n")
+		fmt.Fprintf(os.Stderr, "  -date-pattern Extra regexp for dates in filenames, with (?P<year>) (?P<month>) (?P<day>)\n")
+		fmt.Fprintf(os.Stderr, "          and optional (?P<hour>) (?P<minute>) (?P<second>) groups (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -ext    Comma-separated extensions to rename, e.g. jpg,heic (optional, defaults to all supported)\n")
+		fmt.Fprintf(os.Stderr, "  -processed What to do with source files once copied: move (to processed/) or keep\n")
+		fmt.Fprintf(os.Stderr, "  -config Config file (optional, defaults to $XDG_CONFIG_HOME/photos-organiser/config)\n")
+		fmt.Fprintf(os.Stderr, "  -profile Config profile to use (optional)\n")
 		fmt.Fprintf(os.Stderr, "  -dry-run Show what would be done without making any changes\n")
 		fmt.Fprintf(os.Stderr, "  -help   Show this help message\n\n")
+		fmt.Fprintf(os.Stderr, "Settings in the config file become the defaults of -dest, -layout, -name, -tz,\n")
+		fmt.Fprintf(os.Stderr, "-ext and -processed; 'renamer config show' prints the ones in effect.\n\n")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
 		fmt.Fprintf(os.Stderr, "  renamer -src=~/Photos/\n")
 		fmt.Fprintf(os.Stderr, "  renamer -src=~/Photos/ -dest=~/Organized/\n")
+		fmt.Fprintf(os.Stderr, "  renamer -src=~/Photos/ -tz=UTC\n")
+		fmt.Fprintf(os.Stderr, "  renamer -src=~/Photos/ -dest=~/Library/ -layout='{YYYY}/{MM}' -name='{YYYY}-{MM}-{DD}-{seq:3}.{ext}'\n")
 		fmt.Fprintf(os.Stderr, "  renamer -src=~/Photos/ -dry-run\n\n")
 		fmt.Fprintf(os.Stderr, "Note: Directory paths must end with a trailing slash (/)\n")
 	}
 
+	cfg, sel, err := config.FromArgs(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	var srcDirectory string
 	var destDirectory string
 	var dryRun bool
+	var tz string
+	var dirFlag, nameFlag string
+	defaultDest := cfg.Library
+	if defaultDest != "" && !strings.HasSuffix(defaultDest, "/") {
+		defaultDest += "/"
+	}
+	processed := config.ProcessedMove
+	if cfg.Processed != "" {
+		processed = cfg.Processed
+	}
+	if cfg.Name == "" {
+		cfg.Name = layout.RenamerName
+	}
+	flag.String("config", sel.Path, "config file")
+	flag.String("profile", sel.Profile, "config profile to use")
 	flag.StringVar(&srcDirectory, "src", "", "source directory containing the files to process")
-	flag.StringVar(&destDirectory, "dest", "", "destination directory for processed files")
+	flag.StringVar(&destDirectory, "dest", defaultDest, "destination directory for processed files")
+	flag.StringVar(&dirFlag, "layout", cfg.Layout, "folder template under the destination")
+	flag.StringVar(&nameFlag, "name", cfg.Name, "filename template; must end with .{ext}")
+	flag.StringVar(&tz, "tz", cfg.Timezone, "time zone for filenames (default: as shot)")
+	flag.String("ext", strings.Join(cfg.Extensions, ","), "comma-separated extensions to rename (default: all supported)")
+	flag.String("processed", processed, "what to do with source files once copied: move or keep")
+	flag.Func("date-pattern", "extra regexp for dates in filenames (repeatable)", func(expr string) error {
+		p, err := media.ParseDatePattern(expr)
+		if err != nil {
+			return err
+		}
+		datePatterns = append(datePatterns, p)
+		return nil
+	})
 	flag.BoolVar(&dryRun, "dry-run", false, "show what would be done without making actual changes")
 	flag.Parse()
 
+	err = config.Apply(&cfg, flag.CommandLine, map[string]string{
+		"dest": "library", "layout": "layout", "name": "name", "tz": "timezone",
+		"ext": "extensions", "processed": "processed",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flag.NArg() == 2 && flag.Arg(0) == "config" && flag.Arg(1) == "show" {
+		config.Show(os.Stdout, sel, cfg, map[string]string{
+			"library":    "the source directory",
+			"layout":     "none",
+			"name":       layout.RenamerName,
+			"extensions": "all supported",
+			"processed":  config.ProcessedMove,
+			"timezone":   "as shot",
+		})
+		return
+	}
+	extensions = cfg.Extensions
+	keepOriginals = cfg.Processed == config.ProcessedKeep
+
 	if srcDirectory == "" && !containsHelpFlag() {
 		fmt.Fprintf(os.Stderr, "Error: src argument is required\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	zone, err = media.ParseZone(tz)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dirTemplate, err = layout.ParseDir(dirFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	nameTemplate, err = layout.ParseName(nameFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Expand tilde to home directory in both source and destination paths
-	var err error
 	srcDirectory, err = expandTilde(srcDirectory)
 	if err != nil {
 		log.Fatal(err)
@@ -95,7 +204,7 @@ func main() {
 		}
 
 		// Check if file is already in our processed format
-		if processedFilePattern.MatchString(filename) {
+		if alreadyProcessed(filename) {
 			if dryRun {
 				fmt.Printf("[DRY-RUN] Skipping already processed file: %s\n", filename)
 			}
@@ -109,6 +218,14 @@ func main() {
 	}
 }
 
+// alreadyProcessed reports whether filename was rendered by nameTemplate.
+// A template without date fields matches almost any name, so only a dated
+// match counts.
+func alreadyProcessed(filename string) bool {
+	f, ok := nameTemplate.Match(filename, time.Local)
+	return ok && !f.Time.IsZero()
+}
+
 func processFile(srcDirectory, destDirectory, fname string, dryRun bool) error {
 	result := strings.Split(fname, ".")
 	if len(result) != 2 {
@@ -118,43 +235,76 @@ func processFile(srcDirectory, destDirectory, fname string, dryRun bool) error {
 	filename := result[0]
 	extension := result[1]
 
-	var destFilename string
+	if extensions != nil && !slices.Contains(extensions, strings.ToLower(extension)) {
+		fmt.Printf("Ignoring file with extension not in the configured extensions: %s\n", fname)
+		return nil
+	}
+
+	var fields layout.Fields
 	var err error
-	if extension == "JPG" || extension == "jpg" || extension == "HEIC" {
-		destFilename, err = filenameFromExif(srcDirectory, filename, extension)
+	if extension == "JPG" || extension == "jpg" || extension == "HEIC" || extension == "heic" {
+		fields, err = fieldsFromExif(srcDirectory, filename, extension)
 		if err != nil {
-			// Getting filename from exif fails, use file attribute as failback
-			destFilename, err = filenameFromAttribute(srcDirectory, filename, extension)
+			// Getting date from exif fails, use the name or file attribute as failback
+			fields, err = fieldsFromFallback(srcDirectory, filename, extension)
 			if err != nil {
-				return errors.Wrap(err, "Error getting filename from exif and attribute")
+				return errors.Wrap(err, "Error getting date from exif and attribute")
 			}
 		}
-	} else if extension == "MOV" || extension == "mov" || extension == "PNG" || extension == "png" || extension == "MP4" || extension == "mp4" || extension == "3gp" {
-		destFilename, err = filenameFromAttribute(srcDirectory, filename, extension)
+	} else if extension == "MOV" || extension == "mov" || extension == "MP4" || extension == "mp4" || extension == "3gp" {
+		fields, err = fieldsFromContainer(srcDirectory, filename, extension)
 		if err != nil {
-			return errors.Wrap(err, "Error getting filename from attribute")
+			// Video has no creation date in its container, use the name or file attribute as failback
+			fields, err = fieldsFromFallback(srcDirectory, filename, extension)
+			if err != nil {
+				return errors.Wrap(err, "Error getting date from container and attribute")
+			}
+		}
+	} else if extension == "PNG" || extension == "png" || extensions != nil {
+		// PNGs, and configured extensions without a reader, carry no date we read
+		fields, err = fieldsFromFallback(srcDirectory, filename, extension)
+		if err != nil {
+			return errors.Wrap(err, "Error getting date from attribute")
 		}
 	} else {
 		fmt.Printf("Ignoring file with unsupported extension: %s\n", fname)
 		return nil
 	}
 
+	destFilename, err := destinationFor(srcDirectory, destDirectory, fname, fields)
+	if err != nil {
+		return err
+	}
+
 	if dryRun {
 		fmt.Printf("[DRY-RUN] Would rename:\n")
 		fmt.Printf("  Source: %s\n", filepath.Join(srcDirectory, fname))
 		fmt.Printf("  Destination: %s\n", filepath.Join(destDirectory, destFilename))
-		fmt.Printf("  Then move source to: %s\n", filepath.Join(srcDirectory, "processed", fname))
+		if !keepOriginals {
+			fmt.Printf("  Then move source to: %s\n", filepath.Join(srcDirectory, "processed", fname))
+		}
 		fmt.Println("---")
 		return nil
 	}
 
-	// Copy file to destination preserving all attributes (-a) and preventing overwrite (-n)
-	cmd := exec.Command("cp", "-an", srcDirectory+fname, destDirectory+destFilename)
-	err = cmd.Run()
+	if dir := filepath.Dir(destFilename); dir != "." {
+		err = os.MkdirAll(filepath.Join(destDirectory, dir), os.ModePerm)
+		if err != nil {
+			return errors.Wrap(err, "error creating destination directory")
+		}
+	}
+
+	// Copy file to destination preserving its attributes, never overwriting
+	err = copyfile.Copy(srcDirectory+fname, destDirectory+destFilename)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("Error copying file from %s to %s", srcDirectory+fname, destDirectory+destFilename))
 	}
 
+	if keepOriginals {
+		fmt.Printf("%s processed\n", destDirectory+destFilename)
+		return nil
+	}
+
 	// move source file to processed directory
 	newpath := filepath.Join(srcDirectory, "processed")
 	err = os.MkdirAll(newpath, os.ModePerm)
@@ -172,43 +322,117 @@ func processFile(srcDirectory, destDirectory, fname string, dryRun bool) error {
 	return nil
 }
 
-func filenameFromAttribute(srcDirectory, filename, extension string) (string, error) {
+// destinationFor renders the path of a file under destDirectory from -layout
+// and -name. {hash} is the SHA-256 of the source file; {seq} counts files per
+// day in the order they are processed, skipping numbers already taken in the
+// destination.
+func destinationFor(srcDirectory, destDirectory, fname string, f layout.Fields) (string, error) {
+	if zone != nil {
+		f.Time = f.Time.In(zone)
+	}
+	l := layout.Layout{Dir: dirTemplate, Name: nameTemplate}
+	if l.Uses(layout.FieldHash) {
+		hash, err := fileHash(filepath.Join(srcDirectory, fname))
+		if err != nil {
+			return "", errors.Wrap(err, "Error hashing file")
+		}
+		f.Hash = hash
+	}
+	day := f.Time.Format("2006-01-02")
+	for {
+		if l.Uses(layout.FieldSeq) {
+			daySequence[day]++
+			f.Seq = daySequence[day]
+		}
+		dir, name := l.Render(f)
+		rel := filepath.Join(filepath.FromSlash(dir), name)
+		if !l.Uses(layout.FieldSeq) {
+			return rel, nil
+		}
+		if _, err := os.Stat(filepath.Join(destDirectory, rel)); os.IsNotExist(err) {
+			return rel, nil
+		}
+	}
+}
+
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fieldsFromAttribute(srcDirectory, filename, extension string) (layout.Fields, error) {
 	fullFilepath := srcDirectory + filename + "." + extension
 	_, err := os.Open(fullFilepath)
 	if err != nil {
-		return "", err
+		return layout.Fields{}, err
 	}
 	fi, err := os.Stat(fullFilepath)
 	if err != nil {
-		return "", err
+		return layout.Fields{}, err
 	}
 	modifiedTime := fi.ModTime()
-	return timeToFilename(modifiedTime, extension), nil
+	return layout.Fields{Time: modifiedTime, Name: filename, Ext: extension}, nil
 }
 
-func filenameFromExif(srcDirectory, filename, extension string) (string, error) {
-	f, err := os.Open(srcDirectory + filename + "." + extension)
-	if err != nil {
-		return "", err
+// fieldsFromFallback dates a file with no embedded date: from the filename,
+// then the folder name, then the file attribute.
+func fieldsFromFallback(srcDirectory, filename, extension string) (layout.Fields, error) {
+	if fields, err := fieldsFromName(srcDirectory, filename, extension); err == nil {
+		return fields, nil
 	}
+	return fieldsFromAttribute(srcDirectory, filename, extension)
+}
 
-	exif.RegisterParsers(mknote.All...)
+func fieldsFromName(srcDirectory, filename, extension string) (layout.Fields, error) {
+	patterns := append(append([]media.DatePattern{}, datePatterns...), media.DefaultDatePatterns...)
+	if t, _, ok := media.DateFromName(filename, patterns, naiveZone()); ok {
+		return layout.Fields{Time: t, Name: filename, Ext: extension}, nil
+	}
+	// Only the source folder's own name describes its files; the folders
+	// above it, e.g. a backup volume named by date, do not.
+	if t, ok := media.DateFromPath(filepath.Base(filepath.Clean(srcDirectory)), naiveZone()); ok {
+		return layout.Fields{Time: t, Name: filename, Ext: extension}, nil
+	}
+	return layout.Fields{}, errors.New("no date in filename or folder name")
+}
 
-	pictureData, err := exif.Decode(f)
+func fieldsFromExif(srcDirectory, filename, extension string) (layout.Fields, error) {
+	pictureData, err := media.DecodeExif(srcDirectory + filename + "." + extension)
 	if err != nil {
-		return "", err
+		return layout.Fields{}, err
 	}
 
-	pictureTakenTime, err := pictureData.DateTime()
+	pictureTakenTime, _, err := media.ExifCaptureTime(pictureData, naiveZone())
 	if err != nil {
-		return "", err
+		return layout.Fields{}, err
 	}
 
-	return timeToFilename(pictureTakenTime, extension), nil
+	camera := media.CameraOf(pictureData)
+	return layout.Fields{Time: pictureTakenTime, Name: filename, Ext: extension, Camera: camera.Model}, nil
+}
+
+func fieldsFromContainer(srcDirectory, filename, extension string) (layout.Fields, error) {
+	createdTime, err := media.VideoCreationTime(srcDirectory + filename + "." + extension)
+	if err != nil {
+		return layout.Fields{}, err
+	}
+	return layout.Fields{Time: createdTime, Name: filename, Ext: extension}, nil
 }
 
-func timeToFilename(time time.Time, extension string) string {
-	return fmt.Sprintf("%d-%02d-%02d-%02d-%02d-%02d-%s.%s", time.Year(), time.Month(), time.Day(), time.Hour(), time.Minute(), time.Second(), randomSuffix(4), extension)
+// naiveZone is the zone for times that carry no offset: -tz, or local time.
+func naiveZone() *time.Location {
+	if zone != nil {
+		return zone
+	}
+	return time.Local
 }
 
 // expandTilde replaces ~ with the user's home directory
@@ -252,11 +476,3 @@ func containsHelpFlag() bool {
 	}
 	return false
 }
-
-func randomSuffix(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
-	}
-	return string(b)
-}
@@ -7,9 +7,11 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/cemeng/photos-organiser/internal/layout"
 )
 
-func TestTimeToFilename(t *testing.T) {
+func TestDestinationFor(t *testing.T) {
 	// Create a fixed time for testing
 	testTime := time.Date(2023, time.January, 15, 14, 30, 45, 0, time.UTC)
 
@@ -35,9 +37,12 @@ func TestTimeToFilename(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := timeToFilename(tt.time, tt.extension)
+			got, err := destinationFor(t.TempDir()+"/", t.TempDir()+"/", "photo."+tt.extension, layout.Fields{Time: tt.time, Name: "photo", Ext: tt.extension})
+			if err != nil {
+				t.Fatal(err)
+			}
 			if !strings.HasPrefix(got, tt.want) {
-				t.Errorf("timeToFilename() = %v, want prefix %v", got, tt.want)
+				t.Errorf("destinationFor() = %v, want prefix %v", got, tt.want)
 			}
 			// Check that the random suffix is present and correct length
 			parts := strings.Split(got, "-")
@@ -53,7 +58,7 @@ func TestTimeToFilename(t *testing.T) {
 	}
 }
 
-func TestFilenameFromAttribute(t *testing.T) {
+func TestFieldsFromAttribute(t *testing.T) {
 	// Get the current working directory which contains gopher-stand.jpg
 	dir, err := os.Getwd()
 	if err != nil {
@@ -63,9 +68,13 @@ func TestFilenameFromAttribute(t *testing.T) {
 	// Add trailing slash to match the expected format
 	dir = dir + "/"
 
-	got, err := filenameFromAttribute(dir, "gopher-stand", "jpg")
+	fields, err := fieldsFromAttribute(dir, "gopher-stand", "jpg")
+	if err != nil {
+		t.Fatalf("fieldsFromAttribute() error = %v", err)
+	}
+	got, err := destinationFor(dir, t.TempDir()+"/", "gopher-stand.jpg", fields)
 	if err != nil {
-		t.Fatalf("filenameFromAttribute() error = %v", err)
+		t.Fatal(err)
 	}
 
 	// Get the file's actual modification time
@@ -81,7 +90,7 @@ func TestFilenameFromAttribute(t *testing.T) {
 		modTime.Hour(), modTime.Minute(), modTime.Second())
 
 	if !strings.HasPrefix(got, want) {
-		t.Errorf("filenameFromAttribute() = %v, want prefix %v", got, want)
+		t.Errorf("fieldsFromAttribute() = %v, want prefix %v", got, want)
 	}
 
 	// Check file extension
@@ -148,3 +157,195 @@ func TestProcessFile(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// rendered renders the destination name of a file dated by a fieldsFrom function.
+func rendered(f layout.Fields, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return destinationFor("", os.TempDir()+"/", f.Name+"."+f.Ext, f)
+}
+
+func TestFieldsFromExif_HEIC(t *testing.T) {
+	// iphone-sample.heic sits next to gopher-stand.jpg and carries an Exif item
+	// with DateTimeOriginal 2021:06:12 09:41:27 inside its HEIF container.
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir = dir + "/"
+
+	got, err := rendered(fieldsFromExif(dir, "iphone-sample", "heic"))
+	if err != nil {
+		t.Fatalf("fieldsFromExif() error = %v", err)
+	}
+	if want := "2021-06-12-09-41-27-"; !strings.HasPrefix(got, want) {
+		t.Errorf("fieldsFromExif() = %v, want prefix %v", got, want)
+	}
+	if !strings.HasSuffix(got, ".heic") {
+		t.Errorf("Wrong file extension in %v, want .heic", got)
+	}
+}
+
+func TestFieldsFromExif_Zone(t *testing.T) {
+	// The fixture records OffsetTimeOriginal +10:00, so -tz=UTC moves it back a day.
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir = dir + "/"
+
+	zone = time.UTC
+	defer func() { zone = nil }()
+
+	got, err := rendered(fieldsFromExif(dir, "iphone-sample", "heic"))
+	if err != nil {
+		t.Fatalf("fieldsFromExif() error = %v", err)
+	}
+	if want := "2021-06-11-23-41-27-"; !strings.HasPrefix(got, want) {
+		t.Errorf("fieldsFromExif() = %v, want prefix %v", got, want)
+	}
+}
+
+func TestFieldsFromName(t *testing.T) {
+	dir := t.TempDir() + "/"
+
+	got, err := rendered(fieldsFromName(dir, "IMG-20240315-WA0001", "jpg"))
+	if err != nil {
+		t.Fatalf("fieldsFromName() error = %v", err)
+	}
+	if want := "2024-03-15-00-00-00-"; !strings.HasPrefix(got, want) {
+		t.Errorf("fieldsFromName() = %v, want prefix %v", got, want)
+	}
+
+	// Pixel names are in UTC
+	zone = time.FixedZone("+10:00", 10*3600)
+	defer func() { zone = nil }()
+	got, err = rendered(fieldsFromName(dir, "PXL_20240315_142233123", "jpg"))
+	if err != nil {
+		t.Fatalf("fieldsFromName() error = %v", err)
+	}
+	if want := "2024-03-16-00-22-33-"; !strings.HasPrefix(got, want) {
+		t.Errorf("fieldsFromName() = %v, want prefix %v", got, want)
+	}
+
+	// falls back to a date in the folder name
+	trip := filepath.Join(t.TempDir(), "2022-12-24 Christmas") + "/"
+	got, err = rendered(fieldsFromName(trip, "IMG_2000", "jpg"))
+	if err != nil {
+		t.Fatalf("fieldsFromName() error = %v", err)
+	}
+	if want := "2022-12-24-00-00-00-"; !strings.HasPrefix(got, want) {
+		t.Errorf("fieldsFromName() = %v, want prefix %v", got, want)
+	}
+
+	if _, err := fieldsFromName(dir, "IMG_1234", "jpg"); err == nil {
+		t.Error("expected error for a name without a date")
+	}
+
+	// but not in the folders above it
+	card := filepath.Join(t.TempDir(), "Backup-2019-05-01", "card") + "/"
+	if _, err := fieldsFromName(card, "IMG_1234", "jpg"); err == nil {
+		t.Error("expected error for a date only in a parent folder")
+	}
+}
+
+func TestProcessFile_Layout(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+
+	data, err := os.ReadFile("gopher-stand.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	taken := time.Date(2023, 5, 6, 7, 8, 9, 0, time.Local)
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		path := filepath.Join(srcDir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, taken, taken); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirTemplate = layout.MustParseDir("{YYYY}/{MM}")
+	nameTemplate = layout.MustParseName("{YYYY}{MM}{DD}-{seq:3}-{name}.{ext}")
+	defer func() {
+		dirTemplate = layout.MustParseDir("")
+		nameTemplate = layout.MustParseName(layout.RenamerName)
+		daySequence = make(map[string]int)
+	}()
+
+	// an earlier run already used 001
+	if err := os.MkdirAll(filepath.Join(destDir, "2023", "05"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "2023", "05", "20230506-001-a.jpg"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := processFile(srcDir, destDir, name, false); err != nil {
+			t.Fatalf("processFile(%s) error = %v", name, err)
+		}
+	}
+	for _, want := range []string{"20230506-002-a.jpg", "20230506-003-b.jpg"} {
+		if _, err := os.Stat(filepath.Join(destDir, "2023", "05", want)); err != nil {
+			t.Errorf("expected %s: %v", want, err)
+		}
+	}
+}
+
+func TestProcessFile_ConfigSettings(t *testing.T) {
+	srcDir := t.TempDir() + "/"
+	destDir := t.TempDir() + "/"
+	for _, name := range []string{"IMG-20240315-WA0001.webp", "clip.mov"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	extensions = []string{"webp"}
+	keepOriginals = true
+	nameTemplate = layout.MustParseName("{YYYY}-{MM}-{DD}-{name}.{ext}")
+	defer func() {
+		extensions = nil
+		keepOriginals = false
+		nameTemplate = layout.MustParseName(layout.RenamerName)
+	}()
+
+	for _, name := range []string{"IMG-20240315-WA0001.webp", "clip.mov"} {
+		if err := processFile(srcDir, destDir, name, false); err != nil {
+			t.Fatalf("processFile(%s) error = %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "2024-03-15-IMG_20240315_WA0001.webp")); err != nil {
+		t.Errorf("configured extension not renamed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "IMG-20240315-WA0001.webp")); err != nil {
+		t.Errorf("original not kept: %v", err)
+	}
+	entries, _ := os.ReadDir(destDir)
+	if len(entries) != 1 {
+		t.Errorf("dest has %d entries, want only the .webp", len(entries))
+	}
+}
+
+func TestAlreadyProcessed(t *testing.T) {
+	defer func() { nameTemplate = layout.MustParseName(layout.RenamerName) }()
+
+	nameTemplate = layout.MustParseName(layout.RenamerName)
+	if !alreadyProcessed("2024-03-15-09-41-27-qQg6.jpg") {
+		t.Error("rendered name not recognised as processed")
+	}
+	if alreadyProcessed("IMG_1234.JPG") {
+		t.Error("camera name reported as processed")
+	}
+
+	// a template without date fields matches any name
+	nameTemplate = layout.MustParseName("{name}.{ext}")
+	if alreadyProcessed("IMG_1234.JPG") {
+		t.Error("dateless template: camera name reported as processed")
+	}
+}
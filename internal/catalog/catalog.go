@@ -0,0 +1,278 @@
+// Package catalog keeps an index of every file in a photo library: its
+// SHA-256, size, capture date, path and the import that brought it in. The
+// importer checks new files against it, so content already in the library is
+// not copied again under another name.
+//
+// The catalog lives in the library root as .photos-catalog, one JSON object
+// per line. Imports append to it; Rebuild indexes a library from scratch.
+package catalog
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileName is the catalog's name in the library root.
+const FileName = ".photos-catalog"
+
+// SessionRebuild is the Session of entries indexed by Rebuild rather than
+// brought in by an import.
+const SessionRebuild = "rebuild"
+
+// Entry is one file in the library.
+type Entry struct {
+	Hash    string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	TakenAt time.Time `json:"taken_at,omitzero"`
+	Path    string    `json:"path"` // relative to the library root, with forward slashes
+	// Session identifies the import that copied the file in: the timestamp
+	// of its report, e.g. "2026-05-18-14-32-01", or SessionRebuild.
+	Session string `json:"session,omitempty"`
+}
+
+// Catalog is the index of one library.
+type Catalog struct {
+	Root string
+
+	entries []Entry
+	byHash  map[string][]int
+	sizes   map[int64]bool
+}
+
+// Path returns where the catalog of the library at root lives.
+func Path(root string) string {
+	return filepath.Join(root, FileName)
+}
+
+// Open reads the catalog of the library at root. A library without one has
+// an empty catalog. Lines that do not parse, such as one cut short by a
+// crash, are skipped.
+func Open(root string) (*Catalog, error) {
+	c := newCatalog(root)
+	f, err := os.Open(Path(root))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.Hash == "" {
+			continue
+		}
+		c.index(e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", Path(root), err)
+	}
+	return c, nil
+}
+
+func newCatalog(root string) *Catalog {
+	return &Catalog{Root: root, byHash: make(map[string][]int), sizes: make(map[int64]bool)}
+}
+
+func (c *Catalog) index(e Entry) {
+	c.byHash[e.Hash] = append(c.byHash[e.Hash], len(c.entries))
+	c.sizes[e.Size] = true
+	c.entries = append(c.entries, e)
+}
+
+// Len returns the number of entries.
+func (c *Catalog) Len() int {
+	return len(c.entries)
+}
+
+// HasSize reports whether any file in the catalog has size bytes, so files
+// of other sizes need not be hashed to know they are new.
+func (c *Catalog) HasSize(size int64) bool {
+	return c.sizes[size]
+}
+
+// Lookup returns the entries with content hash whose file is still in the
+// library, first catalogued first. Entries for files deleted or moved since
+// are ignored until the catalog is rebuilt.
+func (c *Catalog) Lookup(hash string) []Entry {
+	var out []Entry
+	for _, i := range c.byHash[hash] {
+		e := c.entries[i]
+		fi, err := os.Stat(filepath.Join(c.Root, filepath.FromSlash(e.Path)))
+		if err == nil && fi.Size() == e.Size {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Add appends entries to the catalog file and the index. Their paths are
+// relative to Root.
+func (c *Catalog) Add(entries ...Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(Path(c.Root), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening catalog: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		e.Path = filepath.ToSlash(e.Path)
+		if err := writeEntry(w, e); err != nil {
+			f.Close()
+			return fmt.Errorf("writing catalog: %w", err)
+		}
+		c.index(e)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	return f.Close()
+}
+
+// Remove drops the entries for paths, relative to Root, e.g. once an import
+// is undone. The catalog file is rewritten, or deleted if nothing is left.
+func (c *Catalog) Remove(paths ...string) error {
+	drop := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		drop[filepath.ToSlash(p)] = true
+	}
+	kept := newCatalog(c.Root)
+	for _, e := range c.entries {
+		if !drop[e.Path] {
+			kept.index(e)
+		}
+	}
+	if kept.Len() == len(c.entries) {
+		return nil
+	}
+	if kept.Len() == 0 {
+		if err := os.Remove(Path(c.Root)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if err := kept.write(); err != nil {
+		return err
+	}
+	*c = *kept
+	return nil
+}
+
+// write replaces the catalog file with c's entries.
+func (c *Catalog) write() error {
+	tmp, err := os.CreateTemp(c.Root, FileName+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	for _, e := range c.entries {
+		if err = writeEntry(w, e); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), Path(c.Root))
+	}
+	if err != nil {
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	return nil
+}
+
+func writeEntry(w io.Writer, e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// Skip reports whether Rebuild leaves a library file out of the catalog:
+// hidden files (the catalog itself, import journals, interrupted copies) and
+// the importer's reports.
+func Skip(name string) bool {
+	return strings.HasPrefix(name, ".") ||
+		strings.HasPrefix(name, "import-report-") ||
+		strings.HasPrefix(name, "undo-report-")
+}
+
+// Rebuild indexes every file under root from scratch and replaces the
+// catalog with the result. dateOf gives a file's capture date (zero when
+// unknown); progress, when not nil, is called after each file with the
+// number indexed so far.
+func Rebuild(root string, dateOf func(path string) time.Time, progress func(n int)) (*Catalog, error) {
+	c := newCatalog(root)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") && d.IsDir() {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !d.Type().IsRegular() || Skip(d.Name()) {
+			return nil
+		}
+		hash, size, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		e := Entry{Hash: hash, Size: size, Path: filepath.ToSlash(rel), Session: SessionRebuild}
+		if dateOf != nil {
+			e.TakenAt = dateOf(path)
+		}
+		c.index(e)
+		if progress != nil {
+			progress(c.Len())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rebuilding catalog: %w", err)
+	}
+	if err := c.write(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// hashFile returns the SHA-256 of the file at path and its size.
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
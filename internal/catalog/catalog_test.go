@@ -0,0 +1,116 @@
+package catalog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpen_Missing(t *testing.T) {
+	c, err := Open(t.TempDir())
+	if err != nil || c.Len() != 0 {
+		t.Fatalf("Open() of a library without a catalog = %d entries, %v", c.Len(), err)
+	}
+	if c.HasSize(0) || len(c.Lookup("abc")) != 0 {
+		t.Error("empty catalog reports content")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "2024", "03", "a.jpg"), "photo")
+	taken := time.Date(2024, 3, 15, 14, 22, 33, 0, time.UTC)
+
+	c, _ := Open(root)
+	e := Entry{Hash: "h1", Size: 5, TakenAt: taken, Path: filepath.Join("2024", "03", "a.jpg"), Session: "2024-04-01-09-00-00"}
+	if err := c.Add(e, Entry{Hash: "h2", Size: 7, Path: "2024/03/gone.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	// a line cut short by a crash
+	f, _ := os.OpenFile(Path(root), os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"sha256":"h3","si`)
+	f.Close()
+
+	c, err := Open(root)
+	if err != nil || c.Len() != 2 {
+		t.Fatalf("reopened catalog has %d entries, %v; want 2", c.Len(), err)
+	}
+	if !c.HasSize(5) || !c.HasSize(7) || c.HasSize(6) {
+		t.Error("HasSize() wrong")
+	}
+	got := c.Lookup("h1")
+	if len(got) != 1 || got[0].Path != "2024/03/a.jpg" || !got[0].TakenAt.Equal(taken) || got[0].Session != e.Session {
+		t.Errorf("Lookup(h1) = %+v", got)
+	}
+	if got := c.Lookup("h2"); len(got) != 0 {
+		t.Errorf("Lookup() returned a file no longer in the library: %+v", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	root := t.TempDir()
+	c, _ := Open(root)
+	if err := c.Add(Entry{Hash: "h1", Size: 1, Path: "a.jpg"}, Entry{Hash: "h2", Size: 2, Path: "b.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Remove("a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ = Open(root); c.Len() != 1 || c.HasSize(1) {
+		t.Errorf("after Remove(a.jpg): %d entries", c.Len())
+	}
+	if err := c.Remove("b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(Path(root)); !os.IsNotExist(err) {
+		t.Errorf("empty catalog file left behind: %v", err)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "2024", "03", "a.jpg"), "photo")
+	writeFile(t, filepath.Join(root, "review", "b.png"), "screenshot")
+	writeFile(t, filepath.Join(root, "import-report-2024-04-01-09-00-00.txt"), "report")
+	writeFile(t, filepath.Join(root, ".import-journal"), "{}")
+	writeFile(t, filepath.Join(root, ".trash", "c.jpg"), "hidden")
+	writeFile(t, Path(root), `{"sha256":"stale","size":1,"path":"old.jpg"}`+"\n")
+
+	taken := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	var seen []int
+	c, err := Rebuild(root, func(path string) time.Time { return taken }, func(n int) { seen = append(seen, n) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 2 || len(seen) != 2 {
+		t.Fatalf("Rebuild() indexed %d files (progress %v), want 2", c.Len(), seen)
+	}
+	reopened, err := Open(root)
+	if err != nil || reopened.Len() != 2 {
+		t.Fatalf("reopened catalog has %d entries, %v; want 2", reopened.Len(), err)
+	}
+	if reopened.HasSize(1) {
+		t.Error("stale entry survived the rebuild")
+	}
+	sum := sha256.Sum256([]byte("photo"))
+	got := reopened.Lookup(hex.EncodeToString(sum[:]))
+	if len(got) != 1 || got[0].Path != "2024/03/a.jpg" || got[0].Size != 5 || got[0].Session != SessionRebuild || !got[0].TakenAt.Equal(taken) {
+		t.Errorf("Lookup() after Rebuild = %+v", got)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(root, FileName+".tmp-*")); len(tmps) != 0 {
+		t.Errorf("temporary files left behind: %v", tmps)
+	}
+}
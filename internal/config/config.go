@@ -0,0 +1,392 @@
+// Package config reads the settings shared by the importer, renamer,
+// organiser and deduplicator from a file in the XDG config directory,
+// $XDG_CONFIG_HOME/photos-organiser/config (~/.config/photos-organiser/config
+// when XDG_CONFIG_HOME is unset).
+//
+// The file is a list of "key = value" lines. Keys before the first section
+// header are the defaults; each [profile <name>] section overrides them when
+// that profile is selected with -profile or $PHOTOS_ORGANISER_PROFILE.
+// Lines starting with # or ; are comments.
+//
+//	library   = ~/Pictures/Library
+//	timezone  = Australia/Sydney
+//
+//	[profile travel]
+//	layout    = {YYYY}/{YYYY}-{MM}-{DD}
+//	timezone  =
+//
+// An empty value means the tool's own default. Flags always win over the file.
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Environment variables that select the file and profile when -config and
+// -profile are not given.
+const (
+	EnvConfig  = "PHOTOS_ORGANISER_CONFIG"
+	EnvProfile = "PHOTOS_ORGANISER_PROFILE"
+)
+
+// Processed-file policies: what happens to an original once it is copied.
+const (
+	ProcessedMove = "move" // move it to <src>/processed/ (the default)
+	ProcessedKeep = "keep" // leave it where it is
+)
+
+// Verify policies: how a copy is checked before its original is moved.
+const (
+	VerifyHash  = "hash"  // re-read the copy and compare its SHA-256 with the original's (the default)
+	VerifyFsync = "fsync" // trust the hash taken while copying and the fsync that followed
+)
+
+// Keys lists the settings in the order they are shown.
+var Keys = []string{"library", "layout", "name", "extensions", "processed", "verify", "timezone", "report-dir", "report-format"}
+
+// Settings are the values of one profile. Empty fields mean "use the tool's
+// default".
+type Settings struct {
+	Library    string   // root of the photo library: the importer's and renamer's dest, the deduplicator's src
+	Layout     string   // folder template, see internal/layout
+	Name       string   // filename template, see internal/layout
+	Extensions []string // lower-case extensions to process, without dots; nil means every supported one
+	Processed  string   // ProcessedMove or ProcessedKeep
+	Verify     string   // VerifyHash or VerifyFsync
+	Timezone   string   // zone for filenames and folders, as accepted by media.ParseZone
+	ReportDir  string   // folder import reports are written to
+	// ReportFormat is a comma-separated list of import report formats:
+	// text, json, csv, md.
+	ReportFormat string
+
+	// Origin says where each key's value came from: "config", "profile <name>"
+	// or "flag". Keys left at the tool default are absent.
+	Origin map[string]string
+}
+
+// Get returns the value of key as it would be written in the file.
+func (s Settings) Get(key string) string {
+	switch key {
+	case "library":
+		return s.Library
+	case "layout":
+		return s.Layout
+	case "name":
+		return s.Name
+	case "extensions":
+		return strings.Join(s.Extensions, " ")
+	case "processed":
+		return s.Processed
+	case "verify":
+		return s.Verify
+	case "timezone":
+		return s.Timezone
+	case "report-dir":
+		return s.ReportDir
+	case "report-format":
+		return s.ReportFormat
+	}
+	return ""
+}
+
+// Set parses value into key, recording origin as where it came from.
+func (s *Settings) Set(key, value, origin string) error {
+	value = strings.TrimSpace(value)
+	switch key {
+	case "library":
+		s.Library = expandHome(value)
+	case "layout":
+		s.Layout = value
+	case "name":
+		s.Name = value
+	case "extensions":
+		s.Extensions = ParseExtensions(value)
+	case "processed":
+		if value != "" && value != ProcessedMove && value != ProcessedKeep {
+			return fmt.Errorf("processed = %q: want %q or %q", value, ProcessedMove, ProcessedKeep)
+		}
+		s.Processed = value
+	case "verify":
+		if value != "" && value != VerifyHash && value != VerifyFsync {
+			return fmt.Errorf("verify = %q: want %q or %q", value, VerifyHash, VerifyFsync)
+		}
+		s.Verify = value
+	case "timezone":
+		s.Timezone = value
+	case "report-dir":
+		s.ReportDir = expandHome(value)
+	case "report-format":
+		s.ReportFormat = value
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if s.Origin == nil {
+		s.Origin = make(map[string]string)
+	}
+	if value == "" {
+		delete(s.Origin, key)
+	} else {
+		s.Origin[key] = origin
+	}
+	return nil
+}
+
+// Allows reports whether files with extension ext (any case, no dot) should be
+// processed: always when no extensions are configured.
+func (s Settings) Allows(ext string) bool {
+	if s.Extensions == nil {
+		return true
+	}
+	ext = strings.ToLower(ext)
+	for _, e := range s.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// expandHome replaces a leading ~ with the user's home directory, keeping any
+// trailing slash.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	expanded := filepath.Join(home, path[1:])
+	if strings.HasSuffix(path, "/") && path != "~/" {
+		expanded += "/"
+	}
+	return expanded
+}
+
+// ParseExtensions splits a comma- or space-separated extension list,
+// lower-casing and dropping leading dots. An empty list returns nil.
+func ParseExtensions(s string) []string {
+	var out []string
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		out = append(out, strings.ToLower(strings.TrimPrefix(f, ".")))
+	}
+	return out
+}
+
+// File is a parsed config file.
+type File struct {
+	Path     string
+	defaults map[string]string
+	profiles map[string]map[string]string
+}
+
+// DefaultPath returns where the config file lives: $XDG_CONFIG_HOME or
+// ~/.config, then photos-organiser/config.
+func DefaultPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("locating config dir: %w", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "photos-organiser", "config"), nil
+}
+
+// Load reads the config file at path. A missing file is not an error: it
+// loads as an empty file, so every tool works without one.
+func Load(path string) (*File, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &File{Path: path}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	file.Path = path
+	return file, nil
+}
+
+// Parse reads a config file.
+func Parse(r io.Reader) (*File, error) {
+	file := &File{defaults: make(map[string]string), profiles: make(map[string]map[string]string)}
+	section := file.defaults
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section header", n)
+			}
+			fields := strings.Fields(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+			if len(fields) != 2 || fields[0] != "profile" {
+				return nil, fmt.Errorf("line %d: want [profile <name>], got %s", n, line)
+			}
+			if _, ok := file.profiles[fields[1]]; ok {
+				return nil, fmt.Errorf("line %d: profile %q defined twice", n, fields[1])
+			}
+			section = make(map[string]string)
+			file.profiles[fields[1]] = section
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key = value, got %q", n, line)
+		}
+		key = strings.TrimSpace(key)
+		if err := new(Settings).Set(key, value, ""); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		section[key] = strings.TrimSpace(value)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Profiles returns the names of the profiles in the file, sorted.
+func (f *File) Profiles() []string {
+	var names []string
+	for name := range f.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Settings returns the defaults merged with profile. An empty profile returns
+// the defaults alone; a profile the file does not define is an error.
+func (f *File) Settings(profile string) (Settings, error) {
+	var s Settings
+	for _, key := range Keys {
+		if v, ok := f.defaults[key]; ok {
+			s.Set(key, v, "config") //nolint:errcheck // validated by Parse
+		}
+	}
+	if profile == "" {
+		return s, nil
+	}
+	values, ok := f.profiles[profile]
+	if !ok {
+		return Settings{}, fmt.Errorf("no profile %q in %s", profile, f.Path)
+	}
+	for _, key := range Keys {
+		if v, ok := values[key]; ok {
+			s.Set(key, v, "profile "+profile) //nolint:errcheck // validated by Parse
+		}
+	}
+	return s, nil
+}
+
+// Selection is the config file and profile a command runs with.
+type Selection struct {
+	Path    string
+	Profile string
+}
+
+// Select finds -config and -profile in args, before the command's flags are
+// parsed, so their settings can become flag defaults. Unset, they fall back to
+// $PHOTOS_ORGANISER_CONFIG and $PHOTOS_ORGANISER_PROFILE, then DefaultPath.
+func Select(args []string) (Selection, error) {
+	sel := Selection{Path: os.Getenv(EnvConfig), Profile: os.Getenv(EnvProfile)}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		name, value, hasValue := strings.Cut(name, "=")
+		if name != "config" && name != "profile" {
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return sel, fmt.Errorf("flag -%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "config" {
+			sel.Path = value
+		} else {
+			sel.Profile = value
+		}
+	}
+	if sel.Path == "" {
+		path, err := DefaultPath()
+		if err != nil {
+			return sel, err
+		}
+		sel.Path = path
+	}
+	return sel, nil
+}
+
+// FromArgs loads the settings selected by args (see Select).
+func FromArgs(args []string) (Settings, Selection, error) {
+	sel, err := Select(args)
+	if err != nil {
+		return Settings{}, sel, err
+	}
+	file, err := Load(sel.Path)
+	if err != nil {
+		return Settings{}, sel, err
+	}
+	s, err := file.Settings(sel.Profile)
+	return s, sel, err
+}
+
+// Apply overrides s with the flags given on the command line. flags maps a
+// flag name to the setting it overrides.
+func Apply(s *Settings, fs *flag.FlagSet, flags map[string]string) error {
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := flags[f.Name]; ok && err == nil {
+			err = s.Set(key, f.Value.String(), "flag")
+		}
+	})
+	return err
+}
+
+// Show writes the effective settings, one "key = value" line each, with where
+// the value came from. Keys the tool does not use are left out; values left at
+// the tool default are shown as that default.
+func Show(w io.Writer, sel Selection, s Settings, defaults map[string]string) {
+	fmt.Fprintf(w, "# config:  %s\n", sel.Path)
+	profile := sel.Profile
+	if profile == "" {
+		profile = "(none)"
+	}
+	fmt.Fprintf(w, "# profile: %s\n", profile)
+	for _, key := range Keys {
+		def, used := defaults[key]
+		if !used {
+			continue
+		}
+		value, origin := s.Get(key), s.Origin[key]
+		if origin == "" {
+			value, origin = def, "default"
+		}
+		fmt.Fprintf(w, "%-10s = %-40s # %s\n", key, value, origin)
+	}
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sample = `# shared settings
+library   = ~/Pictures/Library
+layout    = {YYYY}/{MM}
+extensions = jpg, .HEIC mov
+timezone  = Australia/Sydney
+
+[profile travel]
+layout    = {YYYY}/{YYYY}-{MM}-{DD}
+timezone  =
+processed = keep
+verify    = fsync
+
+; another
+[profile phone]
+report-dir = /tmp/reports
+`
+
+func TestSettings(t *testing.T) {
+	file, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got := file.Profiles(); !reflect.DeepEqual(got, []string{"phone", "travel"}) {
+		t.Errorf("Profiles() = %v", got)
+	}
+
+	def, err := file.Settings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, _ := os.UserHomeDir()
+	if def.Library != filepath.Join(home, "Pictures/Library") || def.Layout != "{YYYY}/{MM}" || def.Timezone != "Australia/Sydney" || def.Processed != "" {
+		t.Errorf("defaults = %+v", def)
+	}
+	if !reflect.DeepEqual(def.Extensions, []string{"jpg", "heic", "mov"}) {
+		t.Errorf("Extensions = %q", def.Extensions)
+	}
+
+	travel, err := file.Settings("travel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if travel.Library != def.Library || travel.Layout != "{YYYY}/{YYYY}-{MM}-{DD}" || travel.Timezone != "" || travel.Processed != ProcessedKeep || travel.Verify != VerifyFsync {
+		t.Errorf("travel = %+v", travel)
+	}
+	want := map[string]string{"library": "config", "layout": "profile travel", "extensions": "config", "processed": "profile travel", "verify": "profile travel"}
+	if !reflect.DeepEqual(travel.Origin, want) {
+		t.Errorf("travel.Origin = %v, want %v", travel.Origin, want)
+	}
+
+	if _, err := file.Settings("work"); err == nil {
+		t.Error("Settings(work) succeeded, want error for undefined profile")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		"colour = blue",
+		"processed = delete",
+		"verify = never",
+		"library",
+		"[travel]",
+		"[profile a]\n[profile a]",
+		"[profile a",
+	} {
+		if _, err := Parse(strings.NewReader(src)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	var all Settings
+	if !all.Allows("PNG") {
+		t.Error("no extensions configured should allow everything")
+	}
+	s := Settings{Extensions: ParseExtensions("jpg heic")}
+	if !s.Allows("JPG") || s.Allows("png") {
+		t.Errorf("Allows() wrong for %q", s.Extensions)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	t.Setenv(EnvConfig, "")
+	t.Setenv(EnvProfile, "phone")
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+
+	sel, err := Select([]string{"-r", "/src"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.Path != "/xdg/photos-organiser/config" || sel.Profile != "phone" {
+		t.Errorf("Select() = %+v", sel)
+	}
+
+	sel, err = Select([]string{"-config=/etc/po", "--profile", "travel", "/src"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.Path != "/etc/po" || sel.Profile != "travel" {
+		t.Errorf("Select() = %+v", sel)
+	}
+
+	if _, err := Select([]string{"-profile"}); err == nil {
+		t.Error("Select(-profile) succeeded, want missing value error")
+	}
+}
+
+func TestFromArgs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte(sample), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvProfile, "")
+
+	s, sel, err := FromArgs([]string{"-config", path, "-profile", "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.Profile != "phone" || s.ReportDir != "/tmp/reports" || s.Library == "" {
+		t.Errorf("FromArgs() = %+v, %+v", s, sel)
+	}
+
+	// A missing file is an empty config.
+	s, _, err = FromArgs([]string{"-config", filepath.Join(dir, "nope")})
+	if err != nil || s.Library != "" {
+		t.Errorf("FromArgs(missing) = %+v, %v", s, err)
+	}
+}
+
+func TestApplyAndShow(t *testing.T) {
+	s := Settings{}
+	s.Set("library", "/lib", "config")
+	s.Set("timezone", "UTC", "profile travel")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	dest := fs.String("dest", s.Library, "")
+	fs.String("tz", s.Timezone, "")
+	fs.String("ext", "", "")
+	if err := fs.Parse([]string{"-dest", "/elsewhere", "-ext", "jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(&s, fs, map[string]string{"dest": "library", "tz": "timezone", "ext": "extensions"}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Library != *dest || s.Origin["library"] != "flag" || s.Origin["timezone"] != "profile travel" {
+		t.Errorf("Apply() = %+v", s)
+	}
+
+	var buf bytes.Buffer
+	Show(&buf, Selection{Path: "/xdg/config", Profile: "travel"}, s, map[string]string{
+		"library": "parent of source", "layout": "{YYYY}/{MM}", "extensions": "all", "timezone": "as shot",
+	})
+	out := buf.String()
+	for _, want := range []string{
+		"# config:  /xdg/config",
+		"# profile: travel",
+		"library    = /elsewhere",
+		"# flag",
+		"layout     = {YYYY}/{MM}",
+		"# default",
+		"extensions = jpg",
+		"timezone   = UTC",
+		"# profile travel",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Show() missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "report-dir") {
+		t.Errorf("Show() printed a key the tool does not use:\n%s", out)
+	}
+}
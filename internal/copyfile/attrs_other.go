@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+
+package copyfile
+
+import (
+	"io/fs"
+	"time"
+)
+
+// accessTime falls back to the modification time where the access time is
+// not portable.
+func accessTime(src string, fi fs.FileInfo) time.Time {
+	return fi.ModTime()
+}
+
+// copyXattrs does nothing: extended attributes are only copied on Linux and
+// macOS.
+func copyXattrs(src, dst string) error {
+	return nil
+}
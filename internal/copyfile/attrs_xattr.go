@@ -0,0 +1,82 @@
+//go:build linux || darwin
+
+package copyfile
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// accessTime returns the source's last access time.
+func accessTime(src string, fi fs.FileInfo) time.Time {
+	var st unix.Stat_t
+	if err := unix.Stat(src, &st); err != nil {
+		return fi.ModTime()
+	}
+	return time.Unix(st.Atim.Unix())
+}
+
+// copyXattrs copies the extended attributes of src to dst: Finder tags and
+// quarantine flags on macOS, user.* attributes on Linux. A destination
+// filesystem without extended attributes (exFAT, most NAS shares) is not an
+// error, and neither are attributes only root may set.
+func copyXattrs(src, dst string) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		if unsupported(err) {
+			return nil
+		}
+		return err
+	}
+	for _, name := range names {
+		value, err := getXattr(src, name)
+		if err != nil {
+			return err
+		}
+		if err := unix.Setxattr(dst, name, value, 0); err != nil {
+			if unsupported(err) || errors.Is(err, unix.EPERM) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func unsupported(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP)
+}
+
+func listXattrs(path string) ([]string, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Listxattr(path, buf); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Getxattr(path, name, buf); err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
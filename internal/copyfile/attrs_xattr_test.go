@@ -0,0 +1,28 @@
+//go:build linux || darwin
+
+package copyfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopy_Xattrs(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg")
+	if err := os.WriteFile(src, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(src, "user.rating", []byte("5"), 0); err != nil {
+		t.Skipf("filesystem has no user xattrs: %v", err)
+	}
+	if err := Copy(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := getXattr(dst, "user.rating"); err != nil || string(got) != "5" {
+		t.Errorf("user.rating = %q, %v", got, err)
+	}
+}
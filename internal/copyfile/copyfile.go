@@ -0,0 +1,176 @@
+// Package copyfile copies a file into place safely: the data goes to a
+// temporary file next to the destination, is synced to disk, and is then
+// moved to the destination name without ever replacing a file already there.
+// The copy keeps the original's permissions, access and modification times
+// and, on Linux and macOS, its extended attributes.
+//
+// It replaces shelling out to "cp -an", whose -n exit status differs between
+// GNU and macOS and which could not tell an existing file from a failed copy.
+package copyfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrExists is the Err of the *Error returned when the destination already
+// exists. It is fs.ErrExist, so errors.Is(err, fs.ErrExist) works too.
+var ErrExists = fs.ErrExist
+
+// ErrNotRegular is the Err of the *Error returned when the source is not a
+// regular file.
+var ErrNotRegular = errors.New("not a regular file")
+
+// Steps of a copy, reported in Error.Op.
+const (
+	OpOpen   = "open"   // opening or statting the source
+	OpCreate = "create" // creating the temporary file
+	OpWrite  = "write"  // copying the data
+	OpSync   = "sync"   // flushing the copy to disk
+	OpAttrs  = "attrs"  // setting permissions, times or extended attributes
+	OpPlace  = "place"  // moving the copy to its final name
+)
+
+// Error is returned by Copy, saying which step failed on which path.
+type Error struct {
+	Op   string // one of the Op constants
+	Path string // the source for OpOpen, else the destination
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("copy: %s %s: %v", e.Op, e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// tempPrefix returns the prefix of the temporary files Copy makes for dst.
+// They are hidden, so a crash part way leaves nothing that looks like a photo.
+func tempPrefix(dst string) string {
+	return "." + filepath.Base(dst) + ".tmp-"
+}
+
+// Copy copies the regular file src to dst, which must not exist. Once it
+// returns nil, dst is complete and on disk; until then, dst does not exist.
+func Copy(src, dst string) error {
+	return CopyTo(src, dst, nil)
+}
+
+// CopyTo is Copy that also writes every byte read from src to w, e.g. a
+// hash.Hash, so the original is read only once.
+func CopyTo(src, dst string, w io.Writer) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return &Error{OpOpen, src, err}
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return &Error{OpOpen, src, err}
+	}
+	if !fi.Mode().IsRegular() {
+		return &Error{OpOpen, src, ErrNotRegular}
+	}
+	atime := accessTime(src, fi) // before reading updates it
+	if _, err := os.Lstat(dst); err == nil {
+		return &Error{OpPlace, dst, ErrExists}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), tempPrefix(dst)+"*")
+	if err != nil {
+		return &Error{OpCreate, dst, err}
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	var r io.Reader = in
+	if w != nil {
+		r = io.TeeReader(in, w)
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		return &Error{OpWrite, dst, err}
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return &Error{OpAttrs, dst, err}
+	}
+	if err := copyXattrs(src, tmp.Name()); err != nil {
+		return &Error{OpAttrs, dst, err}
+	}
+	if err := tmp.Sync(); err != nil {
+		return &Error{OpSync, dst, err}
+	}
+	if err := tmp.Close(); err != nil {
+		return &Error{OpWrite, dst, err}
+	}
+	if err := os.Chtimes(tmp.Name(), atime, fi.ModTime()); err != nil {
+		return &Error{OpAttrs, dst, err}
+	}
+	if err := place(tmp.Name(), dst); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	return nil
+}
+
+// place gives tmp the name dst unless dst exists. A hard link fails if dst
+// exists, so no other writer can slip in between the check and the move.
+// Filesystems without hard links (exFAT, some network shares) fall back to
+// checking, then renaming.
+func place(tmp, dst string) error {
+	err := os.Link(tmp, dst)
+	if err == nil {
+		os.Remove(tmp)
+		return nil
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return &Error{OpPlace, dst, ErrExists}
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return &Error{OpPlace, dst, ErrExists}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return &Error{OpPlace, dst, err}
+	}
+	return nil
+}
+
+// syncDir flushes a directory entry to disk. Not every filesystem supports
+// it, and the file itself is already synced, so failures are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// RemoveTemps deletes temporary files left next to dst by a Copy that was
+// interrupted, e.g. by a crash.
+func RemoveTemps(dst string) error {
+	entries, err := os.ReadDir(filepath.Dir(dst))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	prefix := tempPrefix(dst)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) {
+			if err := os.Remove(filepath.Join(filepath.Dir(dst), e.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
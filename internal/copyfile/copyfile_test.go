@@ -0,0 +1,135 @@
+package copyfile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCopy(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "IMG_1234.JPG")
+	if err := os.WriteFile(src, []byte("photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	atime := time.Date(2024, 3, 16, 8, 0, 0, 0, time.UTC)
+	mtime := time.Date(2024, 3, 15, 14, 22, 33, 0, time.UTC)
+	if err := os.Chtimes(src, atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "2024-03-15-14-22-IMG_1234.JPG")
+	if err := Copy(src, dst); err != nil {
+		t.Fatalf("Copy() error: %v", err)
+	}
+	fi, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// checked before reading dst, which moves its atime
+	if got := accessTime(dst, fi); runtime.GOOS == "linux" && !got.Equal(atime) {
+		t.Errorf("atime = %v, want %v", got, atime)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil || string(got) != "photo" {
+		t.Fatalf("dst = %q, %v", got, err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", fi.Mode().Perm())
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("mtime = %v, want %v", fi.ModTime(), mtime)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("dir has %d entries, want src and dst only", len(entries))
+	}
+}
+
+func TestCopy_Exists(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg")
+	if err := os.WriteFile(src, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Copy(src, dst)
+	var cerr *Error
+	if !errors.As(err, &cerr) || cerr.Op != OpPlace || !errors.Is(err, ErrExists) || !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Copy() error = %v, want *Error{place, ErrExists}", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "old" {
+		t.Errorf("dst overwritten with %q", got)
+	}
+	if err := place(src, dst); !errors.Is(err, ErrExists) {
+		t.Errorf("place() over an existing file = %v, want ErrExists", err)
+	}
+}
+
+func TestCopy_Errors(t *testing.T) {
+	dir := t.TempDir()
+	var cerr *Error
+
+	err := Copy(filepath.Join(dir, "missing.jpg"), filepath.Join(dir, "out.jpg"))
+	if !errors.As(err, &cerr) || cerr.Op != OpOpen || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing source: %v", err)
+	}
+	err = Copy(dir, filepath.Join(dir, "out.jpg"))
+	if !errors.As(err, &cerr) || !errors.Is(err, ErrNotRegular) {
+		t.Errorf("directory source: %v", err)
+	}
+
+	src := filepath.Join(dir, "a.jpg")
+	if err := os.WriteFile(src, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = Copy(src, filepath.Join(dir, "no", "such", "dir", "a.jpg"))
+	if !errors.As(err, &cerr) || cerr.Op != OpCreate {
+		t.Errorf("missing destination folder: %v", err)
+	}
+}
+
+func TestRemoveTemps(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "a.jpg")
+	for _, name := range []string{".a.jpg.tmp-1", ".a.jpg.tmp-2", ".b.jpg.tmp-1", "a.jpg.tmp-1"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RemoveTemps(dst); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("%d entries left, want the other file's temp and the non-hidden file", len(entries))
+	}
+	if err := RemoveTemps(filepath.Join(dir, "missing", "a.jpg")); err != nil {
+		t.Errorf("RemoveTemps() in a missing folder: %v", err)
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "clip.mov")
+	data := bytes.Repeat([]byte("frame"), 100000)
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.New()
+	if err := CopyTo(src, filepath.Join(dir, "copy.mov"), h); err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256.Sum256(data); !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Errorf("hash of the streamed copy = %x, want %x", h.Sum(nil), want)
+	}
+}
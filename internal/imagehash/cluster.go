@@ -0,0 +1,97 @@
+package imagehash
+
+import "sort"
+
+// Cluster groups hashes that are within maxDistance of each other, directly
+// or through a chain of neighbours. It returns the indexes of each group of
+// two or more, each sorted, and the groups ordered by their first index.
+func Cluster(hashes []Hash, maxDistance int) [][]int {
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	var tree bkTree
+	for i, h := range hashes {
+		tree.near(hashes, h, maxDistance, func(j int) {
+			if a, b := find(i), find(j); a != b {
+				parent[max(a, b)] = min(a, b)
+			}
+		})
+		tree.add(hashes, i)
+	}
+
+	byRoot := make(map[int][]int)
+	for i := range hashes {
+		byRoot[find(i)] = append(byRoot[find(i)], i)
+	}
+	var out [][]int
+	for _, group := range byRoot {
+		if len(group) > 1 {
+			out = append(out, group)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a][0] < out[b][0] })
+	return out
+}
+
+// bkTree is a Burkhard-Keller tree over Hamming distance, so finding the
+// neighbours of a hash visits a small part of the collection rather than
+// all of it.
+type bkTree struct {
+	root *bkNode
+}
+
+type bkNode struct {
+	index    int // into the hashes slice
+	children map[int]*bkNode
+}
+
+func (t *bkTree) add(hashes []Hash, i int) {
+	if t.root == nil {
+		t.root = &bkNode{index: i}
+		return
+	}
+	n := t.root
+	for {
+		d := hashes[n.index].Distance(hashes[i])
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*bkNode)
+			}
+			n.children[d] = &bkNode{index: i}
+			return
+		}
+		n = child
+	}
+}
+
+// near calls fn with the index of every hash in the tree within maxDistance
+// of h.
+func (t *bkTree) near(hashes []Hash, h Hash, maxDistance int, fn func(int)) {
+	if t.root == nil {
+		return
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := hashes[n.index].Distance(h)
+		if d <= maxDistance {
+			fn(n.index)
+		}
+		for cd, child := range n.children {
+			if cd >= d-maxDistance && cd <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
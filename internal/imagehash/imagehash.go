@@ -0,0 +1,291 @@
+// Package imagehash computes perceptual hashes of photos: 64-bit
+// fingerprints of what an image looks like rather than of its bytes, so a
+// camera original and a resized or recompressed copy of it hash a few bits
+// apart. JPEG and PNG files are decoded with the standard image packages and
+// turned upright by their EXIF orientation first.
+package imagehash
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cemeng/photos-organiser/internal/media"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Algorithm selects the perceptual hash.
+type Algorithm string
+
+const (
+	// DHash compares the brightness of neighbouring cells of a 9x8 grid.
+	// It is quick and robust to resizing and recompression.
+	DHash Algorithm = "dhash"
+	// PHash keeps the signs of the lowest frequencies of a 32x32 DCT. It is
+	// slower, and more robust to brightness and contrast changes.
+	PHash Algorithm = "phash"
+)
+
+// ParseAlgorithm checks a -similar style name.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch a := Algorithm(strings.ToLower(name)); a {
+	case DHash, PHash:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown perceptual hash %q: want %s or %s", name, DHash, PHash)
+}
+
+// Hash is a 64-bit perceptual hash.
+type Hash uint64
+
+// Distance is the number of bits in which h and o differ, 0 to 64. Copies
+// of one photo are usually within 10.
+func (h Hash) Distance(o Hash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Image is a decoded image's perceptual hash and its upright dimensions.
+type Image struct {
+	Hash          Hash
+	Width, Height int
+}
+
+// Supported reports whether File can hash a file with extension ext (with
+// or without the dot, any case).
+func Supported(ext string) bool {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "jpg", "jpeg", "png":
+		return true
+	}
+	return false
+}
+
+// File decodes the JPEG or PNG at path, turns it upright by its EXIF
+// orientation, and hashes it with alg.
+func File(path string, alg Algorithm) (Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Image{}, err
+	}
+	var img image.Image
+	orientation := 1
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		img, err = png.Decode(bytes.NewReader(data))
+	default:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if x, xerr := exif.Decode(bytes.NewReader(data)); xerr == nil {
+			orientation = media.Orientation(x)
+		}
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	return Compute(img, orientation, alg)
+}
+
+// Compute hashes img as displayed with the given EXIF orientation. An image
+// with no pixels, which a corrupt JPEG header can decode to, is an error.
+func Compute(img image.Image, orientation int, alg Algorithm) (Image, error) {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return Image{}, fmt.Errorf("image is %dx%d pixels", b.Dx(), b.Dy())
+	}
+	out := Image{Width: b.Dx(), Height: b.Dy()}
+	if orientation >= 5 {
+		out.Width, out.Height = out.Height, out.Width
+	}
+	g := orient(luma(img), orientation)
+	if alg == PHash {
+		out.Hash = pHash(g)
+	} else {
+		out.Hash = dHash(g)
+	}
+	return out, nil
+}
+
+// gridSize is the side of the grid images are first reduced to. It is
+// square, so turning it upright never changes its shape, and a multiple of
+// the 32x32 the pHash DCT needs.
+const gridSize = 64
+
+type grid [gridSize][gridSize]float64
+
+// luma box-averages img's brightness into a gridSize square, stretching it
+// to fit. JPEGs' Y plane is read directly. Images smaller than the grid
+// fill it with their nearest pixel.
+func luma(img image.Image) *grid {
+	var sum grid
+	var count [gridSize][gridSize]float64
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	add := func(x, y int, v float64) {
+		gx, gy := x*gridSize/w, y*gridSize/h
+		sum[gy][gx] += v
+		count[gy][gx]++
+	}
+	switch m := img.(type) {
+	case *image.YCbCr:
+		for y := 0; y < h; y++ {
+			row := m.Y[m.YOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				add(x, y, float64(row[x]))
+			}
+		}
+	case *image.Gray:
+		for y := 0; y < h; y++ {
+			row := m.Pix[m.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				add(x, y, float64(row[x]))
+			}
+		}
+	default:
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				add(x, y, (0.299*float64(r)+0.587*float64(g)+0.114*float64(bl))/257)
+			}
+		}
+	}
+	for y := range sum {
+		for x := range sum[y] {
+			if count[y][x] > 0 {
+				sum[y][x] /= count[y][x]
+			}
+		}
+	}
+	if w < gridSize || h < gridSize {
+		for y := range sum {
+			for x := range sum[y] {
+				if count[y][x] == 0 {
+					px, py := x*w/gridSize, y*h/gridSize
+					sum[y][x] = sum[py*gridSize/h][px*gridSize/w]
+				}
+			}
+		}
+	}
+	return &sum
+}
+
+// orient flips and rotates g the way EXIF orientation o says to display it.
+func orient(g *grid, o int) *grid {
+	if o <= 1 || o > 8 {
+		return g
+	}
+	const n = gridSize - 1
+	var out grid
+	for y := range out {
+		for x := range out[y] {
+			sx, sy := x, y
+			switch o {
+			case 2: // mirrored
+				sx = n - x
+			case 3: // upside down
+				sx, sy = n-x, n-y
+			case 4: // mirrored upside down
+				sy = n - y
+			case 5: // mirrored, rotated 90° anticlockwise
+				sx, sy = y, x
+			case 6: // rotated 90° clockwise to display
+				sx, sy = y, n-x
+			case 7: // mirrored, rotated 90° clockwise
+				sx, sy = n-y, n-x
+			case 8: // rotated 90° anticlockwise to display
+				sx, sy = n-y, x
+			}
+			out[y][x] = g[sy][sx]
+		}
+	}
+	return &out
+}
+
+// shrink averages g into a w x h grid.
+func shrink(g *grid, w, h int) [][]float64 {
+	out := make([][]float64, h)
+	for y := range out {
+		out[y] = make([]float64, w)
+		y0, y1 := y*gridSize/h, (y+1)*gridSize/h
+		for x := range out[y] {
+			x0, x1 := x*gridSize/w, (x+1)*gridSize/w
+			var sum float64
+			for gy := y0; gy < y1; gy++ {
+				for gx := x0; gx < x1; gx++ {
+					sum += g[gy][gx]
+				}
+			}
+			out[y][x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return out
+}
+
+// dHash sets one bit per cell of a 9x8 grid brighter than its right-hand
+// neighbour.
+func dHash(g *grid) Hash {
+	cells := shrink(g, 9, 8)
+	var h Hash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// pHash takes the DCT of a 32x32 grid and sets one bit per coefficient of
+// its lowest 8x8 frequencies above their median, leaving out the DC term.
+func pHash(g *grid) Hash {
+	const n = 32
+	cells := shrink(g, n, n)
+	var cos [8][n]float64
+	for u := range cos {
+		for x := range cos[u] {
+			cos[u][x] = math.Cos(float64((2*x+1)*u) * math.Pi / (2 * n))
+		}
+	}
+	// rows, then columns, for the 8 lowest frequencies only
+	var rows [n][8]float64
+	for y := 0; y < n; y++ {
+		for u := 0; u < 8; u++ {
+			for x := 0; x < n; x++ {
+				rows[y][u] += cells[y][x] * cos[u][x]
+			}
+		}
+	}
+	coeffs := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				sum += rows[y][u] * cos[v][y]
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+	sorted := slices.Clone(coeffs[1:])
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	var h Hash
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
@@ -0,0 +1,225 @@
+package imagehash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cemeng/photos-organiser/internal/mediatest"
+)
+
+// scene draws a w x h picture of soft blobs, different for each seed, that
+// survives resizing and JPEG compression the way a photo does.
+func scene(w, h int, seed float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			u, v := float64(x)/float64(w), float64(y)/float64(h)
+			l := 0.5 + 0.2*math.Sin(6*u+seed) + 0.2*math.Cos(5*v*seed+u*3) + 0.1*math.Sin(17*u*v+9*seed*v)
+			c := uint8(max(0, min(255, l*255)))
+			img.Set(x, y, color.RGBA{c, c / 2, 255 - c, 255})
+		}
+	}
+	return img
+}
+
+// rotate turns img 90° clockwise.
+func rotate(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			out.Set(b.Dy()-1-y, x, img.At(x, y))
+		}
+	}
+	return out
+}
+
+func writeJPEG(t *testing.T, path string, img image.Image, quality int, tiff []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if tiff != nil {
+		data = mediatest.WithExif(data, tiff)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFile_CopiesHashClose(t *testing.T) {
+	dir := t.TempDir()
+	orig := scene(640, 480, 1)
+	writeJPEG(t, filepath.Join(dir, "orig.jpg"), orig, 95, nil)
+	writeJPEG(t, filepath.Join(dir, "small.jpg"), scene(160, 120, 1), 60, nil)
+	writePNG(t, filepath.Join(dir, "orig.png"), orig)
+	writeJPEG(t, filepath.Join(dir, "other.jpg"), scene(640, 480, 4), 95, nil)
+
+	for _, alg := range []Algorithm{DHash, PHash} {
+		hash := func(name string) Image {
+			img, err := File(filepath.Join(dir, name), alg)
+			if err != nil {
+				t.Fatalf("File(%s) error: %v", name, err)
+			}
+			return img
+		}
+		o := hash("orig.jpg")
+		if o.Width != 640 || o.Height != 480 {
+			t.Errorf("%s: orig.jpg is %dx%d, want 640x480", alg, o.Width, o.Height)
+		}
+		for _, name := range []string{"small.jpg", "orig.png"} {
+			if d := o.Hash.Distance(hash(name).Hash); d > 6 {
+				t.Errorf("%s: %s is %d bits from the original, want a copy within 6", alg, name, d)
+			}
+		}
+		if d := o.Hash.Distance(hash("other.jpg").Hash); d < 16 {
+			t.Errorf("%s: a different picture is only %d bits away", alg, d)
+		}
+	}
+}
+
+func TestFile_Orientation(t *testing.T) {
+	dir := t.TempDir()
+	upright := scene(400, 300, 2)
+	writeJPEG(t, filepath.Join(dir, "upright.jpg"), upright, 90, nil)
+	// stored on its side, as a camera held upright records it, with
+	// orientation 6 asking viewers to turn it back
+	sideways := rotate(rotate(rotate(upright)))
+	tiff := mediatest.TIFF([]mediatest.Tag{{ID: mediatest.TagOrientation, Short: 6}}, nil)
+	writeJPEG(t, filepath.Join(dir, "tagged.jpg"), sideways, 90, tiff)
+	writeJPEG(t, filepath.Join(dir, "untagged.jpg"), sideways, 90, nil)
+
+	want, err := File(filepath.Join(dir, "upright.jpg"), DHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := File(filepath.Join(dir, "tagged.jpg"), DHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Width != 400 || got.Height != 300 {
+		t.Errorf("tagged.jpg is %dx%d upright, want 400x300", got.Width, got.Height)
+	}
+	if d := want.Hash.Distance(got.Hash); d > 6 {
+		t.Errorf("tagged.jpg is %d bits from the upright copy, want within 6", d)
+	}
+	untagged, err := File(filepath.Join(dir, "untagged.jpg"), DHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := want.Hash.Distance(untagged.Hash); d < 16 {
+		t.Errorf("untagged.jpg is only %d bits from the upright copy; orientation had no effect", d)
+	}
+}
+
+func TestOrient_RoundTrips(t *testing.T) {
+	var g grid
+	for y := range g {
+		for x := range g[y] {
+			g[y][x] = float64(y*gridSize + x)
+		}
+	}
+	// turning the grid the way o would be stored, then orienting it, gives
+	// it back: 6 and 8 undo each other, the rest undo themselves
+	inverse := map[int]int{2: 2, 3: 3, 4: 4, 5: 5, 6: 8, 7: 7, 8: 6}
+	for o, inv := range inverse {
+		if got := orient(orient(&g, inv), o); *got != g {
+			t.Errorf("orient(orient(g, %d), %d) did not give g back", inv, o)
+		}
+	}
+}
+
+func TestFile_Undecodable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.jpg")
+	if err := os.WriteFile(path, []byte("not a jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := File(path, DHash); err == nil {
+		t.Error("File() on a broken JPEG: want an error")
+	}
+}
+
+func TestFile_ZeroSize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scene(16, 16, 1), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Zero the height in the SOF0 header: the decoder accepts it and returns
+	// an empty image.
+	data := buf.Bytes()
+	sof := bytes.Index(data, []byte{0xFF, 0xC0})
+	if sof < 0 {
+		t.Fatal("no SOF0 marker")
+	}
+	data[sof+5], data[sof+6] = 0, 0
+	path := filepath.Join(t.TempDir(), "empty.jpg")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := File(path, DHash); err == nil {
+		t.Error("File() on a 16x0 JPEG: want an error")
+	}
+	if _, err := Compute(image.NewGray(image.Rect(0, 0, 0, 8)), 1, PHash); err == nil {
+		t.Error("Compute() on a 0x8 image: want an error")
+	}
+}
+
+func TestCluster(t *testing.T) {
+	hashes := []Hash{
+		0x0000000000000000,
+		0xffffffffffffffff,
+		0x0000000000000007, // 3 from the first
+		0xfffffffffffffff0, // 4 from the second
+		0x000000000000003f, // 3 from the third, 6 from the first
+		0x00000000ffff0000,
+	}
+	got := Cluster(hashes, 4)
+	want := [][]int{{0, 2, 4}, {1, 3}}
+	if len(got) != len(want) {
+		t.Fatalf("Cluster() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			t.Fatalf("Cluster() = %v, want %v", got, want)
+		}
+		for j := range want[i] {
+			if got[i][j] != want[i][j] {
+				t.Fatalf("Cluster() = %v, want %v", got, want)
+			}
+		}
+	}
+	if got := Cluster(hashes, 0); len(got) != 0 {
+		t.Errorf("Cluster(0) = %v, want none", got)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, name := range []string{"dhash", "PHash"} {
+		if _, err := ParseAlgorithm(name); err != nil {
+			t.Errorf("ParseAlgorithm(%q) error: %v", name, err)
+		}
+	}
+	if _, err := ParseAlgorithm("ahash"); err == nil {
+		t.Error("ParseAlgorithm(ahash): want an error")
+	}
+}
@@ -0,0 +1,426 @@
+// Package layout renders library paths from templates, so the importer,
+// renamer and organiser can share one naming scheme.
+//
+// A template is literal text with fields in braces, optionally followed by
+// an argument after a colon:
+//
+//	{YYYY}/{MM}                                          2024/03
+//	{YYYY}/{YYYY}-{MM}-{DD}                              2024/2024-03-15
+//	{YYYY}/Q{Q}                                          2024/Q1
+//	{YYYY}-{MM}-{DD}-{hh}-{mm}-{name:upper}.{ext:upper}  2024-03-15-14-22-IMG_1234.JPG
+//
+// Date fields: YYYY, YY, MM, MMM (Jan), DD, hh, mm, ss, Q (quarter), and
+// sub (subseconds; {sub:6} for microseconds, default 3 digits).
+// Other fields:
+//
+//	name    original basename, non-alphanumerics replaced with _
+//	ext     original extension, without the dot
+//	camera  camera model, sanitized like name; "unknown" when not recorded
+//	seq     per-day sequence number; {seq:3} sets the width (default 4)
+//	hash    content hash prefix; {hash:12} sets the length (default 8)
+//	rand    random letters and digits; {rand:6} sets the length (default 4)
+//
+// name, ext and camera take "upper" or "lower" as their argument.
+package layout
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The library layouts the tools use unless configured otherwise.
+const (
+	DefaultDir   = "{YYYY}/{MM}"
+	DefaultName  = "{YYYY}-{MM}-{DD}-{hh}-{mm}-{name:upper}.{ext:upper}"
+	RenamerName  = "{YYYY}-{MM}-{DD}-{hh}-{mm}-{ss}-{rand:4}.{ext}"
+	OrganiserDir = "{MM}"
+)
+
+// Field names that callers may need to check with Uses before rendering,
+// because they cost something to fill in.
+const (
+	FieldCamera = "camera"
+	FieldSeq    = "seq"
+	FieldHash   = "hash"
+)
+
+// Fields are the values a template is rendered from.
+type Fields struct {
+	Time   time.Time
+	Name   string // original basename, without extension
+	Ext    string // original extension, without the dot
+	Camera string // camera model
+	Seq    int    // per-day sequence number, from 1
+	Hash   string // hex content hash
+}
+
+// Template is a parsed path template. The zero value is not usable; build one
+// with ParseDir or ParseName.
+type Template struct {
+	src   string
+	parts []part
+	re    *regexp.Regexp // matches rendered strings; see Match
+}
+
+type part struct {
+	lit   string // literal text, when field == ""
+	field string
+	arg   string
+	width int // parsed numeric argument, or the field's default
+}
+
+// fieldSpec describes one field: its default numeric argument (0 when it
+// takes none), the strings it accepts otherwise, and what it matches when a
+// rendered name is parsed back.
+type fieldSpec struct {
+	width   int
+	args    []string
+	pattern func(width int) string
+}
+
+func fixed(n int) func(int) string { return func(int) string { return fmt.Sprintf(`\d{%d}`, n) } }
+
+var fieldSpecs = map[string]fieldSpec{
+	"YYYY":   {pattern: fixed(4)},
+	"YY":     {pattern: fixed(2)},
+	"MM":     {pattern: fixed(2)},
+	"MMM":    {pattern: func(int) string { return `[A-Z][a-z]{2}` }},
+	"DD":     {pattern: fixed(2)},
+	"hh":     {pattern: fixed(2)},
+	"mm":     {pattern: fixed(2)},
+	"ss":     {pattern: fixed(2)},
+	"Q":      {pattern: fixed(1)},
+	"sub":    {width: 3, pattern: func(w int) string { return fmt.Sprintf(`\d{%d}`, w) }},
+	"name":   {args: []string{"upper", "lower"}, pattern: func(int) string { return `.+?` }},
+	"ext":    {args: []string{"upper", "lower"}, pattern: func(int) string { return `[A-Za-z0-9]+` }},
+	"camera": {args: []string{"upper", "lower"}, pattern: func(int) string { return `.+?` }},
+	"seq":    {width: 4, pattern: func(w int) string { return fmt.Sprintf(`\d{%d,}`, w) }},
+	"hash":   {width: 8, pattern: func(w int) string { return fmt.Sprintf(`[0-9a-f]{%d}`, w) }},
+	"rand":   {width: 4, pattern: func(w int) string { return fmt.Sprintf(`[A-Za-z0-9]{%d}`, w) }},
+}
+
+var fieldPattern = regexp.MustCompile(`\{([A-Za-z]+)(?::([A-Za-z0-9]+))?\}`)
+
+func parse(src string) (*Template, error) {
+	t := &Template{src: src}
+	last := 0
+	for _, m := range fieldPattern.FindAllStringSubmatchIndex(src, -1) {
+		if m[0] > last {
+			t.parts = append(t.parts, part{lit: src[last:m[0]]})
+		}
+		last = m[1]
+		p := part{field: src[m[2]:m[3]]}
+		if m[4] >= 0 {
+			p.arg = src[m[4]:m[5]]
+		}
+		spec, ok := fieldSpecs[p.field]
+		if !ok {
+			return nil, fmt.Errorf("template %q: unknown field {%s}", src, p.field)
+		}
+		p.width = spec.width
+		if p.arg != "" {
+			switch {
+			case spec.width > 0:
+				n, err := strconv.Atoi(p.arg)
+				if err != nil || n < 1 || n > 64 {
+					return nil, fmt.Errorf("template %q: {%s:%s} wants a length from 1 to 64", src, p.field, p.arg)
+				}
+				p.width = n
+			case slices.Contains(spec.args, p.arg):
+			default:
+				return nil, fmt.Errorf("template %q: {%s} does not take %q", src, p.field, p.arg)
+			}
+		}
+		t.parts = append(t.parts, p)
+	}
+	if last < len(src) {
+		t.parts = append(t.parts, part{lit: src[last:]})
+	}
+	var re strings.Builder
+	re.WriteString("^")
+	for _, p := range t.parts {
+		if p.field == "" {
+			if strings.ContainsAny(p.lit, "{}") {
+				return nil, fmt.Errorf("template %q: unbalanced brace in %q", src, p.lit)
+			}
+			re.WriteString(regexp.QuoteMeta(p.lit))
+			continue
+		}
+		re.WriteString("(" + fieldSpecs[p.field].pattern(p.width) + ")")
+	}
+	re.WriteString("$")
+	t.re = regexp.MustCompile(re.String())
+	return t, nil
+}
+
+// ParseDir parses a directory template, relative to the library root. Use /
+// between folders. An empty template puts files directly in the root.
+func ParseDir(src string) (*Template, error) {
+	t, err := parse(strings.Trim(src, "/"))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range t.parts {
+		for _, seg := range strings.Split(p.lit, "/") {
+			if seg == ".." {
+				return nil, fmt.Errorf("directory template %q must stay inside the library", src)
+			}
+		}
+	}
+	return t, nil
+}
+
+// ParseName parses a filename template. It must end with .{ext}, so that
+// companions and sidecars can share a rendered name with their own extension.
+func ParseName(src string) (*Template, error) {
+	t, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	n := len(t.parts)
+	if n < 2 || t.parts[n-1].field != "ext" || !strings.HasSuffix(t.parts[n-2].lit, ".") {
+		return nil, fmt.Errorf("filename template %q must end with .{ext}", src)
+	}
+	for _, p := range t.parts {
+		if strings.ContainsRune(p.lit, '/') {
+			return nil, fmt.Errorf("filename template %q must not contain /", src)
+		}
+	}
+	return t, nil
+}
+
+// MustParseDir is ParseDir for templates known to be valid.
+func MustParseDir(src string) *Template {
+	t, err := ParseDir(src)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// MustParseName is ParseName for templates known to be valid.
+func MustParseName(src string) *Template {
+	t, err := ParseName(src)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the template's source.
+func (t *Template) String() string { return t.src }
+
+// Uses reports whether the template contains field.
+func (t *Template) Uses(field string) bool {
+	for _, p := range t.parts {
+		if p.field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Render fills in the template.
+func (t *Template) Render(f Fields) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.field == "" {
+			b.WriteString(p.lit)
+			continue
+		}
+		b.WriteString(p.render(f))
+	}
+	return b.String()
+}
+
+// Ext renders ext the way the template's trailing {ext} would, so a sidecar
+// named after its primary gets a matching extension.
+func (t *Template) Ext(ext string) string {
+	for i := len(t.parts) - 1; i >= 0; i-- {
+		if p := t.parts[i]; p.field == "ext" {
+			return p.render(Fields{Ext: ext})
+		}
+	}
+	return ext
+}
+
+func (p part) render(f Fields) string {
+	ts := f.Time
+	switch p.field {
+	case "YYYY":
+		return fmt.Sprintf("%04d", ts.Year())
+	case "YY":
+		return fmt.Sprintf("%02d", ts.Year()%100)
+	case "MM":
+		return fmt.Sprintf("%02d", int(ts.Month()))
+	case "MMM":
+		return ts.Month().String()[:3]
+	case "DD":
+		return fmt.Sprintf("%02d", ts.Day())
+	case "hh":
+		return fmt.Sprintf("%02d", ts.Hour())
+	case "mm":
+		return fmt.Sprintf("%02d", ts.Minute())
+	case "ss":
+		return fmt.Sprintf("%02d", ts.Second())
+	case "Q":
+		return strconv.Itoa((int(ts.Month())-1)/3 + 1)
+	case "sub":
+		frac := fmt.Sprintf("%09d", ts.Nanosecond())
+		if p.width <= 9 {
+			return frac[:p.width]
+		}
+		return frac + strings.Repeat("0", p.width-9)
+	case "name":
+		return setCase(Sanitize(f.Name), p.arg)
+	case "ext":
+		return setCase(f.Ext, p.arg)
+	case "camera":
+		camera := Sanitize(f.Camera)
+		if camera == "" {
+			camera = "unknown"
+		}
+		return setCase(camera, p.arg)
+	case "seq":
+		return fmt.Sprintf("%0*d", p.width, f.Seq)
+	case "hash":
+		if len(f.Hash) <= p.width {
+			return f.Hash
+		}
+		return f.Hash[:p.width]
+	case "rand":
+		return randomString(p.width)
+	}
+	return ""
+}
+
+// Match parses a rendered string back into the date fields, name and ext it
+// was rendered from. Fields the template does not contain are left zero; the
+// time is in loc. ok is false when s was not rendered from t.
+func (t *Template) Match(s string, loc *time.Location) (f Fields, ok bool) {
+	m := t.re.FindStringSubmatch(s)
+	if m == nil {
+		return Fields{}, false
+	}
+
+	year, month, day, hour, minute, second, nsec := 0, 1, 1, 0, 0, 0, 0
+	i := 1
+	for _, p := range t.parts {
+		if p.field == "" {
+			continue
+		}
+		v := m[i]
+		i++
+		n, _ := strconv.Atoi(v)
+		switch p.field {
+		case "YYYY":
+			year = n
+		case "YY":
+			if year == 0 {
+				year = 2000 + n
+			}
+		case "MM":
+			month = n
+		case "MMM":
+			for mo := time.January; mo <= time.December; mo++ {
+				if mo.String()[:3] == v {
+					month = int(mo)
+				}
+			}
+		case "DD":
+			day = n
+		case "hh":
+			hour = n
+		case "mm":
+			minute = n
+		case "ss":
+			second = n
+		case "sub":
+			nsec, _ = strconv.Atoi((v + strings.Repeat("0", 9))[:9])
+		case "name":
+			f.Name = v
+		case "ext":
+			f.Ext = v
+		case "camera":
+			f.Camera = v
+		case "seq":
+			f.Seq = n
+		case "hash":
+			f.Hash = v
+		}
+	}
+	if year != 0 {
+		if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || second > 59 {
+			return Fields{}, false
+		}
+		f.Time = time.Date(year, time.Month(month), day, hour, minute, second, nsec, loc)
+	}
+	return f, true
+}
+
+// Layout is where a file goes: a directory template under the library root
+// and a filename template.
+type Layout struct {
+	Dir  *Template
+	Name *Template
+}
+
+// Default returns the importer's layout: DefaultDir and DefaultName.
+func Default() Layout {
+	return Layout{Dir: MustParseDir(DefaultDir), Name: MustParseName(DefaultName)}
+}
+
+// Render returns the file's directory, relative to the library root with /
+// separators, and its filename.
+func (l Layout) Render(f Fields) (dir, name string) {
+	return path.Clean("/" + l.Dir.Render(f))[1:], l.Name.Render(f)
+}
+
+// Uses reports whether either template contains field.
+func (l Layout) Uses(field string) bool {
+	return l.Dir.Uses(field) || l.Name.Uses(field)
+}
+
+// Sanitize replaces runs of characters other than letters and digits with a
+// single _, and trims _ from both ends.
+func Sanitize(s string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore {
+			b.WriteRune('_')
+			underscore = true
+		}
+	}
+	return strings.Trim(b.String(), "_")
+}
+
+func setCase(s, mode string) string {
+	switch mode {
+	case "upper":
+		return strings.ToUpper(s)
+	case "lower":
+		return strings.ToLower(s)
+	}
+	return s
+}
+
+const randomLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = randomLetters[rand.Intn(len(randomLetters))]
+	}
+	return string(b)
+}
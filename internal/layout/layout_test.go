@@ -0,0 +1,140 @@
+package layout
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	f := Fields{
+		Time:   time.Date(2024, 3, 15, 14, 22, 33, 123456789, time.UTC),
+		Name:   "IMG 1234 (copy)",
+		Ext:    "jpg",
+		Camera: "iPhone 15 Pro",
+		Seq:    7,
+		Hash:   "9f86d081884c7d659a2feaa0c55ad015",
+	}
+	cases := []struct {
+		tmpl string
+		want string
+	}{
+		{DefaultName, "2024-03-15-14-22-IMG_1234_COPY.JPG"},
+		{"{YYYY}{MM}{DD}_{hh}{mm}{ss}_{sub}.{ext}", "20240315_142233_123.jpg"},
+		{"{YY}-{MMM}-{DD}_{sub:6}.{ext:upper}", "24-Mar-15_123456.JPG"},
+		{"{YYYY}-{MM}-{DD}-{seq}-{camera}.{ext}", "2024-03-15-0007-iPhone_15_Pro.jpg"},
+		{"{YYYY}-{MM}-{DD}-{seq:2}-{camera:lower}.{ext}", "2024-03-15-07-iphone_15_pro.jpg"},
+		{"{hash}.{ext}", "9f86d081.jpg"},
+		{"{hash:12}-{name:lower}.{ext}", "9f86d081884c-img_1234_copy.jpg"},
+	}
+	for _, c := range cases {
+		tmpl, err := ParseName(c.tmpl)
+		if err != nil {
+			t.Errorf("ParseName(%q) error: %v", c.tmpl, err)
+			continue
+		}
+		if got := tmpl.Render(f); got != c.want {
+			t.Errorf("%q.Render() = %q, want %q", c.tmpl, got, c.want)
+		}
+	}
+
+	tmpl := MustParseName("{name}-{camera}.{ext}")
+	if got := tmpl.Render(Fields{Name: "a", Ext: "jpg"}); got != "a-unknown.jpg" {
+		t.Errorf("missing camera rendered as %q", got)
+	}
+	rnd := MustParseName(RenamerName).Render(f)
+	if !strings.HasPrefix(rnd, "2024-03-15-14-22-33-") || len(rnd) != len("2024-03-15-14-22-33-xxxx.jpg") {
+		t.Errorf("RenamerName rendered as %q", rnd)
+	}
+}
+
+func TestLayoutRender(t *testing.T) {
+	f := Fields{Time: time.Date(2024, 8, 5, 9, 0, 0, 0, time.UTC), Name: "IMG_1", Ext: "JPG"}
+	cases := []struct {
+		dir  string
+		want string
+	}{
+		{DefaultDir, "2024/08"},
+		{"{YYYY}/{YYYY}-{MM}-{DD}/", "2024/2024-08-05"},
+		{"/{YYYY}/Q{Q}", "2024/Q3"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		dir, err := ParseDir(c.dir)
+		if err != nil {
+			t.Errorf("ParseDir(%q) error: %v", c.dir, err)
+			continue
+		}
+		l := Layout{Dir: dir, Name: MustParseName(DefaultName)}
+		gotDir, gotName := l.Render(f)
+		if gotDir != c.want || gotName != "2024-08-05-09-00-IMG_1.JPG" {
+			t.Errorf("Layout{%q}.Render() = %q, %q", c.dir, gotDir, gotName)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		"{YYYY}-{nope}.{ext}",
+		"{YYYY}-{name:title}.{ext}",
+		"{seq:x}.{ext}",
+		"{YYYY}-{MM}",
+		"{YYYY}.{ext}-x",
+		"{YYYY}/{MM}.{ext}",
+		"{YYYY.{ext}",
+	} {
+		if _, err := ParseName(src); err == nil {
+			t.Errorf("ParseName(%q) succeeded, want error", src)
+		}
+	}
+	for _, src := range []string{"{YYYY}/../{MM}", "{bogus}"} {
+		if _, err := ParseDir(src); err == nil {
+			t.Errorf("ParseDir(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	f, ok := MustParseName(DefaultName).Match("2024-03-15-14-22-IMG_1234.ARW.XMP", time.UTC)
+	if !ok {
+		t.Fatal("Match failed")
+	}
+	if !f.Time.Equal(time.Date(2024, 3, 15, 14, 22, 0, 0, time.UTC)) || f.Name != "IMG_1234.ARW" || f.Ext != "XMP" {
+		t.Errorf("Match() = %+v", f)
+	}
+
+	f, ok = MustParseName(RenamerName).Match("2018-07-19-13-18-05-s8fx.JPG", time.UTC)
+	if !ok || !f.Time.Equal(time.Date(2018, 7, 19, 13, 18, 5, 0, time.UTC)) {
+		t.Errorf("Match() = %+v, %v", f, ok)
+	}
+
+	for _, s := range []string{"IMG_1234.JPG", "2024-13-15-14-22-IMG.JPG", "2024-03-15-14-22-.JPG"} {
+		if _, ok := MustParseName(DefaultName).Match(s, time.UTC); ok {
+			t.Errorf("Match(%q) succeeded, want no match", s)
+		}
+	}
+}
+
+func TestExt(t *testing.T) {
+	if got := MustParseName(DefaultName).Ext("xmp"); got != "XMP" {
+		t.Errorf("Ext() = %q, want XMP", got)
+	}
+	if got := MustParseName("{name}.{ext}").Ext("Xmp"); got != "Xmp" {
+		t.Errorf("Ext() = %q, want Xmp", got)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"IMG_1234", "IMG_1234"},
+		{"my photo", "my_photo"},
+		{"a--b  c", "a_b_c"},
+		{"__x__", "x"},
+		{"café", "café"},
+	}
+	for _, c := range cases {
+		if got := Sanitize(c.in); got != c.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// appleMakerNoteHeader opens the MakerNote iPhones write: the signature, a
+// version word and a big-endian byte-order mark, followed by a TIFF IFD whose
+// offsets are relative to the start of the MakerNote.
+const appleMakerNoteHeader = "Apple iOS\x00"
+
+// appleContentIDTag is the MakerNote tag holding the ContentIdentifier UUID
+// that links the two halves of a Live Photo.
+const appleContentIDTag = 0x0011
+
+// appleContentIDKey is the QuickTime metadata key carrying the same UUID in
+// the Live Photo's MOV.
+const appleContentIDKey = "com.apple.quicktime.content.identifier"
+
+// AppleContentID returns the Live Photo ContentIdentifier from an iPhone
+// photo's MakerNote, or "" when there is none.
+func AppleContentID(x *exif.Exif) string {
+	tag, err := x.Get(exif.MakerNote)
+	if err != nil || !bytes.HasPrefix(tag.Val, []byte(appleMakerNoteHeader)) {
+		return ""
+	}
+	const ifdStart = len(appleMakerNoteHeader) + 4 // version + "MM"
+	if len(tag.Val) < ifdStart {
+		return ""
+	}
+	r := bytes.NewReader(tag.Val)
+	if _, err := r.Seek(int64(ifdStart), io.SeekStart); err != nil {
+		return ""
+	}
+	dir, _, err := tiff.DecodeDir(r, binary.BigEndian)
+	if err != nil {
+		return ""
+	}
+	for _, t := range dir.Tags {
+		if t.Id != appleContentIDTag {
+			continue
+		}
+		if s, err := t.StringVal(); err == nil {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+// Package media reads capture metadata (dates, camera details) out of the
+// photo and video containers the tools import, without shelling out.
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoDate is returned when a container was parsed successfully but does not
+// carry a usable capture date.
+var ErrNoDate = errors.New("no capture date in container")
+
+// box is one ISO-BMFF / QuickTime atom: a size, a four-character type and a payload.
+type box struct {
+	Type   string
+	Offset int64 // offset of the box header in the file
+	Start  int64 // offset of the payload (after the header)
+	End    int64 // offset one past the last payload byte
+}
+
+func (b box) Size() int64 { return b.End - b.Start }
+
+// readBoxes lists the boxes laid out back to back between start and end.
+func readBoxes(r io.ReaderAt, start, end int64) ([]box, error) {
+	var out []box
+	var hdr [16]byte
+	for off := start; off+8 <= end; {
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return out, fmt.Errorf("reading box header at %d: %w", off, err)
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		typ := string(hdr[4:8])
+		headerLen := int64(8)
+		switch size {
+		case 0: // box extends to the end of its parent
+			size = end - off
+		case 1: // 64-bit largesize follows the type
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return out, fmt.Errorf("reading largesize of %q: %w", typ, err)
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			headerLen = 16
+		}
+		if size < headerLen || off+size > end {
+			return out, fmt.Errorf("box %q at %d has invalid size %d", typ, off, size)
+		}
+		out = append(out, box{Type: typ, Offset: off, Start: off + headerLen, End: off + size})
+		off += size
+	}
+	return out, nil
+}
+
+// children lists the boxes nested inside b, skipping skip bytes of b's own
+// payload first (4 for a "full box" with version and flags).
+func children(r io.ReaderAt, b box, skip int64) ([]box, error) {
+	return readBoxes(r, b.Start+skip, b.End)
+}
+
+// findBox returns the first box of the given type, or false.
+func findBox(boxes []box, typ string) (box, bool) {
+	for _, b := range boxes {
+		if b.Type == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// readPayload reads the whole payload of b into memory. limit guards
+// against reading huge boxes (e.g. mdat) by mistake.
+func readPayload(r io.ReaderAt, b box, limit int64) ([]byte, error) {
+	if b.Size() > limit {
+		return nil, fmt.Errorf("box %q is too large (%d bytes)", b.Type, b.Size())
+	}
+	buf := make([]byte, b.Size())
+	if _, err := r.ReadAt(buf, b.Start); err != nil {
+		return nil, fmt.Errorf("reading box %q: %w", b.Type, err)
+	}
+	return buf, nil
+}
@@ -0,0 +1,32 @@
+package media
+
+import (
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Camera identifies the body that took a photo.
+type Camera struct {
+	Make   string
+	Model  string
+	Serial string // EXIF BodySerialNumber; empty when the camera does not record it
+}
+
+// CameraOf reads Make, Model and BodySerialNumber. Missing fields are empty.
+func CameraOf(x *exif.Exif) Camera {
+	return Camera{
+		Make:   exifString(x, exif.Make),
+		Model:  exifString(x, exif.Model),
+		Serial: exifString(x, BodySerialNumber),
+	}
+}
+
+// String renders the camera as "Make Model (#serial)", for reports.
+func (c Camera) String() string {
+	s := strings.TrimSpace(c.Make + " " + c.Model)
+	if c.Serial != "" {
+		s += " (#" + c.Serial + ")"
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+package media
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/mknote"
+)
+
+func init() {
+	exif.RegisterParsers(mknote.All...)
+	exif.RegisterParsers(extraFieldsParser{})
+}
+
+// DecodeExif reads the EXIF block of a photo. JPEG and TIFF-based files
+// (including DNG, CR2, NEF and ARW RAWs) are handed to goexif directly.
+// HEIF/HEIC files have their Exif item extracted from the container first,
+// since goexif does not understand ISO-BMFF; Fujifilm RAF files are read
+// through their embedded JPEG, and Olympus ORF files have their non-standard
+// TIFF magic patched.
+func DecodeExif(path string) (*exif.Exif, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if IsHEIF(f) {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		tiff, err := HEIFExif(f, fi.Size())
+		if err != nil {
+			return nil, err
+		}
+		return exif.Decode(bytes.NewReader(tiff))
+	}
+	if isRAF(f) {
+		jpeg, err := rafJPEG(f)
+		if err != nil {
+			return nil, err
+		}
+		return exif.Decode(jpeg)
+	}
+	r, err := orfTIFF(f)
+	if err != nil {
+		return nil, err
+	}
+	return exif.Decode(r)
+}
+
+// Orientation returns the EXIF Orientation, 1 to 8: how the stored pixels
+// must be flipped and rotated to display the photo upright. It is 1 (as
+// stored) when the tag is missing or out of range.
+func Orientation(x *exif.Exif) int {
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	o, err := tag.Int(0)
+	if err != nil || o < 1 || o > 8 {
+		return 1
+	}
+	return o
+}
+
+// Dimensions returns the photo's PixelXDimension and PixelYDimension turned
+// upright by its Orientation, or 0, 0 when either is missing.
+func Dimensions(x *exif.Exif) (width, height int) {
+	dim := func(name exif.FieldName) int {
+		tag, err := x.Get(name)
+		if err != nil {
+			return 0
+		}
+		v, err := tag.Int(0)
+		if err != nil || v < 0 {
+			return 0
+		}
+		return v
+	}
+	width, height = dim(exif.PixelXDimension), dim(exif.PixelYDimension)
+	if width == 0 || height == 0 {
+		return 0, 0
+	}
+	if Orientation(x) >= 5 {
+		width, height = height, width
+	}
+	return width, height
+}
@@ -0,0 +1,158 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// EXIF 2.3x fields that goexif does not load on its own. extraFieldsParser
+// reads them from the Exif sub-IFD so they can be fetched with Exif.Get.
+const (
+	OffsetTime          exif.FieldName = "OffsetTime"
+	OffsetTimeOriginal  exif.FieldName = "OffsetTimeOriginal"
+	OffsetTimeDigitized exif.FieldName = "OffsetTimeDigitized"
+	BodySerialNumber    exif.FieldName = "BodySerialNumber"
+)
+
+var extraExifFields = map[uint16]exif.FieldName{
+	0x9010: OffsetTime,
+	0x9011: OffsetTimeOriginal,
+	0x9012: OffsetTimeDigitized,
+	0xA431: BodySerialNumber,
+}
+
+// extraFieldsParser is an exif.Parser that loads extraExifFields.
+type extraFieldsParser struct{}
+
+func (extraFieldsParser) Parse(x *exif.Exif) error {
+	ptr, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := ptr.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, 0); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(dir, extraExifFields, false)
+	return nil
+}
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// ExifCaptureTime returns when a photo was taken: DateTimeOriginal, falling
+// back to DateTime, with SubSecTimeOriginal folded in when present.
+//
+// When the file records the UTC offset of that time (OffsetTimeOriginal, or
+// OffsetTime alongside DateTime), the result is expressed in that offset and
+// hasOffset is true. Otherwise the wall-clock time is interpreted in naive,
+// which should be the zone the camera's clock was set to.
+func ExifCaptureTime(x *exif.Exif, naive *time.Location) (t time.Time, hasOffset bool, err error) {
+	dateField, offsetField := exif.DateTimeOriginal, OffsetTimeOriginal
+	tag, err := x.Get(dateField)
+	if err != nil {
+		dateField, offsetField = exif.DateTime, OffsetTime
+		if tag, err = x.Get(dateField); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%s not in string format", dateField)
+	}
+	value = strings.TrimRight(value, "\x00 ")
+
+	loc := naive
+	if s := exifString(x, offsetField); s != "" {
+		if zone, err := ParseZone(s); err == nil {
+			loc, hasOffset = zone, true
+		}
+	}
+	t, err = time.ParseInLocation(exifTimeLayout, value, loc)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if dateField == exif.DateTimeOriginal {
+		if sub := exifString(x, exif.SubSecTimeOriginal); sub != "" {
+			if frac, err := strconv.ParseFloat("0."+sub, 64); err == nil {
+				t = t.Add(time.Duration(frac * float64(time.Second)))
+			}
+		}
+	}
+	return t, hasOffset, nil
+}
+
+// HasSubSecTime reports whether ExifCaptureTime's result carries the
+// fraction of a second from SubSecTimeOriginal, rather than whole seconds.
+func HasSubSecTime(x *exif.Exif) bool {
+	if _, err := x.Get(exif.DateTimeOriginal); err != nil {
+		return false
+	}
+	sub := exifString(x, exif.SubSecTimeOriginal)
+	_, err := strconv.ParseFloat("0."+sub, 64)
+	return sub != "" && err == nil
+}
+
+// exifString returns a string field trimmed of padding, or "" when absent.
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(s, "\x00 ")
+}
+
+var fixedOffsetPattern = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})$`)
+
+// ParseZone parses a time zone given on the command line or in a file:
+// an IANA name ("Australia/Sydney"), "UTC", "Local", or a fixed offset
+// ("+10:00", "-0530"). An empty string returns nil, meaning "as shot".
+func ParseZone(s string) (*time.Location, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "":
+		return nil, nil
+	case "local":
+		return time.Local, nil
+	case "utc", "z":
+		return time.UTC, nil
+	}
+	if m := fixedOffsetPattern.FindStringSubmatch(s); m != nil {
+		h, _ := strconv.Atoi(m[2])
+		min, _ := strconv.Atoi(m[3])
+		secs := h*3600 + min*60
+		if m[1] == "-" {
+			secs = -secs
+		}
+		return time.FixedZone(fmt.Sprintf("%s%s:%s", m[1], m[2], m[3]), secs), nil
+	}
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", s)
+	}
+	return loc, nil
+}
+
+// FormatOffset renders t's UTC offset as "+10:00".
+func FormatOffset(t time.Time) string {
+	return t.Format("-07:00")
+}
@@ -0,0 +1,160 @@
+package media
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/mediatest"
+)
+
+func TestExifCaptureTime(t *testing.T) {
+	t.Run("offset tag is honoured", func(t *testing.T) {
+		x, err := DecodeExif(heicFixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, hasOffset, err := ExifCaptureTime(x, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasOffset {
+			t.Error("hasOffset = false, want true for OffsetTimeOriginal +10:00")
+		}
+		if got.Format("2006-01-02 15:04:05.000 -07:00") != "2021-06-12 09:41:27.123 +10:00" {
+			t.Errorf("ExifCaptureTime() = %v", got)
+		}
+		if !HasSubSecTime(x) {
+			t.Error("HasSubSecTime() = false, want true for SubSecTimeOriginal 123")
+		}
+	})
+
+	t.Run("naive time uses the given zone", func(t *testing.T) {
+		tiff := mediatest.TIFF(nil, []mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: "2023:09:30 17:05:44"}})
+		x, err := DecodeExif(writeTemp(t, "a.jpg", mediatest.JPEG(tiff)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		berlin, err := time.LoadLocation("Europe/Berlin")
+		if err != nil {
+			t.Skip("no tzdata:", err)
+		}
+		got, hasOffset, err := ExifCaptureTime(x, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasOffset || got.Location() != berlin || got.Hour() != 17 {
+			t.Errorf("ExifCaptureTime() = %v (hasOffset %v), want 17:05 in Berlin", got, hasOffset)
+		}
+		if HasSubSecTime(x) {
+			t.Error("HasSubSecTime() = true without SubSecTimeOriginal")
+		}
+	})
+
+	t.Run("DateTime fallback uses OffsetTime", func(t *testing.T) {
+		tiff := mediatest.TIFF(
+			[]mediatest.Tag{{ID: mediatest.TagDateTime, Value: "2023:09:30 17:05:44"}},
+			[]mediatest.Tag{{ID: 0x9010, Value: "-05:00"}},
+		)
+		x, err := DecodeExif(writeTemp(t, "b.jpg", mediatest.JPEG(tiff)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, hasOffset, err := ExifCaptureTime(x, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, off := got.Zone(); !hasOffset || off != -5*3600 {
+			t.Errorf("ExifCaptureTime() = %v, want -05:00", got)
+		}
+	})
+}
+
+func TestParseZone(t *testing.T) {
+	cases := []struct {
+		in     string
+		offset int // seconds east of UTC at 2024-01-01
+		isNil  bool
+	}{
+		{in: "", isNil: true},
+		{in: "UTC", offset: 0},
+		{in: "+10:00", offset: 10 * 3600},
+		{in: "-0530", offset: -(5*3600 + 30*60)},
+		{in: "Asia/Tokyo", offset: 9 * 3600},
+	}
+	for _, c := range cases {
+		loc, err := ParseZone(c.in)
+		if err != nil {
+			t.Errorf("ParseZone(%q) error: %v", c.in, err)
+			continue
+		}
+		if c.isNil {
+			if loc != nil {
+				t.Errorf("ParseZone(%q) = %v, want nil", c.in, loc)
+			}
+			continue
+		}
+		if _, off := time.Date(2024, 1, 1, 0, 0, 0, 0, loc).Zone(); off != c.offset {
+			t.Errorf("ParseZone(%q) offset = %d, want %d", c.in, off, c.offset)
+		}
+	}
+	if _, err := ParseZone("Mars/Olympus_Mons"); err == nil {
+		t.Error("expected error for unknown zone")
+	}
+}
+
+func TestCameraOf(t *testing.T) {
+	tiff := mediatest.TIFF(
+		[]mediatest.Tag{{ID: mediatest.TagMake, Value: "FUJIFILM"}, {ID: mediatest.TagModel, Value: "X-T4"}},
+		[]mediatest.Tag{
+			{ID: mediatest.TagDateTimeOriginal, Value: "2023:09:30 17:05:44"},
+			{ID: mediatest.TagBodySerialNumber, Value: "12345678"},
+		},
+	)
+	x, err := DecodeExif(writeTemp(t, "c.jpg", mediatest.JPEG(tiff)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cam := CameraOf(x)
+	want := Camera{Make: "FUJIFILM", Model: "X-T4", Serial: "12345678"}
+	if cam != want {
+		t.Errorf("CameraOf() = %+v, want %+v", cam, want)
+	}
+	if got := cam.String(); got != "FUJIFILM X-T4 (#12345678)" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestDimensions(t *testing.T) {
+	for _, tc := range []struct {
+		orientation   uint16
+		width, height int
+	}{
+		{0, 4032, 3024}, // no tag: as stored
+		{1, 4032, 3024},
+		{6, 3024, 4032}, // stored on its side
+	} {
+		var ifd0 []mediatest.Tag
+		if tc.orientation != 0 {
+			ifd0 = append(ifd0, mediatest.Tag{ID: mediatest.TagOrientation, Short: tc.orientation})
+		}
+		tiff := mediatest.TIFF(ifd0, []mediatest.Tag{
+			{ID: mediatest.TagPixelXDimension, Short: 4032},
+			{ID: mediatest.TagPixelYDimension, Short: 3024},
+		})
+		x, err := DecodeExif(writeTemp(t, "d.jpg", mediatest.JPEG(tiff)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, h := Dimensions(x); w != tc.width || h != tc.height {
+			t.Errorf("orientation %d: Dimensions() = %dx%d, want %dx%d", tc.orientation, w, h, tc.width, tc.height)
+		}
+	}
+
+	x, err := DecodeExif(writeTemp(t, "e.jpg", mediatest.JPEG(mediatest.TIFF(nil, []mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: "2023:09:30 17:05:44"}}))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := Dimensions(x); w != 0 || h != 0 {
+		t.Errorf("Dimensions() = %dx%d without the tags, want 0x0", w, h)
+	}
+}
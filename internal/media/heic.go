@@ -0,0 +1,270 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoExif is returned when a HEIF file has no Exif item.
+var ErrNoExif = errors.New("no Exif item in HEIF container")
+
+// heifBrands are the ftyp major/compatible brands that mark a HEIF still image.
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"hevc": true, "hevx": true, "mif1": true, "msf1": true, "avif": true,
+}
+
+// itemExtent is one contiguous run of an item's data.
+type itemExtent struct {
+	offset, length int64
+}
+
+// itemLocation is an iloc entry: where an item's bytes live.
+type itemLocation struct {
+	constructionMethod uint8 // 0 = file offset, 1 = offset into idat
+	extents            []itemExtent
+}
+
+// IsHEIF reports whether r starts with an ftyp box naming a HEIF brand.
+func IsHEIF(r io.ReaderAt) bool {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil || string(hdr[4:8]) != "ftyp" {
+		return false
+	}
+	size := int64(binary.BigEndian.Uint32(hdr[:4]))
+	if size < 16 || size > 4096 {
+		return false
+	}
+	buf := make([]byte, size-8)
+	if _, err := r.ReadAt(buf, 8); err != nil {
+		return false
+	}
+	// major brand, minor version, then compatible brands
+	if heifBrands[string(buf[0:4])] {
+		return true
+	}
+	for i := 8; i+4 <= len(buf); i += 4 {
+		if heifBrands[string(buf[i:i+4])] {
+			return true
+		}
+	}
+	return false
+}
+
+// HEIFExif returns the TIFF-formatted EXIF block stored in a HEIF/HEIC file.
+// It finds the item of type "Exif" through meta/iinf, locates its bytes via
+// meta/iloc and strips the Exif item header, so the result can be handed
+// straight to exif.Decode.
+func HEIFExif(r io.ReaderAt, size int64) ([]byte, error) {
+	top, err := readBoxes(r, 0, size)
+	if err != nil && len(top) == 0 {
+		return nil, err
+	}
+	meta, ok := findBox(top, "meta")
+	if !ok {
+		return nil, fmt.Errorf("no meta box")
+	}
+	inner, err := children(r, meta, 4)
+	if err != nil {
+		return nil, fmt.Errorf("reading meta: %w", err)
+	}
+
+	iinf, ok := findBox(inner, "iinf")
+	if !ok {
+		return nil, fmt.Errorf("no iinf box")
+	}
+	exifID, err := findItemByType(r, iinf, "Exif")
+	if err != nil {
+		return nil, err
+	}
+
+	ilocBox, ok := findBox(inner, "iloc")
+	if !ok {
+		return nil, fmt.Errorf("no iloc box")
+	}
+	ilocPayload, err := readPayload(r, ilocBox, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	locations, err := parseIloc(ilocPayload)
+	if err != nil {
+		return nil, err
+	}
+	loc, ok := locations[exifID]
+	if !ok {
+		return nil, fmt.Errorf("Exif item %d has no location", exifID)
+	}
+
+	var base int64
+	if loc.constructionMethod == 1 {
+		idat, ok := findBox(inner, "idat")
+		if !ok {
+			return nil, fmt.Errorf("Exif item stored in missing idat box")
+		}
+		base = idat.Start
+	} else if loc.constructionMethod != 0 {
+		return nil, fmt.Errorf("unsupported iloc construction method %d", loc.constructionMethod)
+	}
+
+	var data []byte
+	for _, ext := range loc.extents {
+		if ext.length <= 0 || ext.length > 16<<20 {
+			return nil, fmt.Errorf("Exif extent has invalid length %d", ext.length)
+		}
+		buf := make([]byte, ext.length)
+		if _, err := r.ReadAt(buf, base+ext.offset); err != nil {
+			return nil, fmt.Errorf("reading Exif item: %w", err)
+		}
+		data = append(data, buf...)
+	}
+
+	// The item starts with a 4-byte offset to the TIFF header, which normally
+	// skips an "Exif\0\0" marker.
+	if len(data) < 4 {
+		return nil, fmt.Errorf("Exif item too short")
+	}
+	tiffStart := 4 + int(binary.BigEndian.Uint32(data[:4]))
+	if tiffStart > len(data) {
+		return nil, fmt.Errorf("Exif item has invalid TIFF header offset")
+	}
+	tiff := data[tiffStart:]
+	// Some writers leave the marker in place and set the offset to zero.
+	tiff = bytes.TrimPrefix(tiff, []byte("Exif\x00\x00"))
+	return tiff, nil
+}
+
+// findItemByType returns the item_ID of the first infe entry with the given type.
+func findItemByType(r io.ReaderAt, iinf box, itemType string) (uint32, error) {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], iinf.Start); err != nil {
+		return 0, err
+	}
+	skip := int64(4 + 2) // version/flags + 16-bit entry_count
+	if hdr[0] != 0 {
+		skip = 4 + 4 // 32-bit entry_count
+	}
+	entries, err := children(r, iinf, skip)
+	if err != nil {
+		return 0, fmt.Errorf("reading iinf: %w", err)
+	}
+	for _, infe := range entries {
+		if infe.Type != "infe" {
+			continue
+		}
+		p, err := readPayload(r, infe, 1<<16)
+		if err != nil {
+			return 0, err
+		}
+		id, typ, ok := parseInfe(p)
+		if ok && typ == itemType {
+			return id, nil
+		}
+	}
+	return 0, ErrNoExif
+}
+
+// parseInfe decodes item_ID and item_type from an infe payload (versions 2 and 3).
+func parseInfe(p []byte) (id uint32, itemType string, ok bool) {
+	if len(p) < 4 {
+		return 0, "", false
+	}
+	switch p[0] {
+	case 2:
+		if len(p) < 12 {
+			return 0, "", false
+		}
+		return uint32(binary.BigEndian.Uint16(p[4:6])), string(p[8:12]), true
+	case 3:
+		if len(p) < 14 {
+			return 0, "", false
+		}
+		return binary.BigEndian.Uint32(p[4:8]), string(p[10:14]), true
+	}
+	// Versions 0 and 1 predate typed items.
+	return 0, "", false
+}
+
+// parseIloc decodes an iloc payload into item_ID → location.
+func parseIloc(p []byte) (map[uint32]itemLocation, error) {
+	rd := &byteReader{buf: p}
+	version := rd.uint(1)
+	rd.skip(3) // flags
+	sizes := rd.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0xF)
+	sizes = rd.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), int(sizes&0xF)
+	if version == 0 {
+		indexSize = 0
+	}
+
+	var count uint64
+	if version < 2 {
+		count = rd.uint(2)
+	} else {
+		count = rd.uint(4)
+	}
+
+	out := make(map[uint32]itemLocation)
+	for i := uint64(0); i < count && rd.err == nil; i++ {
+		var id uint64
+		if version < 2 {
+			id = rd.uint(2)
+		} else {
+			id = rd.uint(4)
+		}
+		var loc itemLocation
+		if version == 1 || version == 2 {
+			loc.constructionMethod = uint8(rd.uint(2) & 0xF)
+		}
+		rd.skip(2) // data_reference_index
+		base := int64(rd.uint(baseOffsetSize))
+		extents := rd.uint(2)
+		for e := uint64(0); e < extents && rd.err == nil; e++ {
+			rd.skip(indexSize)
+			off := int64(rd.uint(offsetSize))
+			length := int64(rd.uint(lengthSize))
+			loc.extents = append(loc.extents, itemExtent{offset: base + off, length: length})
+		}
+		out[uint32(id)] = loc
+	}
+	if rd.err != nil {
+		return nil, fmt.Errorf("malformed iloc box: %w", rd.err)
+	}
+	return out, nil
+}
+
+// byteReader reads big-endian fields of variable width, remembering the first error.
+type byteReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (b *byteReader) uint(n int) uint64 {
+	if b.err != nil {
+		return 0
+	}
+	if n == 0 {
+		return 0
+	}
+	if b.pos+n > len(b.buf) {
+		b.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	var v uint64
+	for _, c := range b.buf[b.pos : b.pos+n] {
+		v = v<<8 | uint64(c)
+	}
+	b.pos += n
+	return v
+}
+
+func (b *byteReader) skip(n int) {
+	if b.err == nil && b.pos+n > len(b.buf) {
+		b.err = io.ErrUnexpectedEOF
+	}
+	b.pos += n
+}
@@ -0,0 +1,101 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cemeng/photos-organiser/internal/mediatest"
+)
+
+// heicFixture is a synthetic iPhone HEIC holding an Exif item with
+// DateTimeOriginal 2021:06:12 09:41:27, shared with the renamer and importer tests.
+const heicFixture = "../../cmd/renamer/iphone-sample.heic"
+
+func TestDecodeExif_HEICFixture(t *testing.T) {
+	x, err := DecodeExif(heicFixture)
+	if err != nil {
+		t.Fatalf("DecodeExif() error: %v", err)
+	}
+	got, err := x.DateTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2021-06-12 09:41:27"; got.Format("2006-01-02 15:04:05") != want {
+		t.Errorf("DateTime() = %v, want %s", got, want)
+	}
+	model, err := x.Get("Model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := model.StringVal(); s != "iPhone 12" {
+		t.Errorf("Model = %q, want iPhone 12", s)
+	}
+}
+
+func TestHEIFExif(t *testing.T) {
+	tiff := mediatest.TIFF(nil, []mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: "2020:01:02 03:04:05"}})
+
+	for _, useIdat := range []bool{false, true} {
+		data := mediatest.HEIC(tiff, useIdat)
+		r := bytes.NewReader(data)
+		if !IsHEIF(r) {
+			t.Fatalf("IsHEIF() = false for HEIC (idat=%v)", useIdat)
+		}
+		got, err := HEIFExif(r, int64(len(data)))
+		if err != nil {
+			t.Fatalf("HEIFExif(idat=%v) error: %v", useIdat, err)
+		}
+		if !bytes.Equal(got, tiff) {
+			t.Errorf("HEIFExif(idat=%v) returned %d bytes, want the %d-byte TIFF block", useIdat, len(got), len(tiff))
+		}
+	}
+}
+
+func TestHEIFExif_NoExifItem(t *testing.T) {
+	ftyp := mediatest.Atom("ftyp", []byte("heic"), mediatest.U32(0))
+	infe := mediatest.Atom("infe", []byte{2, 0, 0, 0}, mediatest.U16(1), mediatest.U16(0), []byte("hvc1"), []byte{0})
+	meta := mediatest.Atom("meta", mediatest.U32(0), mediatest.Atom("iinf", mediatest.U32(0), mediatest.U16(1), infe))
+	data := append(ftyp, meta...)
+
+	if _, err := HEIFExif(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrNoExif) {
+		t.Errorf("err = %v, want ErrNoExif", err)
+	}
+}
+
+func TestIsHEIF_JPEG(t *testing.T) {
+	f, err := os.Open("../../cmd/renamer/gopher-stand.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if IsHEIF(f) {
+		t.Error("IsHEIF() = true for a JPEG")
+	}
+}
+
+func TestAppleContentID(t *testing.T) {
+	tiff := mediatest.TIFF(
+		[]mediatest.Tag{{ID: mediatest.TagMake, Value: "Apple"}},
+		[]mediatest.Tag{
+			{ID: mediatest.TagDateTimeOriginal, Value: "2024:03:15 14:22:33"},
+			{ID: mediatest.TagMakerNote, Raw: mediatest.AppleMakerNote("8A3F-LIVE")},
+		},
+	)
+	x, err := DecodeExif(writeTemp(t, "IMG_1234.HEIC", mediatest.HEIC(tiff, false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := AppleContentID(x); got != "8A3F-LIVE" {
+		t.Errorf("AppleContentID() = %q, want 8A3F-LIVE", got)
+	}
+
+	x, err = DecodeExif(heicFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := AppleContentID(x); got != "" {
+		t.Errorf("AppleContentID() = %q for a photo without a MakerNote", got)
+	}
+}
@@ -0,0 +1,132 @@
+package media
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DatePattern recognises a capture date in a filename. Re must name its
+// groups: year, month and day are required; hour, minute, second, frac
+// (fractional seconds, any number of digits) and ampm ("AM"/"PM", for
+// 12-hour clocks) are optional.
+type DatePattern struct {
+	Name string
+	Re   *regexp.Regexp
+	// UTC is set when the app writes the name in UTC rather than local
+	// time (Pixel's PXL_ names).
+	UTC bool
+}
+
+// DefaultDatePatterns covers apps that strip EXIF but keep the date in the name.
+var DefaultDatePatterns = []DatePattern{
+	{Name: "pixel", UTC: true,
+		Re: regexp.MustCompile(`^PXL_(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})_(?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2})(?P<frac>\d{3})`)},
+	{Name: "whatsapp",
+		Re: regexp.MustCompile(`^(?:IMG|VID|AUD|PTT|STK)-(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})-WA\d+`)},
+	{Name: "whatsapp",
+		Re: regexp.MustCompile(`^WhatsApp (?:Image|Video) (?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2}) at (?P<hour>\d{1,2})\.(?P<minute>\d{2})\.(?P<second>\d{2})(?: (?P<ampm>[AP]M))?`)},
+	{Name: "signal",
+		Re: regexp.MustCompile(`^signal-(?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2})-(?P<hour>\d{2})-?(?P<minute>\d{2})-?(?P<second>\d{2})(?:-(?P<frac>\d{3}))?`)},
+	{Name: "screenshot",
+		Re: regexp.MustCompile(`^Screenshot_(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})[-_](?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2})`)},
+	{Name: "screenshot",
+		Re: regexp.MustCompile(`^Screen ?[Ss]hot (?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2}) at (?P<hour>\d{1,2})\.(?P<minute>\d{2})\.(?P<second>\d{2})(?:[\s\x{202F}](?P<ampm>[AP]M))?`)},
+	{Name: "android",
+		Re: regexp.MustCompile(`^(?:IMG|VID|PANO|MVIMG|BURST)_(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})_(?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2})`)},
+}
+
+// ParseDatePattern compiles a user-supplied filename pattern, checking that it
+// names the year, month and day groups DateFromName needs.
+func ParseDatePattern(expr string) (DatePattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return DatePattern{}, fmt.Errorf("date pattern %q: %w", expr, err)
+	}
+	for _, group := range []string{"year", "month", "day"} {
+		if re.SubexpIndex(group) < 0 {
+			return DatePattern{}, fmt.Errorf("date pattern %q has no (?P<%s>...) group", expr, group)
+		}
+	}
+	return DatePattern{Name: "custom", Re: re}, nil
+}
+
+// DateFromName returns the date in a filename (with or without extension),
+// trying patterns in order. Times without a UTC flag are wall-clock time in
+// naive.
+func DateFromName(name string, patterns []DatePattern, naive *time.Location) (time.Time, DatePattern, bool) {
+	for _, p := range patterns {
+		m := p.Re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		loc := naive
+		if p.UTC {
+			loc = time.UTC
+		}
+		if t, ok := dateFromMatch(p.Re, m, loc); ok {
+			return t, p, true
+		}
+	}
+	return time.Time{}, DatePattern{}, false
+}
+
+// pathDatePattern matches a YYYY-MM-DD date in a folder name ("2024-03-15 Trip")
+// or spread over folders ("2024/03/15").
+var pathDatePattern = regexp.MustCompile(`(?:^|\D)(?P<year>(?:19|20)\d{2})[-_./](?P<month>\d{2})[-_./](?P<day>\d{2})(?:\D|$)`)
+
+// DateFromPath returns the innermost YYYY-MM-DD date in dir's folder names,
+// at midnight in naive.
+func DateFromPath(dir string, naive *time.Location) (time.Time, bool) {
+	dir = filepath.ToSlash(dir)
+	all := pathDatePattern.FindAllStringSubmatch(dir, -1)
+	for i := len(all) - 1; i >= 0; i-- {
+		if t, ok := dateFromMatch(pathDatePattern, all[i], naive); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// dateFromMatch builds a time from a match's named groups, rejecting
+// out-of-range values rather than letting time.Date normalise them.
+func dateFromMatch(re *regexp.Regexp, m []string, loc *time.Location) (time.Time, bool) {
+	field := func(name string) int {
+		i := re.SubexpIndex(name)
+		if i < 0 || m[i] == "" {
+			return 0
+		}
+		n, err := strconv.Atoi(m[i])
+		if err != nil {
+			return -1
+		}
+		return n
+	}
+	year, month, day := field("year"), field("month"), field("day")
+	hour, minute, second := field("hour"), field("minute"), field("second")
+	if year < 1900 || month < 1 || month > 12 || day < 1 || hour < 0 || hour > 23 || minute < 0 || minute > 59 || second < 0 || second > 59 {
+		return time.Time{}, false
+	}
+	if i := re.SubexpIndex("ampm"); i >= 0 && m[i] != "" {
+		if hour < 1 || hour > 12 {
+			return time.Time{}, false
+		}
+		hour %= 12
+		if strings.EqualFold(m[i], "PM") {
+			hour += 12
+		}
+	}
+	var nsec int
+	if i := re.SubexpIndex("frac"); i >= 0 && m[i] != "" {
+		frac := (m[i] + strings.Repeat("0", 9))[:9]
+		nsec, _ = strconv.Atoi(frac)
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, nsec, loc)
+	if t.Day() != day {
+		return time.Time{}, false // e.g. 31 February
+	}
+	return t, true
+}
@@ -0,0 +1,82 @@
+package media
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateFromName(t *testing.T) {
+	local := time.Local
+	cases := []struct {
+		name    string
+		want    time.Time
+		pattern string
+	}{
+		{"IMG-20240315-WA0001", time.Date(2024, 3, 15, 0, 0, 0, 0, local), "whatsapp"},
+		{"WhatsApp Image 2024-03-15 at 9.05.12 PM", time.Date(2024, 3, 15, 21, 5, 12, 0, local), "whatsapp"},
+		{"WhatsApp Image 2024-03-15 at 12.05.12 AM", time.Date(2024, 3, 15, 0, 5, 12, 0, local), "whatsapp"},
+		{"WhatsApp Video 2024-03-15 at 21.05.12", time.Date(2024, 3, 15, 21, 5, 12, 0, local), "whatsapp"},
+		{"PXL_20240315_142233123", time.Date(2024, 3, 15, 14, 22, 33, 123e6, time.UTC), "pixel"},
+		{"PXL_20240315_142233123.MP", time.Date(2024, 3, 15, 14, 22, 33, 123e6, time.UTC), "pixel"},
+		{"Screenshot_20240315-142233", time.Date(2024, 3, 15, 14, 22, 33, 0, local), "screenshot"},
+		{"Screenshot 2024-03-15 at 14.22.33", time.Date(2024, 3, 15, 14, 22, 33, 0, local), "screenshot"},
+		{"Screenshot 2024-03-15 at 2.22.33\u202fPM", time.Date(2024, 3, 15, 14, 22, 33, 0, local), "screenshot"},
+		{"signal-2024-03-15-14-22-33-123", time.Date(2024, 3, 15, 14, 22, 33, 123e6, local), "signal"},
+		{"signal-2024-03-15-142233", time.Date(2024, 3, 15, 14, 22, 33, 0, local), "signal"},
+		{"IMG_20240315_142233", time.Date(2024, 3, 15, 14, 22, 33, 0, local), "android"},
+	}
+	for _, c := range cases {
+		got, p, ok := DateFromName(c.name, DefaultDatePatterns, local)
+		if !ok {
+			t.Errorf("DateFromName(%q) found no date", c.name)
+			continue
+		}
+		if !got.Equal(c.want) || p.Name != c.pattern {
+			t.Errorf("DateFromName(%q) = %v via %s, want %v via %s", c.name, got, p.Name, c.want, c.pattern)
+		}
+	}
+
+	for _, name := range []string{"IMG_1234", "IMG-20241315-WA0001", "IMG-20240231-WA0001", "DSC_20240315"} {
+		if got, _, ok := DateFromName(name, DefaultDatePatterns, local); ok {
+			t.Errorf("DateFromName(%q) = %v, want no date", name, got)
+		}
+	}
+}
+
+func TestParseDatePattern(t *testing.T) {
+	p, err := ParseDatePattern(`^scan_(?P<day>\d{2})(?P<month>\d{2})(?P<year>\d{4})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, ok := DateFromName("scan_15032024_001", []DatePattern{p}, time.UTC)
+	if !ok || !got.Equal(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("custom pattern = %v, %v", got, ok)
+	}
+
+	if _, err := ParseDatePattern(`^scan_(\d{8})`); err == nil {
+		t.Error("expected error for a pattern without named groups")
+	}
+	if _, err := ParseDatePattern(`(`); err == nil {
+		t.Error("expected error for an invalid regexp")
+	}
+}
+
+func TestDateFromPath(t *testing.T) {
+	cases := []struct {
+		dir  string
+		want time.Time
+		ok   bool
+	}{
+		{"Trips/2024-03-15 Tasmania", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), true},
+		{"2023/07/04/party", time.Date(2023, 7, 4, 0, 0, 0, 0, time.UTC), true},
+		{"2023-01-01 New Year/2023-01-02 Day after", time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), true},
+		{"Pictures/2017", time.Time{}, false},
+		{"DCIM/100APPLE", time.Time{}, false},
+	}
+	for _, c := range cases {
+		got, ok := DateFromPath(c.dir, time.UTC)
+		if ok != c.ok || !got.Equal(c.want) {
+			t.Errorf("DateFromPath(%q) = %v, %v; want %v, %v", c.dir, got, ok, c.want, c.ok)
+		}
+	}
+}
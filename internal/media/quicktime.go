@@ -0,0 +1,246 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// appleCreationDateKey is the metadata key iPhones write with the local
+// capture time, including its UTC offset.
+const appleCreationDateKey = "com.apple.quicktime.creationdate"
+
+// quickTimeEpoch is the zero point of mvhd timestamps: 1904-01-01 00:00:00 UTC.
+var quickTimeEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// creationDateLayouts are the ISO 8601 variants seen in the Apple creationdate key.
+var creationDateLayouts = []string{
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05.000-0700",
+	"2006-01-02T15:04:05.000Z07:00",
+}
+
+// VideoInfo is the capture metadata read from a MOV, MP4 or 3GP file.
+type VideoInfo struct {
+	// CreationTime is the capture time, or zero when the container has none.
+	CreationTime time.Time
+	// HasOffset is true when CreationTime carries the UTC offset where the
+	// clip was shot (Apple creationdate), rather than the local zone.
+	HasOffset bool
+	// ContentIdentifier is the Apple Live Photo UUID shared with the still
+	// image, or "" when absent.
+	ContentIdentifier string
+}
+
+// VideoCreationTime returns the capture time stored in a MOV, MP4 or 3GP file.
+//
+// Apple's com.apple.quicktime.creationdate key is preferred when present: it
+// records the wall-clock time and UTC offset where the clip was shot, so it is
+// returned in that offset, matching how EXIF dates are read for photos.
+// Otherwise the movie header (moov/mvhd) creation time is used. QuickTime
+// stores that in UTC, so it is converted to the local zone.
+//
+// ErrNoDate is returned when the container has neither.
+func VideoCreationTime(path string) (time.Time, error) {
+	info, err := ReadVideo(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if info.CreationTime.IsZero() {
+		return time.Time{}, ErrNoDate
+	}
+	return info.CreationTime, nil
+}
+
+// ReadVideo reads the capture metadata of a MOV, MP4 or 3GP file. See
+// VideoCreationTime for how the creation time is chosen. A container without
+// a moov atom is an error wrapping ErrNoDate.
+func ReadVideo(path string) (VideoInfo, error) {
+	var info VideoInfo
+
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return info, err
+	}
+
+	top, err := readBoxes(f, 0, fi.Size())
+	if err != nil && len(top) == 0 {
+		return info, err
+	}
+	moov, ok := findBox(top, "moov")
+	if !ok {
+		return info, fmt.Errorf("no moov atom: %w", ErrNoDate)
+	}
+	atoms, err := children(f, moov, 0)
+	if err != nil {
+		return info, fmt.Errorf("reading moov: %w", err)
+	}
+
+	items := appleMetadata(f, atoms)
+	info.ContentIdentifier = strings.TrimSpace(items[appleContentIDKey])
+	if value, ok := items[appleCreationDateKey]; ok {
+		for _, layout := range creationDateLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+				info.CreationTime, info.HasOffset = t, true
+				return info, nil
+			}
+		}
+	}
+
+	mvhd, ok := findBox(atoms, "mvhd")
+	if !ok {
+		return info, nil
+	}
+	payload, err := readPayload(f, mvhd, 1<<10)
+	if err != nil {
+		return info, err
+	}
+	if t, err := parseMvhdCreationTime(payload); err == nil {
+		info.CreationTime = t.In(time.Local)
+	}
+	return info, nil
+}
+
+// parseMvhdCreationTime decodes the creation time field of an mvhd payload.
+func parseMvhdCreationTime(p []byte) (time.Time, error) {
+	if len(p) < 8 {
+		return time.Time{}, fmt.Errorf("mvhd too short")
+	}
+	var secs uint64
+	switch p[0] {
+	case 0:
+		secs = uint64(binary.BigEndian.Uint32(p[4:8]))
+	case 1:
+		if len(p) < 12 {
+			return time.Time{}, fmt.Errorf("mvhd too short")
+		}
+		secs = binary.BigEndian.Uint64(p[4:12])
+	default:
+		return time.Time{}, fmt.Errorf("unknown mvhd version %d", p[0])
+	}
+	// Many encoders leave the field zeroed rather than writing a real date.
+	if secs == 0 {
+		return time.Time{}, fmt.Errorf("mvhd creation time not set: %w", ErrNoDate)
+	}
+	return quickTimeEpoch.Add(time.Duration(secs) * time.Second), nil
+}
+
+// appleMetadata merges the string items of moov/meta and moov/udta/meta.
+func appleMetadata(f *os.File, moov []box) map[string]string {
+	var metas []box
+	if meta, ok := findBox(moov, "meta"); ok {
+		metas = append(metas, meta)
+	}
+	if udta, ok := findBox(moov, "udta"); ok {
+		if inner, err := children(f, udta, 0); err == nil {
+			if meta, ok := findBox(inner, "meta"); ok {
+				metas = append(metas, meta)
+			}
+		}
+	}
+
+	out := make(map[string]string)
+	for _, meta := range metas {
+		items, err := metadataItems(f, meta)
+		if err != nil {
+			continue
+		}
+		for k, v := range items {
+			if _, ok := out[k]; !ok {
+				out[k] = v
+			}
+		}
+	}
+	return out
+}
+
+// metadataItems decodes the keys/ilst pair of a QuickTime metadata box into
+// key → UTF-8 string value. Non-string values are ignored.
+func metadataItems(f *os.File, meta box) (map[string]string, error) {
+	// QuickTime writes meta as a plain container; ISO MP4 writes it as a full
+	// box with 4 bytes of version and flags. Those bytes are always zero,
+	// whereas a plain container starts with a non-zero child size.
+	var first [4]byte
+	if _, err := f.ReadAt(first[:], meta.Start); err != nil {
+		return nil, err
+	}
+	skip := int64(0)
+	if binary.BigEndian.Uint32(first[:]) == 0 {
+		skip = 4
+	}
+	inner, err := children(f, meta, skip)
+	if err != nil {
+		return nil, err
+	}
+
+	keysBox, ok := findBox(inner, "keys")
+	if !ok {
+		return nil, fmt.Errorf("no keys atom")
+	}
+	ilst, ok := findBox(inner, "ilst")
+	if !ok {
+		return nil, fmt.Errorf("no ilst atom")
+	}
+
+	kp, err := readPayload(f, keysBox, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	if len(kp) < 8 {
+		return nil, fmt.Errorf("keys atom too short")
+	}
+	// The count comes straight from the file; each key takes at least 8
+	// bytes, so never reserve more than the payload can hold.
+	count := binary.BigEndian.Uint32(kp[4:8])
+	if limit := uint32(len(kp) / 8); count > limit {
+		count = limit
+	}
+	keys := make([]string, 0, count)
+	for off := 8; uint32(len(keys)) < count && off+8 <= len(kp); {
+		size := int(binary.BigEndian.Uint32(kp[off : off+4]))
+		if size < 8 || off+size > len(kp) {
+			return nil, fmt.Errorf("malformed keys atom")
+		}
+		keys = append(keys, string(kp[off+8:off+size]))
+		off += size
+	}
+
+	entries, err := children(f, ilst, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for _, entry := range entries {
+		idx := binary.BigEndian.Uint32([]byte(entry.Type))
+		if idx == 0 || int(idx) > len(keys) {
+			continue
+		}
+		values, err := children(f, entry, 0)
+		if err != nil {
+			continue
+		}
+		data, ok := findBox(values, "data")
+		if !ok {
+			continue
+		}
+		dp, err := readPayload(f, data, 1<<16)
+		if err != nil || len(dp) < 8 {
+			continue
+		}
+		// Type indicator 1 is UTF-8 text.
+		if binary.BigEndian.Uint32(dp[0:4])&0xFFFFFF != 1 {
+			continue
+		}
+		out[keys[idx-1]] = string(dp[8:])
+	}
+	return out, nil
+}
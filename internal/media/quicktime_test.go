@@ -0,0 +1,118 @@
+package media
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cemeng/photos-organiser/internal/mediatest"
+)
+
+func writeTemp(t *testing.T, name string, parts ...[]byte) string {
+	t.Helper()
+	var data []byte
+	for _, p := range parts {
+		data = append(data, p...)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVideoCreationTime(t *testing.T) {
+	shot := time.Date(2024, 3, 15, 3, 22, 33, 0, time.UTC)
+
+	t.Run("mvhd is UTC and converted to local time", func(t *testing.T) {
+		path := writeTemp(t, "a.mov", mediatest.Movie(shot))
+		got, err := VideoCreationTime(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(shot) || got.Location() != time.Local {
+			t.Errorf("VideoCreationTime() = %v, want %v in local zone", got, shot.In(time.Local))
+		}
+	})
+
+	t.Run("apple creationdate wins and keeps its offset", func(t *testing.T) {
+		meta := mediatest.AppleMeta(map[string]string{
+			"com.apple.quicktime.make":  "Apple",
+			appleCreationDateKey:        "2024-03-15T14:22:33+1100",
+			"com.apple.quicktime.model": "iPhone 12",
+		})
+		path := writeTemp(t, "b.mov", mediatest.Movie(shot.Add(time.Hour), meta))
+		got, err := VideoCreationTime(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Hour() != 14 || got.Minute() != 22 || !got.Equal(shot) {
+			t.Errorf("VideoCreationTime() = %v, want 14:22 +1100", got)
+		}
+		if _, off := got.Zone(); off != 11*3600 {
+			t.Errorf("offset = %d, want +11h", off)
+		}
+	})
+
+	t.Run("zero mvhd is no date", func(t *testing.T) {
+		path := writeTemp(t, "c.mp4", mediatest.Movie(time.Time{}))
+		if _, err := VideoCreationTime(path); !errors.Is(err, ErrNoDate) {
+			t.Errorf("err = %v, want ErrNoDate", err)
+		}
+	})
+
+	t.Run("missing moov is no date", func(t *testing.T) {
+		ftyp := mediatest.Atom("ftyp", []byte("isom"), mediatest.U32(0))
+		path := writeTemp(t, "d.mp4", ftyp, mediatest.Atom("mdat", make([]byte, 64)))
+		if _, err := VideoCreationTime(path); !errors.Is(err, ErrNoDate) {
+			t.Errorf("err = %v, want ErrNoDate", err)
+		}
+	})
+
+	t.Run("oversized keys count falls back to mvhd", func(t *testing.T) {
+		keys := mediatest.Atom("keys", mediatest.U32(0), mediatest.U32(0xFFFFFFF0))
+		meta := mediatest.Atom("meta", mediatest.Atom("hdlr", make([]byte, 24)), keys, mediatest.Atom("ilst"))
+		path := writeTemp(t, "f.mov", mediatest.Movie(shot, meta))
+		got, err := VideoCreationTime(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(shot) {
+			t.Errorf("VideoCreationTime() = %v, want %v", got, shot)
+		}
+	})
+
+	t.Run("truncated keys atom falls back to mvhd", func(t *testing.T) {
+		key := mediatest.Atom("mdta", []byte(appleCreationDateKey))
+		keys := mediatest.Atom("keys", mediatest.U32(0), mediatest.U32(3), key[:len(key)-4])
+		meta := mediatest.Atom("meta", mediatest.Atom("hdlr", make([]byte, 24)), keys, mediatest.Atom("ilst"))
+		path := writeTemp(t, "g.mov", mediatest.Movie(shot, meta))
+		got, err := VideoCreationTime(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(shot) {
+			t.Errorf("VideoCreationTime() = %v, want %v", got, shot)
+		}
+	})
+
+	t.Run("not a container", func(t *testing.T) {
+		path := writeTemp(t, "e.mov", []byte("hello"))
+		if _, err := VideoCreationTime(path); err == nil {
+			t.Error("expected error for a non-QuickTime file")
+		}
+	})
+}
+
+func TestReadVideo_ContentIdentifier(t *testing.T) {
+	meta := mediatest.AppleMeta(map[string]string{appleContentIDKey: "8A3F-LIVE"})
+	info, err := ReadVideo(writeTemp(t, "IMG_1234.MOV", mediatest.Movie(time.Date(2024, 3, 15, 3, 22, 33, 0, time.UTC), meta)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentIdentifier != "8A3F-LIVE" {
+		t.Errorf("ContentIdentifier = %q, want 8A3F-LIVE", info.ContentIdentifier)
+	}
+}
@@ -0,0 +1,67 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// rafMagic opens every Fujifilm RAF file.
+const rafMagic = "FUJIFILMCCD-RAW "
+
+// RawExtensions are the camera RAW formats whose EXIF can be read. All but
+// RAF are TIFF containers; RAF wraps a JPEG preview that carries the EXIF.
+var RawExtensions = map[string]bool{
+	"dng": true, // Adobe / many phones
+	"cr2": true, // Canon
+	"nef": true, // Nikon
+	"arw": true, // Sony
+	"orf": true, // Olympus / OM System
+	"raf": true, // Fujifilm
+}
+
+// IsRaw reports whether ext (without the dot, any case) is a supported RAW format.
+func IsRaw(ext string) bool {
+	return RawExtensions[strings.ToLower(ext)]
+}
+
+// isRAF reports whether r starts with the Fujifilm RAF magic.
+func isRAF(r io.ReaderAt) bool {
+	hdr := make([]byte, len(rafMagic))
+	_, err := r.ReadAt(hdr, 0)
+	return err == nil && string(hdr) == rafMagic
+}
+
+// rafJPEG returns a reader over the JPEG preview embedded in a RAF file. Its
+// offset and length are big-endian words at bytes 84 and 88 of the header.
+func rafJPEG(r io.ReaderAt) (io.Reader, error) {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], 84); err != nil {
+		return nil, fmt.Errorf("reading RAF header: %w", err)
+	}
+	off := int64(binary.BigEndian.Uint32(hdr[0:4]))
+	length := int64(binary.BigEndian.Uint32(hdr[4:8]))
+	if off == 0 || length == 0 {
+		return nil, fmt.Errorf("RAF file has no embedded JPEG")
+	}
+	return io.NewSectionReader(r, off, length), nil
+}
+
+// orfTIFF returns r with an Olympus ORF TIFF magic rewritten to the standard
+// 42, so the regular TIFF decoder accepts it. ORF uses "IIRO"/"IIRS" (or
+// "MMOR") where TIFF has "II*\0" / "MM\0*". Other files pass through unchanged.
+func orfTIFF(r io.Reader) (io.Reader, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	switch string(hdr) {
+	case "IIRO", "IIRS":
+		hdr[2], hdr[3] = 0x2A, 0x00
+	case "MMOR":
+		hdr[2], hdr[3] = 0x00, 0x2A
+	}
+	return io.MultiReader(bytes.NewReader(hdr), r), nil
+}
@@ -0,0 +1,52 @@
+package media
+
+import (
+	"testing"
+
+	"github.com/cemeng/photos-organiser/internal/mediatest"
+)
+
+func TestDecodeExif_Raw(t *testing.T) {
+	tiff := mediatest.TIFF(
+		[]mediatest.Tag{{ID: mediatest.TagMake, Value: "SONY"}},
+		[]mediatest.Tag{{ID: mediatest.TagDateTimeOriginal, Value: "2023:09:30 17:05:44"}},
+	)
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"DSC0001.ARW", tiff},
+		{"IMG_0001.CR2", tiff},
+		{"P0001.ORF", mediatest.ORF(tiff)},
+		{"DSCF0001.RAF", mediatest.RAF(mediatest.JPEG(tiff))},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			x, err := DecodeExif(writeTemp(t, c.name, c.data))
+			if err != nil {
+				t.Fatalf("DecodeExif() error: %v", err)
+			}
+			got, err := x.DateTime()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "2023-09-30 17:05:44"; got.Format("2006-01-02 15:04:05") != want {
+				t.Errorf("DateTime() = %v, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestIsRaw(t *testing.T) {
+	for _, ext := range []string{"dng", "CR2", "Nef", "ARW", "orf", "RAF"} {
+		if !IsRaw(ext) {
+			t.Errorf("IsRaw(%q) = false", ext)
+		}
+	}
+	for _, ext := range []string{"jpg", "heic", "rw2", ""} {
+		if IsRaw(ext) {
+			t.Errorf("IsRaw(%q) = true", ext)
+		}
+	}
+}
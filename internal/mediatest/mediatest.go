@@ -0,0 +1,248 @@
+// Package mediatest builds small synthetic media files for tests: ISO-BMFF
+// atoms, TIFF/EXIF blocks, HEIC and QuickTime containers.
+package mediatest
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+)
+
+// Atom builds an ISO-BMFF box of the given type around the concatenated payloads.
+func Atom(typ string, payload ...[]byte) []byte {
+	var body []byte
+	for _, p := range payload {
+		body = append(body, p...)
+	}
+	out := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(out, uint32(8+len(body)))
+	copy(out[4:], typ)
+	return append(out, body...)
+}
+
+// U16 and U32 encode big-endian integers.
+func U16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func U32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// Movie returns a minimal MOV whose moov/mvhd records created (stored as UTC).
+// Extra atoms (e.g. from AppleMeta) are appended inside moov.
+func Movie(created time.Time, moovExtra ...[]byte) []byte {
+	secs := created.Sub(time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)) / time.Second
+	if created.IsZero() {
+		secs = 0
+	}
+	mvhd := Atom("mvhd", U32(0), U32(uint32(secs)), U32(uint32(secs)), U32(600), U32(0))
+	moov := Atom("moov", append([][]byte{mvhd}, moovExtra...)...)
+	ftyp := Atom("ftyp", []byte("qt  "), U32(0), []byte("qt  "))
+	return append(append(ftyp, moov...), Atom("mdat", make([]byte, 32))...)
+}
+
+// AppleMeta builds a QuickTime moov/meta box holding string keys.
+func AppleMeta(kv map[string]string) []byte {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var keyAtoms, items [][]byte
+	for i, k := range keys {
+		keyAtoms = append(keyAtoms, Atom("mdta", []byte(k)))
+		data := Atom("data", U32(1), U32(0), []byte(kv[k]))
+		items = append(items, Atom(string(U32(uint32(i+1))), data))
+	}
+	keysBox := Atom("keys", append([][]byte{U32(0), U32(uint32(len(keys)))}, keyAtoms...)...)
+	return Atom("meta", Atom("hdlr", make([]byte, 24)), keysBox, Atom("ilst", items...))
+}
+
+// Tag is one TIFF tag: an ASCII Value, an UNDEFINED-typed Raw blob when Raw
+// is set, or a SHORT when Short is set.
+type Tag struct {
+	ID    uint16
+	Value string
+	Raw   []byte
+	Short uint16
+}
+
+// Common tag IDs.
+const (
+	TagMake               = 0x010F
+	TagModel              = 0x0110
+	TagOrientation        = 0x0112
+	TagDateTime           = 0x0132
+	TagDateTimeOriginal   = 0x9003
+	TagOffsetTimeOriginal = 0x9011
+	TagSubSecTimeOriginal = 0x9291
+	TagMakerNote          = 0x927C
+	TagPixelXDimension    = 0xA002
+	TagPixelYDimension    = 0xA003
+	TagBodySerialNumber   = 0xA431
+	tagExifIFDPointer     = 0x8769
+)
+
+// TIFF returns a little-endian TIFF block with an IFD0 and, when exifTags is
+// non-empty, an Exif sub-IFD.
+func TIFF(ifd0, exifTags []Tag) []byte {
+	le := binary.LittleEndian
+	ifdLen := func(n int) int { return 2 + 12*n + 4 }
+
+	n0 := len(ifd0)
+	if len(exifTags) > 0 {
+		n0++
+	}
+	ifd0Off := 8
+	exifOff := ifd0Off + ifdLen(n0)
+	dataOff := exifOff
+	if len(exifTags) > 0 {
+		dataOff += ifdLen(len(exifTags))
+	}
+
+	out := make([]byte, dataOff)
+	copy(out, "II*\x00")
+	le.PutUint32(out[4:], uint32(ifd0Off))
+
+	writeIFD := func(at int, tags []Tag, withExif bool) {
+		tags = append([]Tag(nil), tags...)
+		sort.Slice(tags, func(i, j int) bool { return tags[i].ID < tags[j].ID })
+		count := len(tags)
+		if withExif {
+			count++
+		}
+		le.PutUint16(out[at:], uint16(count))
+		entry := at + 2
+		put := func(id, typ uint16, n, value uint32) {
+			le.PutUint16(out[entry:], id)
+			le.PutUint16(out[entry+2:], typ)
+			le.PutUint32(out[entry+4:], n)
+			le.PutUint32(out[entry+8:], value)
+			entry += 12
+		}
+		exifDone := !withExif
+		for _, tg := range tags {
+			if !exifDone && tg.ID > tagExifIFDPointer {
+				put(tagExifIFDPointer, 4, 1, uint32(exifOff))
+				exifDone = true
+			}
+			typ, val := uint16(2), append([]byte(tg.Value), 0)
+			switch {
+			case tg.Raw != nil:
+				typ, val = 7, tg.Raw
+			case tg.Short != 0:
+				typ, val = 3, binary.LittleEndian.AppendUint16(nil, tg.Short)
+			}
+			if len(val) <= 4 {
+				var inline [4]byte
+				copy(inline[:], val)
+				put(tg.ID, typ, uint32(len(val)), le.Uint32(inline[:]))
+				continue
+			}
+			put(tg.ID, typ, uint32(len(val)), uint32(len(out)))
+			out = append(out, val...)
+			if len(out)%2 == 1 {
+				out = append(out, 0)
+			}
+		}
+		if !exifDone {
+			put(tagExifIFDPointer, 4, 1, uint32(exifOff))
+		}
+		// next IFD offset stays zero
+	}
+	writeIFD(ifd0Off, ifd0, len(exifTags) > 0)
+	if len(exifTags) > 0 {
+		writeIFD(exifOff, exifTags, false)
+	}
+	return out
+}
+
+// AppleMakerNote returns an iPhone-style MakerNote holding a Live Photo
+// ContentIdentifier (tag 0x0011).
+func AppleMakerNote(contentID string) []byte {
+	const ifdStart = 14
+	value := append([]byte(contentID), 0)
+	out := append([]byte("Apple iOS\x00"), 0, 1, 'M', 'M')
+	out = append(out, U16(1)...)
+	out = append(out, U16(0x0011)...)
+	out = append(out, U16(2)...)
+	out = append(out, U32(uint32(len(value)))...)
+	out = append(out, U32(uint32(ifdStart+2+12+4))...)
+	out = append(out, U32(0)...)
+	return append(out, value...)
+}
+
+// HEIC wraps a TIFF block in a minimal HEIF container: a primary image item
+// with a few placeholder bytes and an Exif item describing it. When useIdat is
+// set the Exif item is stored in meta/idat (iloc construction method 1)
+// instead of mdat.
+func HEIC(tiff []byte, useIdat bool) []byte {
+	exifItem := append(append(U32(6), "Exif\x00\x00"...), tiff...)
+	image := []byte("hvc1-placeholder")
+
+	ftyp := Atom("ftyp", []byte("heic"), U32(0), []byte("mif1heic"))
+	hdlr := Atom("hdlr", U32(0), U32(0), []byte("pict"), make([]byte, 12), []byte{0})
+	pitm := Atom("pitm", U32(0), U16(1))
+	infe := func(id uint16, typ string) []byte {
+		return Atom("infe", []byte{2, 0, 0, 0}, U16(id), U16(0), []byte(typ), []byte{0})
+	}
+	iinf := Atom("iinf", U32(0), U16(2), infe(1, "hvc1"), infe(2, "Exif"))
+	iref := Atom("iref", U32(0), Atom("cdsc", U16(2), U16(1), U16(1)))
+
+	// iloc version 1, 4-byte offsets and lengths, no base offset. The meta box
+	// is built twice: once to learn its size, then with the real offsets.
+	buildMeta := func(imageOff, exifOff uint32, exifMethod uint16) []byte {
+		iloc := Atom("iloc", []byte{1, 0, 0, 0}, []byte{0x44, 0x00}, U16(2),
+			U16(1), U16(0), U16(0), U16(1), U32(imageOff), U32(uint32(len(image))),
+			U16(2), U16(exifMethod), U16(0), U16(1), U32(exifOff), U32(uint32(len(exifItem))))
+		parts := [][]byte{U32(0), hdlr, pitm, iinf, iref, iloc}
+		if exifMethod == 1 {
+			parts = append(parts, Atom("idat", exifItem))
+		}
+		return Atom("meta", parts...)
+	}
+
+	if useIdat {
+		meta := buildMeta(0, 0, 1)
+		imageOff := uint32(len(ftyp) + len(meta) + 8)
+		meta = buildMeta(imageOff, 0, 1)
+		return append(append(ftyp, meta...), Atom("mdat", image)...)
+	}
+	meta := buildMeta(0, 0, 0)
+	imageOff := uint32(len(ftyp) + len(meta) + 8)
+	meta = buildMeta(imageOff, imageOff+uint32(len(image)), 0)
+	return append(append(ftyp, meta...), Atom("mdat", image, exifItem)...)
+}
+
+// JPEG returns a minimal JPEG stream (SOI, APP1 Exif, EOI) carrying tiff.
+// It has no image data, which is enough for EXIF readers.
+func JPEG(tiff []byte) []byte {
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	out = append(out, U16(uint16(len(app1)+2))...)
+	out = append(out, app1...)
+	return append(out, 0xFF, 0xD9)
+}
+
+// WithExif inserts an APP1 Exif segment carrying tiff after the SOI marker
+// of a complete JPEG, e.g. one from image/jpeg.
+func WithExif(jpeg, tiff []byte) []byte {
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	out = append(out, U16(uint16(len(app1)+2))...)
+	out = append(out, app1...)
+	return append(out, jpeg[2:]...)
+}
+
+// RAF wraps a JPEG preview in a Fujifilm RAF header.
+func RAF(jpeg []byte) []byte {
+	hdr := make([]byte, 160)
+	copy(hdr, "FUJIFILMCCD-RAW 0201FF383501")
+	copy(hdr[28:], "X-T30")
+	binary.BigEndian.PutUint32(hdr[84:], uint32(len(hdr)))
+	binary.BigEndian.PutUint32(hdr[88:], uint32(len(jpeg)))
+	return append(hdr, jpeg...)
+}
+
+// ORF rewrites a little-endian TIFF block's magic to Olympus' "IIRO".
+func ORF(tiff []byte) []byte {
+	out := append([]byte(nil), tiff...)
+	copy(out, "IIRO")
+	return out
+}